@@ -0,0 +1,307 @@
+// catalog contains the descriptions of the functions offered by the ventilators.
+// A catalog maps the function numbers used on the serial bus to names, units, scales, valid ranges and access rights.
+package catalog
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// Access describes whether a function can be written
+type Access string
+
+const (
+	// ReadOnly is the Access of a function that can only be read
+	ReadOnly Access = "read-only"
+	// ReadWrite is the Access of a function that can be read and written
+	ReadWrite Access = "read-write"
+)
+
+var (
+	// UnknownFunctionError is the error returned when a function is not in the catalog
+	UnknownFunctionError = merry.Sentinel("The function is not in the catalog")
+	// ReadOnlyFunctionError is the error returned when writing a function that can only be read
+	ReadOnlyFunctionError = merry.Sentinel("The function is read-only")
+	// ValueOutOfRangeError is the error returned when a value is outside of the valid range of a function.
+	// It is the encoding.ValueOutOfRangeError.
+	ValueOutOfRangeError = encoding.ValueOutOfRangeError
+)
+
+// Function describes a single function of a ventilator
+type Function struct {
+	// Number is the number of the function used in frames
+	Number int `json:"number"`
+	// Name is the unique symbolic name of the function
+	Name string `json:"name"`
+	// Description is a human readable description of the function
+	Description string `json:"description,omitempty"`
+	// Unit is the unit of the value of the function after scaling (e.g. °C)
+	Unit string `json:"unit,omitempty"`
+	// Signed makes raw values above encoding.MAXIMUM_VALUE/2 negative (see encoding.ValueCodec)
+	Signed bool `json:"signed,omitempty"`
+	// Offset is subtracted from the (signed) raw value before scaling
+	Offset int `json:"offset,omitempty"`
+	// Scale is the factor the value is multiplied with to get the value in Unit.
+	// It defaults to 1.
+	Scale float64 `json:"scale,omitempty"`
+	// Minimum is the smallest valid value before scaling.
+	// If it is omitted, the smallest value representable by the raw value is the minimum.
+	Minimum *int `json:"minimum,omitempty"`
+	// Maximum is the largest valid value before scaling.
+	// If it is omitted, the largest value representable by the raw value is the maximum.
+	Maximum *int `json:"maximum,omitempty"`
+	// Access describes whether the function can be written
+	Access Access `json:"access"`
+}
+
+// Writable returns whether the function can be written
+func (function Function) Writable() bool {
+	return function.Access == ReadWrite
+}
+
+// Codec returns the codec converting the raw value of the function into the value in Unit
+func (function Function) Codec() encoding.ValueCodec {
+	return encoding.ValueCodec{
+		Signed:  function.Signed,
+		Offset:  function.Offset,
+		Scale:   function.Scale,
+		Minimum: function.Minimum,
+		Maximum: function.Maximum,
+		Unit:    function.Unit,
+	}
+}
+
+// validate checks the description of the function and sets the defaults of omitted fields
+func (function *Function) validate() error {
+	if function.Number < encoding.MINIMUM_FUNCTION || function.Number > encoding.MAXIMUM_FUNCTION {
+		return merry.Errorf("The number of function %s must be between %d and %d (inclusive). It was %d",
+			function.Name, encoding.MINIMUM_FUNCTION, encoding.MAXIMUM_FUNCTION, function.Number)
+	}
+	if function.Name == "" {
+		return merry.Errorf("Function %d must have a name", function.Number)
+	}
+	if function.Scale == 0 {
+		function.Scale = 1
+	}
+	if err := function.Codec().Validate(); err != nil {
+		return merry.Prependf(err, "Invalid value of function %s", function.Name)
+	}
+	if function.Access != ReadOnly && function.Access != ReadWrite {
+		return merry.Errorf("The access of function %s must be %s or %s. It was '%s'", function.Name, ReadOnly, ReadWrite, function.Access)
+	}
+	return nil
+}
+
+// checkValue returns ValueOutOfRangeError if the given raw value is outside the valid range of the function
+func (function Function) checkValue(value int) error {
+	if _, err := function.Codec().DecodeInt(value); err != nil {
+		return merry.Prependf(err, "Invalid value of function %s", function.Name)
+	}
+	return nil
+}
+
+// SplitFunction describes a value that is split across two functions
+type SplitFunction struct {
+	// Name is the unique symbolic name of the combined value
+	Name string `json:"name"`
+	// Description is a human readable description of the combined value
+	Description string `json:"description,omitempty"`
+	// High is the name of the function containing the high part
+	High string `json:"high"`
+	// Low is the name of the function containing the low part
+	Low string `json:"low"`
+	// Base is the factor the high part is multiplied with (see encoding.SplitCodec)
+	Base int `json:"base,omitempty"`
+	// Unit is the unit of the combined value
+	Unit string `json:"unit,omitempty"`
+}
+
+// Codec returns the codec combining the high and low part
+func (splitFunction SplitFunction) Codec() encoding.SplitCodec {
+	return encoding.SplitCodec{Base: splitFunction.Base, Unit: splitFunction.Unit}
+}
+
+// Catalog is a set of function descriptions.
+// It is immutable and safe for concurrent use.
+type Catalog struct {
+	name           string
+	functions      []Function
+	byNumber       map[int]int
+	byName         map[string]int
+	splitFunctions map[string]SplitFunction
+}
+
+// New validates the given functions and split functions and creates a catalog with the given name containing them
+func New(name string, functions []Function, splitFunctions []SplitFunction) (*Catalog, error) {
+	catalog := &Catalog{
+		name:      name,
+		functions: make([]Function, len(functions)),
+		byNumber:  make(map[int]int, len(functions)),
+		byName:    make(map[string]int, len(functions)),
+	}
+	copy(catalog.functions, functions)
+	sort.Slice(catalog.functions, func(i, j int) bool { return catalog.functions[i].Number < catalog.functions[j].Number })
+
+	for i := range catalog.functions {
+		function := &catalog.functions[i]
+		if err := function.validate(); err != nil {
+			return nil, merry.Prependf(err, "Invalid function in catalog %s", name)
+		}
+		if _, exists := catalog.byNumber[function.Number]; exists {
+			return nil, merry.Errorf("Function %d is in catalog %s more than once", function.Number, name)
+		}
+		if _, exists := catalog.byName[function.Name]; exists {
+			return nil, merry.Errorf("A function named %s is in catalog %s more than once", function.Name, name)
+		}
+		catalog.byNumber[function.Number] = i
+		catalog.byName[function.Name] = i
+	}
+
+	catalog.splitFunctions = make(map[string]SplitFunction, len(splitFunctions))
+	for _, splitFunction := range splitFunctions {
+		if err := catalog.validateSplitFunction(splitFunction); err != nil {
+			return nil, merry.Prependf(err, "Invalid split function in catalog %s", name)
+		}
+		catalog.splitFunctions[splitFunction.Name] = splitFunction
+	}
+
+	return catalog, nil
+}
+
+// validateSplitFunction checks the given split function against the functions of the catalog
+func (catalog *Catalog) validateSplitFunction(splitFunction SplitFunction) error {
+	if splitFunction.Name == "" {
+		return merry.New("A split function must have a name")
+	}
+	if _, exists := catalog.byName[splitFunction.Name]; exists {
+		return merry.Errorf("A function named %s is in the catalog more than once", splitFunction.Name)
+	}
+	if _, exists := catalog.splitFunctions[splitFunction.Name]; exists {
+		return merry.Errorf("A function named %s is in the catalog more than once", splitFunction.Name)
+	}
+	for _, part := range []string{splitFunction.High, splitFunction.Low} {
+		if _, exists := catalog.byName[part]; !exists {
+			return merry.Prependf(UnknownFunctionError, "Part %s of split function %s", part, splitFunction.Name)
+		}
+	}
+	return merry.Prependf(splitFunction.Codec().Validate(), "Invalid split function %s", splitFunction.Name)
+}
+
+// catalogFile is the format of a catalog file
+type catalogFile struct {
+	Name           string          `json:"name"`
+	Functions      []Function      `json:"functions"`
+	SplitFunctions []SplitFunction `json:"splitFunctions,omitempty"`
+}
+
+// Load reads a catalog in JSON format from the given reader
+func Load(reader io.Reader) (*Catalog, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+
+	var file catalogFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, merry.Prepend(err, "Failed to decode catalog")
+	}
+	return New(file.Name, file.Functions, file.SplitFunctions)
+}
+
+// LoadFile reads a catalog in JSON format from the file with the given path
+func LoadFile(path string) (*Catalog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, merry.Prependf(err, "Failed to open catalog %s", path)
+	}
+	defer file.Close()
+
+	catalog, err := Load(file)
+	if err != nil {
+		return nil, merry.Prependf(err, "Failed to load catalog %s", path)
+	}
+	return catalog, nil
+}
+
+//go:embed default.json
+var defaultCatalogData []byte
+
+var (
+	defaultCatalog     *Catalog
+	defaultCatalogOnce sync.Once
+)
+
+// Default returns the catalog embedded into the binary
+func Default() *Catalog {
+	defaultCatalogOnce.Do(func() {
+		catalog, err := Load(bytes.NewReader(defaultCatalogData))
+		if err != nil {
+			// The embedded catalog is checked by the tests
+			panic(merry.Prepend(err, "The embedded catalog is invalid"))
+		}
+		defaultCatalog = catalog
+	})
+	return defaultCatalog
+}
+
+// Name returns the name of the catalog
+func (catalog *Catalog) Name() string {
+	return catalog.name
+}
+
+// Function returns the function with the given number and whether it is in the catalog
+func (catalog *Catalog) Function(number int) (Function, bool) {
+	index, ok := catalog.byNumber[number]
+	if !ok {
+		return Function{}, false
+	}
+	return catalog.functions[index], true
+}
+
+// FunctionByName returns the function with the given name and whether it is in the catalog
+func (catalog *Catalog) FunctionByName(name string) (Function, bool) {
+	index, ok := catalog.byName[name]
+	if !ok {
+		return Function{}, false
+	}
+	return catalog.functions[index], true
+}
+
+// SplitFunction returns the split function with the given name and whether it is in the catalog
+func (catalog *Catalog) SplitFunction(name string) (SplitFunction, bool) {
+	splitFunction, ok := catalog.splitFunctions[name]
+	return splitFunction, ok
+}
+
+// Functions returns all functions in the catalog sorted by their number
+func (catalog *Catalog) Functions() []Function {
+	functions := make([]Function, len(catalog.functions))
+	copy(functions, catalog.functions)
+	return functions
+}
+
+// ValidateFrame checks the given frame against the catalog.
+// It returns UnknownFunctionError if the function of the frame is not in the catalog,
+// ReadOnlyFunctionError for a write request to a read-only function and
+// ValueOutOfRangeError if the value of a write request or response is outside the range of the function.
+func (catalog *Catalog) ValidateFrame(frame encoding.Frame) error {
+	function, ok := catalog.Function(frame.Function())
+	if !ok {
+		return merry.Prependf(UnknownFunctionError, "Function %d of frame to/from device %d", frame.Function(), frame.Address())
+	}
+
+	if frame.FrameType() == encoding.WriteRequest && !function.Writable() {
+		return merry.Prependf(ReadOnlyFunctionError, "Can't write function %s of device %d", function.Name, frame.Address())
+	}
+
+	if frame.FrameType() != encoding.ReadRequest {
+		return function.checkValue(frame.Value())
+	}
+	return nil
+}
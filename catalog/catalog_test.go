@@ -0,0 +1,252 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+func testFunctions() []Function {
+	return []Function{
+		{Number: 20, Name: "temperature", Unit: "°C", Scale: 0.1, Minimum: encoding.Bound(0), Maximum: encoding.Bound(500), Access: ReadOnly},
+		{Number: 10, Name: "level", Minimum: encoding.Bound(1), Maximum: encoding.Bound(4), Access: ReadWrite},
+		{Number: 30, Name: "outdoor", Unit: "°C", Signed: true, Scale: 0.1, Minimum: encoding.Bound(-400), Maximum: encoding.Bound(499), Access: ReadOnly},
+		{Number: 40, Name: "hours_high", Access: ReadOnly},
+		{Number: 41, Name: "hours_low", Access: ReadOnly},
+	}
+}
+
+func testSplitFunctions() []SplitFunction {
+	return []SplitFunction{{Name: "hours", High: "hours_high", Low: "hours_low", Unit: "h"}}
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	catalog := Default()
+	must.NotNil(t, catalog)
+	test.EqOp(t, "default", catalog.Name())
+	test.SliceNotEmpty(t, catalog.Functions())
+	test.EqOp(t, catalog, Default())
+
+	for _, name := range []string{"fan_level", "mode", "supply_air_temperature", "outdoor_air_temperature"} {
+		_, ok := catalog.FunctionByName(name)
+		test.True(t, ok, test.Sprintf("function %s missing in default catalog", name))
+	}
+}
+
+func TestNew(t *testing.T) {
+	catalog, err := New("test", testFunctions(), nil)
+	must.NoError(t, err)
+
+	test.EqOp(t, "test", catalog.Name())
+	functions := catalog.Functions()
+	must.Len(t, 5, functions)
+	test.EqOp(t, 10, functions[0].Number)
+	test.EqOp(t, 20, functions[1].Number)
+	test.EqOp(t, 1.0, functions[0].Scale)
+	test.EqOp(t, 0.1, functions[1].Scale)
+
+	function, ok := catalog.Function(20)
+	must.True(t, ok)
+	test.EqOp(t, "temperature", function.Name)
+	test.False(t, function.Writable())
+
+	function, ok = catalog.FunctionByName("level")
+	must.True(t, ok)
+	test.EqOp(t, 10, function.Number)
+	test.True(t, function.Writable())
+
+	_, ok = catalog.Function(50)
+	test.False(t, ok)
+	_, ok = catalog.FunctionByName("foo")
+	test.False(t, ok)
+}
+
+func TestNewBad(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func([]Function) []Function
+	}{
+		{"no name", func(f []Function) []Function { f[0].Name = ""; return f }},
+		{"number too high", func(f []Function) []Function { f[0].Number = encoding.MAXIMUM_FUNCTION + 1; return f }},
+		{"minimum too low", func(f []Function) []Function { f[0].Minimum = encoding.Bound(encoding.MINIMUM_VALUE - 1); return f }},
+		{"signed maximum too high", func(f []Function) []Function { f[0].Signed = true; return f }},
+		{"negative scale", func(f []Function) []Function { f[0].Scale = -1; return f }},
+		{"maximum too high", func(f []Function) []Function { f[0].Maximum = encoding.Bound(encoding.MAXIMUM_VALUE + 1); return f }},
+		{"minimum above maximum", func(f []Function) []Function { f[0].Minimum = encoding.Bound(*f[0].Maximum + 1); return f }},
+		{"no access", func(f []Function) []Function { f[0].Access = ""; return f }},
+		{"duplicate number", func(f []Function) []Function { f[0].Number = f[1].Number; return f }},
+		{"duplicate name", func(f []Function) []Function { f[0].Name = f[1].Name; return f }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New("test", tc.modify(testFunctions()), nil)
+			test.Error(t, err)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	catalog, err := Load(strings.NewReader(`{"name": "test", "functions": [
+		{"number": 5, "name": "foo", "unit": "%", "scale": 0.5, "minimum": 0, "maximum": 200, "access": "read-write"}
+	]}`))
+	must.NoError(t, err)
+
+	test.EqOp(t, "test", catalog.Name())
+	function, ok := catalog.Function(5)
+	must.True(t, ok)
+	test.Eq(t, Function{Number: 5, Name: "foo", Unit: "%", Scale: 0.5, Minimum: encoding.Bound(0), Maximum: encoding.Bound(200), Access: ReadWrite}, function)
+}
+
+func TestLoadOnlyZero(t *testing.T) {
+	catalog, err := Load(strings.NewReader(`{"name": "test", "functions": [
+		{"number": 5, "name": "reset", "minimum": 0, "maximum": 0, "access": "read-write"},
+		{"number": 6, "name": "counter", "access": "read-only"}
+	]}`))
+	must.NoError(t, err)
+
+	reset, err := encoding.NewWriteRequest(10, 5, 0)
+	must.NoError(t, err)
+	test.NoError(t, catalog.ValidateFrame(reset))
+	reset, err = encoding.NewWriteRequest(10, 5, 1)
+	must.NoError(t, err)
+	test.ErrorIs(t, catalog.ValidateFrame(reset), encoding.ValueOutOfRangeError)
+
+	counter, ok := catalog.Function(6)
+	must.True(t, ok)
+	minimum, maximum := counter.Codec().IntRange()
+	test.EqOp(t, [2]int{encoding.MINIMUM_VALUE, encoding.MAXIMUM_VALUE}, [2]int{minimum, maximum})
+}
+
+func TestLoadBad(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{"no json", "foo"},
+		{"unknown field", `{"name": "test", "functions": [{"number": 5, "name": "foo", "maximum": 1, "access": "read-only", "bar": 1}]}`},
+		{"invalid function", `{"name": "test", "functions": [{"number": 5, "name": "foo", "maximum": 1, "access": "write-only"}]}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tc.data))
+			test.Error(t, err)
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	must.NoError(t, os.WriteFile(path, defaultCatalogData, 0o600))
+
+	catalog, err := LoadFile(path)
+	must.NoError(t, err)
+	test.Eq(t, Default().Functions(), catalog.Functions())
+
+	_, err = LoadFile(filepath.Join(t.TempDir(), "missing.json"))
+	test.ErrorContains(t, err, "Failed to open catalog")
+}
+
+func TestValidateFrame(t *testing.T) {
+	catalog, err := New("test", testFunctions(), nil)
+	must.NoError(t, err)
+
+	mustFrame := func(frame encoding.Frame, err error) encoding.Frame {
+		must.NoError(t, err)
+		return frame
+	}
+
+	testCases := []struct {
+		name     string
+		frame    encoding.Frame
+		expected error
+	}{
+		{"read read-only", mustFrame(encoding.NewReadRequest(1, 20)), nil},
+		{"read read-write", mustFrame(encoding.NewReadRequest(1, 10)), nil},
+		{"write read-write", mustFrame(encoding.NewWriteRequest(1, 10, 4)), nil},
+		{"response in range", mustFrame(encoding.NewReadResponse(1, 20, 500)), nil},
+		{"read unknown", mustFrame(encoding.NewReadRequest(1, 50)), UnknownFunctionError},
+		{"response unknown", mustFrame(encoding.NewWriteResponse(1, 50, 1)), UnknownFunctionError},
+		{"write read-only", mustFrame(encoding.NewWriteRequest(1, 20, 1)), ReadOnlyFunctionError},
+		{"write below range", mustFrame(encoding.NewWriteRequest(1, 10, 0)), ValueOutOfRangeError},
+		{"write above range", mustFrame(encoding.NewWriteRequest(1, 10, 5)), ValueOutOfRangeError},
+		{"response out of range", mustFrame(encoding.NewReadResponse(1, 20, 501)), ValueOutOfRangeError},
+		{"signed response in range", mustFrame(encoding.NewReadResponse(1, 30, 999)), nil},
+		{"signed response out of range", mustFrame(encoding.NewReadResponse(1, 30, 500)), ValueOutOfRangeError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := catalog.ValidateFrame(tc.frame)
+			if tc.expected == nil {
+				test.NoError(t, err)
+			} else {
+				test.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFunctionCodec(t *testing.T) {
+	catalog, err := New("test", testFunctions(), nil)
+	must.NoError(t, err)
+
+	function, ok := catalog.FunctionByName("outdoor")
+	must.True(t, ok)
+	value, err := function.Codec().Decode(995)
+	must.NoError(t, err)
+	test.EqOp(t, -0.5, value)
+
+	_, err = function.Codec().Encode(-40.1)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	test.ErrorContains(t, err, "-40 °C")
+}
+
+func TestSplitFunctions(t *testing.T) {
+	catalog, err := New("test", testFunctions(), testSplitFunctions())
+	must.NoError(t, err)
+
+	splitFunction, ok := catalog.SplitFunction("hours")
+	must.True(t, ok)
+	test.EqOp(t, "hours_high", splitFunction.High)
+	value, err := splitFunction.Codec().Combine(12, 345)
+	must.NoError(t, err)
+	test.EqOp(t, 12345, value)
+
+	_, ok = catalog.SplitFunction("hours_high")
+	test.False(t, ok)
+
+	_, ok = Default().SplitFunction("operating_hours")
+	test.True(t, ok)
+}
+
+func TestSplitFunctionsBad(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(*SplitFunction)
+	}{
+		{"no name", func(f *SplitFunction) { f.Name = "" }},
+		{"name of function", func(f *SplitFunction) { f.Name = "level" }},
+		{"unknown high", func(f *SplitFunction) { f.High = "foo" }},
+		{"unknown low", func(f *SplitFunction) { f.Low = "foo" }},
+		{"invalid base", func(f *SplitFunction) { f.Base = 1 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			splitFunctions := testSplitFunctions()
+			tc.modify(&splitFunctions[0])
+			_, err := New("test", testFunctions(), splitFunctions)
+			test.Error(t, err)
+		})
+	}
+
+	_, err := New("test", testFunctions(), append(testSplitFunctions(), testSplitFunctions()...))
+	test.ErrorContains(t, err, "more than once")
+}
@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ansel1/merry/v2"
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
+	"github.com/ventcon/ventcon-hwio/catalog"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/serial"
 )
 
 // PREFIX is prepended the the configuration options of this project
@@ -19,7 +25,34 @@ const PREFIX = "VENTCON_HWIO"
 // See github.com/kelseyhightower/envconfig for the format
 // It can also include subconfig of specific components.
 type Config struct {
-	LogLevel log.Level `default:"Info" split_words:"true" desc:"The log level (panic, fatal, error, warn, info, debug, trace)"`
+	LogLevel  log.Level `default:"Info" split_words:"true" desc:"The log level (panic, fatal, error, warn, info, debug, trace)"`
+	Dialect   Dialect   `default:"default" desc:"The name of the protocol dialect spoken on the serial bus"`
+	Catalog   Catalog   `desc:"The path of a JSON file describing the functions of the ventilators. The embedded catalog is used if empty"`
+	Ports     []Port    `desc:"Comma separated list of serial ports (device names, usb://VID:PID to find a USB adapter, tcp://host:port for raw TCP or rfc2217://host:port for RFC 2217), each optionally followed by its line parameters, e.g. /dev/ttyUSB0?baud=19200&parity=none. Parameters: baud, dataBits, parity (none, odd, even, mark, space), stopBits (1, 1.5, 2), readTimeout, responseTimeout, echo (strip the local echo of RS485 adapters), rts (assert RTS while writing), rtsPreDelay, rtsPostDelay, interFrameGap, turnaround (derived from the baud rate if not given), serialNumber and product (further criteria of usb:// ports)"`
+	Routes    Routes    `desc:"Comma separated list of device addresses and the names of the ports of their bus, e.g. 1=/dev/ttyUSB0,2=tcp://host:4001. The addresses of devices found by scanning the buses are added"`
+	Retry     Retry
+	Reconnect Reconnect
+}
+
+// Retry represents the configuration of the retries of failed requests
+type Retry struct {
+	MaxAttempts int           `default:"3" split_words:"true" desc:"The maximum number of times a request is sent. 1 disables retries"`
+	Backoff     time.Duration `default:"50ms" desc:"The delay before the first retry. It is doubled after each retry"`
+	MaxBackoff  time.Duration `default:"1s" split_words:"true" desc:"The longest delay between two attempts"`
+	On          RetryOn       `default:"all" desc:"Comma separated list of the error classes requests are retried on (noData, invalidResponse, mismatch, writeFailure, all, none). Rejections are never retried"`
+	Writes      WriteRetry    `default:"unsent" desc:"When write requests are retried (never, unsent: only if they could not be written to the port, always)"`
+}
+
+// Policy returns the retry policy described by the configuration
+func (retry Retry) Policy() serial.RetryPolicy {
+	return serial.RetryPolicy{
+		MaxAttempts:   retry.MaxAttempts,
+		Backoff:       retry.Backoff,
+		MaxBackoff:    retry.MaxBackoff,
+		BackoffFactor: serial.DEFAULT_BACKOFF_FACTOR,
+		RetryOn:       serial.RetryOn(retry.On),
+		WriteRetry:    serial.WriteRetry(retry.Writes),
+	}
 }
 
 // LogLevel is a type alias used for the LogLevel config decoded
@@ -32,6 +65,123 @@ func (level *LogLevel) Decode(value string) error {
 	return err
 }
 
+// Dialect is a type alias used for the Dialect config decoded
+type Dialect encoding.Dialect
+
+// Decode is used to Decode Dialect configurations by looking up the registered dialect with the given name
+func (dialect *Dialect) Decode(value string) error {
+	registeredDialect, err := encoding.LookupDialect(value)
+	*dialect = Dialect(registeredDialect)
+	return err
+}
+
+// Catalog is a type used for the Catalog config decoded
+type Catalog struct {
+	*catalog.Catalog
+}
+
+// Decode is used to Decode Catalog configurations by loading the catalog from the file with the given path
+func (functionCatalog *Catalog) Decode(value string) error {
+	loadedCatalog, err := catalog.LoadFile(value)
+	functionCatalog.Catalog = loadedCatalog
+	return err
+}
+
+// OrDefault returns the configured catalog or the embedded catalog if none has been configured
+func (functionCatalog Catalog) OrDefault() *catalog.Catalog {
+	if functionCatalog.Catalog == nil {
+		return catalog.Default()
+	}
+	return functionCatalog.Catalog
+}
+
+// Reconnect represents the configuration of reopening failed serial ports
+type Reconnect struct {
+	Enabled    bool          `default:"true" desc:"Whether to close and reopen a serial port after it failed (e.g. because the adapter has been unplugged)"`
+	Backoff    time.Duration `default:"500ms" desc:"The delay before the first attempt to reopen the port. It is doubled after each attempt"`
+	MaxBackoff time.Duration `default:"30s" split_words:"true" desc:"The longest delay between two attempts to reopen the port"`
+	FollowByID bool          `default:"true" split_words:"true" desc:"Whether to reopen the port using its link in /dev/serial/by-id, which follows the adapter if its device name changes"`
+	Pending    Pending       `default:"queue" desc:"What happens to requests while the port is being reopened (queue, fail)"`
+}
+
+// Policy returns the reconnect policy described by the configuration
+func (reconnect Reconnect) Policy() serial.ReconnectPolicy {
+	return serial.ReconnectPolicy{
+		Enabled:    reconnect.Enabled,
+		Backoff:    reconnect.Backoff,
+		MaxBackoff: reconnect.MaxBackoff,
+		FollowByID: reconnect.FollowByID,
+		Pending:    serial.PendingPolicy(reconnect.Pending),
+	}
+}
+
+// RetryOn is a type alias used for the Retry.On config decoded
+type RetryOn serial.RetryOn
+
+// Decode is used to Decode RetryOn configurations by parsing the list of error classes
+func (retryOn *RetryOn) Decode(value string) error {
+	parsed, err := serial.ParseRetryOn(value)
+	*retryOn = RetryOn(parsed)
+	return err
+}
+
+// WriteRetry is a type alias used for the Retry.Writes config decoded
+type WriteRetry serial.WriteRetry
+
+// Decode is used to Decode WriteRetry configurations
+func (writeRetry *WriteRetry) Decode(value string) error {
+	parsed, err := serial.ParseWriteRetry(value)
+	*writeRetry = WriteRetry(parsed)
+	return err
+}
+
+// Pending is a type alias used for the Reconnect.Pending config decoded
+type Pending serial.PendingPolicy
+
+// Decode is used to Decode Pending configurations
+func (pending *Pending) Decode(value string) error {
+	parsed, err := serial.ParsePendingPolicy(value)
+	*pending = Pending(parsed)
+	return err
+}
+
+// Port is a type alias used for the Ports config decoded
+type Port serial.PortOptions
+
+// Decode is used to Decode Port configurations by parsing and validating the port spec
+func (port *Port) Decode(value string) error {
+	options, err := serial.ParsePortOptions(value)
+	*port = Port(options)
+	return err
+}
+
+// Routes is a type used for the Routes config decoded
+type Routes map[int]string
+
+// Decode is used to Decode Routes configurations by parsing the address=port pairs
+func (routes *Routes) Decode(value string) error {
+	*routes = make(Routes)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		address, port, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(port) == "" {
+			return merry.Errorf("The route %s must have the form address=port", pair)
+		}
+		parsed, err := strconv.Atoi(strings.TrimSpace(address))
+		if err != nil {
+			return merry.Prependf(err, "Invalid address of route %s", pair)
+		}
+		if _, ok := (*routes)[parsed]; ok {
+			return merry.Errorf("The address %d is routed more than once", parsed)
+		}
+		(*routes)[parsed] = strings.TrimSpace(port)
+	}
+	return nil
+}
+
 func sanitizeEnvVarName(envVarName string) string {
 	var newEnvVarName string
 	for _, char := range strings.ToUpper(envVarName) {
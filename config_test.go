@@ -3,10 +3,17 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
 	log "github.com/sirupsen/logrus"
+	"github.com/ventcon/ventcon-hwio/catalog"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/serial"
+	goserial "go.bug.st/serial"
 )
 
 func TestSanitizeEnvVarName(t *testing.T) {
@@ -147,3 +154,156 @@ func TestGetUsage(t *testing.T) {
 		test.SliceContains(t, actualVars, expectedVar)
 	}
 }
+
+func TestDialectDecode(t *testing.T) {
+	var dialect Dialect
+
+	err := dialect.Decode(encoding.DEFAULT_DIALECT_NAME)
+	test.NoError(t, err)
+	test.Eq(t, Dialect(encoding.DefaultDialect), dialect)
+
+	err = dialect.Decode("someUnknownDialect")
+	test.ErrorContains(t, err, "Unknown dialect someUnknownDialect")
+}
+
+func TestLoadMainConfigDialect(t *testing.T) {
+	os.Clearenv()
+
+	config, _, err := loadMainConfig()
+	test.NoError(t, err)
+	test.Eq(t, Dialect(encoding.DefaultDialect), config.Dialect)
+
+	setEnvVar("Dialect", "someUnknownDialect")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "Unknown dialect someUnknownDialect")
+}
+
+func TestCatalogDecode(t *testing.T) {
+	var functionCatalog Catalog
+	test.Eq(t, catalog.Default(), functionCatalog.OrDefault())
+
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	must.NoError(t, os.WriteFile(path, []byte(`{"name": "test", "functions": [{"number": 5, "name": "foo", "minimum": 0, "maximum": 10, "access": "read-only"}]}`), 0o600))
+
+	err := functionCatalog.Decode(path)
+	must.NoError(t, err)
+	test.EqOp(t, "test", functionCatalog.OrDefault().Name())
+
+	err = functionCatalog.Decode(filepath.Join(t.TempDir(), "missing.json"))
+	test.ErrorContains(t, err, "Failed to open catalog")
+}
+
+func TestLoadMainConfigCatalog(t *testing.T) {
+	os.Clearenv()
+
+	config, _, err := loadMainConfig()
+	test.NoError(t, err)
+	test.Nil(t, config.Catalog.Catalog)
+	test.Eq(t, catalog.Default(), config.Catalog.OrDefault())
+
+	setEnvVar("Catalog", filepath.Join(t.TempDir(), "missing.json"))
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "Failed to open catalog")
+}
+
+func TestPortDecode(t *testing.T) {
+	var port Port
+
+	err := port.Decode("/dev/ttyUSB0?baud=19200&parity=none")
+	must.NoError(t, err)
+	expected := serial.DefaultPortOptions("/dev/ttyUSB0")
+	expected.BaudRate = 19200
+	expected.Parity = goserial.NoParity
+	test.Eq(t, Port(expected), port)
+
+	err = port.Decode("/dev/ttyUSB0?baud=0")
+	test.ErrorContains(t, err, "baud rate of port /dev/ttyUSB0 must be positive")
+}
+
+func TestLoadMainConfigPorts(t *testing.T) {
+	os.Clearenv()
+
+	config, _, err := loadMainConfig()
+	test.NoError(t, err)
+	test.SliceEmpty(t, config.Ports)
+
+	setEnvVar("Ports", "/dev/ttyUSB0?baud=19200&parity=none,/dev/ttyUSB1")
+	config, _, err = loadMainConfig()
+	must.NoError(t, err)
+	must.Len(t, 2, config.Ports)
+	test.EqOp(t, 19200, config.Ports[0].BaudRate)
+	test.EqOp(t, goserial.NoParity, config.Ports[0].Parity)
+	test.Eq(t, Port(serial.DefaultPortOptions("/dev/ttyUSB1")), config.Ports[1])
+
+	setEnvVar("Ports", "/dev/ttyUSB0?dataBits=9")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "data bits of port /dev/ttyUSB0 must be between 5 and 8")
+}
+
+func TestLoadMainConfigRetry(t *testing.T) {
+	os.Clearenv()
+
+	config, _, err := loadMainConfig()
+	must.NoError(t, err)
+	test.Eq(t, serial.DefaultRetryPolicy(), config.Retry.Policy())
+
+	setEnvVar("Retry_Max_Attempts", "5")
+	setEnvVar("Retry_Backoff", "10ms")
+	setEnvVar("Retry_On", "noData,invalidResponse")
+	setEnvVar("Retry_Writes", "never")
+	config, _, err = loadMainConfig()
+	must.NoError(t, err)
+	policy := config.Retry.Policy()
+	test.EqOp(t, 5, policy.MaxAttempts)
+	test.EqOp(t, 10*time.Millisecond, policy.Backoff)
+	test.EqOp(t, serial.RetryOnNoData|serial.RetryOnInvalidResponse, policy.RetryOn)
+	test.EqOp(t, serial.WriteRetryNever, policy.WriteRetry)
+
+	setEnvVar("Retry_On", "noData,rejection")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "Unknown error class rejection")
+}
+
+func TestLoadMainConfigReconnect(t *testing.T) {
+	os.Clearenv()
+
+	config, _, err := loadMainConfig()
+	must.NoError(t, err)
+	test.Eq(t, serial.DefaultReconnectPolicy(), config.Reconnect.Policy())
+
+	setEnvVar("Reconnect_Enabled", "false")
+	setEnvVar("Reconnect_Pending", "fail")
+	config, _, err = loadMainConfig()
+	must.NoError(t, err)
+	test.False(t, config.Reconnect.Policy().Enabled)
+	test.EqOp(t, serial.PendingFail, config.Reconnect.Policy().Pending)
+
+	setEnvVar("Reconnect_Pending", "drop")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "Unknown pending policy drop")
+}
+
+func TestLoadMainConfigRoutes(t *testing.T) {
+	os.Clearenv()
+
+	config, _, err := loadMainConfig()
+	must.NoError(t, err)
+	test.MapEmpty(t, config.Routes)
+
+	setEnvVar("Routes", "1=/dev/ttyUSB0, 12=tcp://host:4001")
+	config, _, err = loadMainConfig()
+	must.NoError(t, err)
+	test.Eq(t, Routes{1: "/dev/ttyUSB0", 12: "tcp://host:4001"}, config.Routes)
+
+	setEnvVar("Routes", "1:/dev/ttyUSB0")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "must have the form address=port")
+
+	setEnvVar("Routes", "x=/dev/ttyUSB0")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "Invalid address of route x=/dev/ttyUSB0")
+
+	setEnvVar("Routes", "1=/dev/ttyUSB0,1=/dev/ttyUSB1")
+	_, _, err = loadMainConfig()
+	test.ErrorContains(t, err, "address 1 is routed more than once")
+}
@@ -0,0 +1,288 @@
+// device contains typed APIs for the devices connected to a serial bus.
+// They are built on the requests channel of a serial.SerialManager.
+package device
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/ventcon/ventcon-hwio/catalog"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/serial"
+)
+
+// The names of the catalog functions used by the Ventilator
+const (
+	FUNCTION_FAN_LEVEL               = "fan_level"
+	FUNCTION_MODE                    = "mode"
+	FUNCTION_SUPPLY_AIR_TEMPERATURE  = "supply_air_temperature"
+	FUNCTION_EXTRACT_AIR_TEMPERATURE = "extract_air_temperature"
+	FUNCTION_OUTDOOR_AIR_TEMPERATURE = "outdoor_air_temperature"
+	FUNCTION_EXHAUST_AIR_TEMPERATURE = "exhaust_air_temperature"
+	FUNCTION_OPERATING_HOURS         = "operating_hours"
+)
+
+// UnexpectedResponseError is the error returned when the response does not fit the request
+// (e.g. it is for another function or the device did not confirm the written value)
+var UnexpectedResponseError = merry.Sentinel("Unexpected response")
+
+// Mode is the operating mode of a ventilator
+type Mode int
+
+const (
+	// ModeOff is the Mode of a ventilator that is switched off
+	ModeOff Mode = 0
+	// ModeAutomatic is the Mode of a ventilator that controls its fan level itself
+	ModeAutomatic Mode = 1
+	// ModeManual is the Mode of a ventilator that runs with the fan level set
+	ModeManual Mode = 2
+	// ModeBoost is the Mode of a ventilator that runs with its highest fan level for a while
+	ModeBoost Mode = 3
+)
+
+// String returns the name of the mode
+func (mode Mode) String() string {
+	switch mode {
+	case ModeOff:
+		return "off"
+	case ModeAutomatic:
+		return "automatic"
+	case ModeManual:
+		return "manual"
+	case ModeBoost:
+		return "boost"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(mode))
+	}
+}
+
+// Temperatures are the temperatures measured by a ventilator in the unit of the catalog (usually °C)
+type Temperatures struct {
+	// Supply is the temperature of the air supplied to the rooms
+	Supply float64
+	// Extract is the temperature of the air extracted from the rooms
+	Extract float64
+	// Outdoor is the temperature of the outdoor air
+	Outdoor float64
+	// Exhaust is the temperature of the air exhausted to the outside
+	Exhaust float64
+}
+
+// Ventilator is a single ventilator on a serial bus.
+// It is safe for concurrent use.
+type Ventilator struct {
+	address  int
+	requests chan<- serial.Request
+	catalog  *catalog.Catalog
+}
+
+// NewVentilator creates a new Ventilator with the given address that sends its requests to the given channel
+// (usually the one returned by serial.NewSerialManager) and uses the given catalog to look up its functions.
+func NewVentilator(address int, requests chan<- serial.Request, functionCatalog *catalog.Catalog) (*Ventilator, error) {
+	if address < encoding.MINIMUM_ADDRESS || address > encoding.MAXIMUM_ADDRESS {
+		return nil, merry.Errorf("The address must be between %d and %d (inclusive). It was %d", encoding.MINIMUM_ADDRESS, encoding.MAXIMUM_ADDRESS, address)
+	}
+	if requests == nil {
+		return nil, merry.New("The requests channel must not be nil")
+	}
+	if functionCatalog == nil {
+		return nil, merry.New("The catalog must not be nil")
+	}
+	return &Ventilator{address: address, requests: requests, catalog: functionCatalog}, nil
+}
+
+// Address returns the address of the ventilator
+func (ventilator *Ventilator) Address() int {
+	return ventilator.address
+}
+
+// FanLevel reads the current fan level
+func (ventilator *Ventilator) FanLevel(ctx context.Context) (int, error) {
+	return ventilator.Read(ctx, FUNCTION_FAN_LEVEL)
+}
+
+// SetFanLevel sets the fan level
+func (ventilator *Ventilator) SetFanLevel(ctx context.Context, level int) error {
+	return ventilator.Write(ctx, FUNCTION_FAN_LEVEL, level)
+}
+
+// Mode reads the current operating mode
+func (ventilator *Ventilator) Mode(ctx context.Context) (Mode, error) {
+	mode, err := ventilator.Read(ctx, FUNCTION_MODE)
+	return Mode(mode), err
+}
+
+// SetMode sets the operating mode
+func (ventilator *Ventilator) SetMode(ctx context.Context, mode Mode) error {
+	return ventilator.Write(ctx, FUNCTION_MODE, int(mode))
+}
+
+// Temperatures reads all temperatures measured by the ventilator
+func (ventilator *Ventilator) Temperatures(ctx context.Context) (Temperatures, error) {
+	var temperatures Temperatures
+	for _, temperature := range []struct {
+		name  string
+		value *float64
+	}{
+		{FUNCTION_SUPPLY_AIR_TEMPERATURE, &temperatures.Supply},
+		{FUNCTION_EXTRACT_AIR_TEMPERATURE, &temperatures.Extract},
+		{FUNCTION_OUTDOOR_AIR_TEMPERATURE, &temperatures.Outdoor},
+		{FUNCTION_EXHAUST_AIR_TEMPERATURE, &temperatures.Exhaust},
+	} {
+		value, err := ventilator.ReadScaled(ctx, temperature.name)
+		if err != nil {
+			return Temperatures{}, err
+		}
+		*temperature.value = value
+	}
+	return temperatures, nil
+}
+
+// OperatingHours reads the operating hours of the ventilator
+func (ventilator *Ventilator) OperatingHours(ctx context.Context) (int, error) {
+	return ventilator.ReadSplit(ctx, FUNCTION_OPERATING_HOURS)
+}
+
+// Read reads the raw value of the catalog function with the given name
+func (ventilator *Ventilator) Read(ctx context.Context, name string) (int, error) {
+	function, err := ventilator.function(name)
+	if err != nil {
+		return 0, err
+	}
+	request, err := encoding.NewReadRequest(ventilator.address, function.Number)
+	if err != nil {
+		return 0, err
+	}
+	response, err := ventilator.send(ctx, request)
+	if err != nil {
+		return 0, merry.Prependf(err, "Failed to read %s of ventilator %d", name, ventilator.address)
+	}
+	return response.Value(), nil
+}
+
+// ReadScaled reads the value of the catalog function with the given name and converts it into the unit of the function
+func (ventilator *Ventilator) ReadScaled(ctx context.Context, name string) (float64, error) {
+	function, err := ventilator.function(name)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := ventilator.Read(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	value, err := function.Codec().Decode(raw)
+	if err != nil {
+		return 0, merry.Prependf(err, "Ventilator %d returned an invalid %s", ventilator.address, name)
+	}
+	return value, nil
+}
+
+// WriteScaled converts the given value in the unit of the catalog function with the given name into the raw value and writes it.
+// The value is rounded to the nearest value the function can represent.
+func (ventilator *Ventilator) WriteScaled(ctx context.Context, name string, value float64) error {
+	function, err := ventilator.function(name)
+	if err != nil {
+		return err
+	}
+	raw, err := function.Codec().Encode(value)
+	if err != nil {
+		return merry.Prependf(err, "Failed to write %s of ventilator %d", name, ventilator.address)
+	}
+	return ventilator.Write(ctx, name, raw)
+}
+
+// ReadSplit reads both parts of the catalog split function with the given name and combines them
+func (ventilator *Ventilator) ReadSplit(ctx context.Context, name string) (int, error) {
+	splitFunction, ok := ventilator.catalog.SplitFunction(name)
+	if !ok {
+		return 0, merry.Prependf(catalog.UnknownFunctionError, "Split function %s in catalog %s", name, ventilator.catalog.Name())
+	}
+	high, err := ventilator.Read(ctx, splitFunction.High)
+	if err != nil {
+		return 0, err
+	}
+	low, err := ventilator.Read(ctx, splitFunction.Low)
+	if err != nil {
+		return 0, err
+	}
+	value, err := splitFunction.Codec().Combine(high, low)
+	if err != nil {
+		return 0, merry.Prependf(err, "Ventilator %d returned an invalid %s", ventilator.address, name)
+	}
+	return value, nil
+}
+
+// Write writes the raw value of the catalog function with the given name.
+// The value is checked against the catalog before it is sent.
+func (ventilator *Ventilator) Write(ctx context.Context, name string, value int) error {
+	function, err := ventilator.function(name)
+	if err != nil {
+		return err
+	}
+	request, err := encoding.NewWriteRequest(ventilator.address, function.Number, value)
+	if err != nil {
+		return merry.Prependf(err, "Failed to write %s of ventilator %d", name, ventilator.address)
+	}
+	if err := ventilator.catalog.ValidateFrame(request); err != nil {
+		return merry.Prependf(err, "Failed to write %s of ventilator %d", name, ventilator.address)
+	}
+	response, err := ventilator.send(ctx, request)
+	if err != nil {
+		return merry.Prependf(err, "Failed to write %s of ventilator %d", name, ventilator.address)
+	}
+	if response.Value() != value {
+		return merry.Prependf(UnexpectedResponseError, "Ventilator %d confirmed %d instead of %d for %s", ventilator.address, response.Value(), value, name)
+	}
+	return nil
+}
+
+// function looks up the catalog function with the given name
+func (ventilator *Ventilator) function(name string) (catalog.Function, error) {
+	function, ok := ventilator.catalog.FunctionByName(name)
+	if !ok {
+		return function, merry.Prependf(catalog.UnknownFunctionError, "Function %s in catalog %s", name, ventilator.catalog.Name())
+	}
+	return function, nil
+}
+
+// send sends the given request and waits for its response or the end of the context.
+// The request has the priority carried by the context (see serial.WithPriority).
+// It checks that the response belongs to the request.
+func (ventilator *Ventilator) send(ctx context.Context, request encoding.Frame) (encoding.Frame, error) {
+	// Buffered so the manager does not block on the response if the context ended in the meantime
+	responses := make(chan serial.Response, 1)
+
+	select {
+	case ventilator.requests <- serial.Request{ResponseChannel: responses, Data: request, Context: ctx, Priority: serial.PriorityFromContext(ctx)}:
+	case <-ctx.Done():
+		return nil, merry.Prepend(ctx.Err(), "Failed to queue request")
+	}
+
+	select {
+	case response, ok := <-responses:
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return nil, merry.Prepend(err, "Failed to wait for response")
+			}
+			return nil, merry.New("The request has been dropped without a response")
+		}
+		if response.Err != nil {
+			return nil, response.Err
+		}
+		if err := checkResponse(request, response.Response); err != nil {
+			return nil, err
+		}
+		return response.Response, nil
+	case <-ctx.Done():
+		return nil, merry.Prepend(ctx.Err(), "Failed to wait for response")
+	}
+}
+
+// checkResponse checks whether the given response belongs to the given request
+func checkResponse(request encoding.Frame, response encoding.Frame) error {
+	if !serial.ResponseMatches(request, response) {
+		return merry.Prependf(UnexpectedResponseError, "Received %v as response to %v", response, request)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/catalog"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/serial"
+)
+
+// testBus answers requests like a serial manager with a single ventilator holding the given values
+type testBus struct {
+	values   map[int]int
+	requests chan serial.Request
+	// respond can replace the response to a request
+	respond func(request encoding.Frame, response serial.Response) serial.Response
+}
+
+func setupTestBus(t *testing.T) *testBus {
+	bus := &testBus{
+		values:   map[int]int{},
+		requests: make(chan serial.Request),
+	}
+	go func() {
+		for request := range bus.requests {
+			frame := request.Data
+			var response serial.Response
+			if frame.FrameType() == encoding.WriteRequest {
+				bus.values[frame.Function()] = frame.Value()
+				response.Response, response.Err = encoding.NewWriteResponse(frame.Address(), frame.Function(), frame.Value())
+			} else {
+				response.Response, response.Err = encoding.NewReadResponse(frame.Address(), frame.Function(), bus.values[frame.Function()])
+			}
+			if bus.respond != nil {
+				response = bus.respond(frame, response)
+			}
+			request.ResponseChannel <- response
+			close(request.ResponseChannel)
+		}
+	}()
+	t.Cleanup(func() { close(bus.requests) })
+	return bus
+}
+
+func functionNumber(t *testing.T, name string) int {
+	function, ok := catalog.Default().FunctionByName(name)
+	must.True(t, ok)
+	return function.Number
+}
+
+func setupTestVentilator(t *testing.T) (*Ventilator, *testBus) {
+	bus := setupTestBus(t)
+	ventilator, err := NewVentilator(10, bus.requests, catalog.Default())
+	must.NoError(t, err)
+	return ventilator, bus
+}
+
+func TestNewVentilatorBad(t *testing.T) {
+	requests := make(chan serial.Request)
+
+	_, err := NewVentilator(encoding.MAXIMUM_ADDRESS+1, requests, catalog.Default())
+	test.Error(t, err)
+	_, err = NewVentilator(10, nil, catalog.Default())
+	test.Error(t, err)
+	_, err = NewVentilator(10, requests, nil)
+	test.Error(t, err)
+}
+
+func TestFanLevel(t *testing.T) {
+	ventilator, bus := setupTestVentilator(t)
+	test.EqOp(t, 10, ventilator.Address())
+
+	bus.values[functionNumber(t, FUNCTION_FAN_LEVEL)] = 2
+	level, err := ventilator.FanLevel(context.Background())
+	must.NoError(t, err)
+	test.EqOp(t, 2, level)
+
+	must.NoError(t, ventilator.SetFanLevel(context.Background(), 3))
+	level, err = ventilator.FanLevel(context.Background())
+	must.NoError(t, err)
+	test.EqOp(t, 3, level)
+
+	err = ventilator.SetFanLevel(context.Background(), 100)
+	test.ErrorIs(t, err, catalog.ValueOutOfRangeError)
+	err = ventilator.SetFanLevel(context.Background(), encoding.MAXIMUM_VALUE+1)
+	test.Error(t, err)
+}
+
+func TestMode(t *testing.T) {
+	ventilator, bus := setupTestVentilator(t)
+
+	bus.values[functionNumber(t, FUNCTION_MODE)] = 1
+	mode, err := ventilator.Mode(context.Background())
+	must.NoError(t, err)
+	test.EqOp(t, ModeAutomatic, mode)
+	test.EqOp(t, "automatic", mode.String())
+
+	must.NoError(t, ventilator.SetMode(context.Background(), ModeBoost))
+	mode, err = ventilator.Mode(context.Background())
+	must.NoError(t, err)
+	test.EqOp(t, ModeBoost, mode)
+
+	test.EqOp(t, "Mode(7)", Mode(7).String())
+}
+
+func TestTemperatures(t *testing.T) {
+	ventilator, bus := setupTestVentilator(t)
+
+	bus.values[functionNumber(t, FUNCTION_SUPPLY_AIR_TEMPERATURE)] = 215
+	bus.values[functionNumber(t, FUNCTION_EXTRACT_AIR_TEMPERATURE)] = 223
+	bus.values[functionNumber(t, FUNCTION_OUTDOOR_AIR_TEMPERATURE)] = 949
+	bus.values[functionNumber(t, FUNCTION_EXHAUST_AIR_TEMPERATURE)] = 87
+
+	temperatures, err := ventilator.Temperatures(context.Background())
+	must.NoError(t, err)
+	test.Eq(t, Temperatures{Supply: 21.5, Extract: 22.3, Outdoor: -5.1, Exhaust: 8.7}, temperatures)
+}
+
+func TestOperatingHours(t *testing.T) {
+	ventilator, bus := setupTestVentilator(t)
+
+	bus.values[functionNumber(t, "operating_hours_high")] = 12
+	bus.values[functionNumber(t, "operating_hours_low")] = 345
+
+	hours, err := ventilator.OperatingHours(context.Background())
+	must.NoError(t, err)
+	test.EqOp(t, 12345, hours)
+
+	_, err = ventilator.ReadSplit(context.Background(), "foo")
+	test.ErrorIs(t, err, catalog.UnknownFunctionError)
+}
+
+func TestWriteScaled(t *testing.T) {
+	ventilator, bus := setupTestVentilator(t)
+	functionCatalog, err := catalog.New("test", []catalog.Function{
+		{Number: 50, Name: "setpoint", Unit: "°C", Signed: true, Scale: 0.5, Minimum: encoding.Bound(-20), Maximum: encoding.Bound(60), Access: catalog.ReadWrite},
+	}, nil)
+	must.NoError(t, err)
+	ventilator.catalog = functionCatalog
+
+	must.NoError(t, ventilator.WriteScaled(context.Background(), "setpoint", -2.5))
+	test.EqOp(t, 995, bus.values[50])
+	value, err := ventilator.ReadScaled(context.Background(), "setpoint")
+	must.NoError(t, err)
+	test.EqOp(t, -2.5, value)
+
+	err = ventilator.WriteScaled(context.Background(), "setpoint", 30.5)
+	test.ErrorIs(t, err, encoding.ValueOutOfRangeError)
+	test.ErrorContains(t, err, "between -10 °C and 30 °C (inclusive). It was 30.5 °C")
+
+	bus.values[50] = 100
+	_, err = ventilator.ReadScaled(context.Background(), "setpoint")
+	test.ErrorIs(t, err, encoding.ValueOutOfRangeError)
+}
+
+func TestWriteReadOnly(t *testing.T) {
+	ventilator, _ := setupTestVentilator(t)
+
+	err := ventilator.Write(context.Background(), FUNCTION_SUPPLY_AIR_TEMPERATURE, 1)
+	test.ErrorIs(t, err, catalog.ReadOnlyFunctionError)
+}
+
+func TestUnknownFunction(t *testing.T) {
+	ventilator, _ := setupTestVentilator(t)
+
+	_, err := ventilator.Read(context.Background(), "foo")
+	test.ErrorIs(t, err, catalog.UnknownFunctionError)
+	err = ventilator.Write(context.Background(), "foo", 1)
+	test.ErrorIs(t, err, catalog.UnknownFunctionError)
+}
+
+func TestResponseError(t *testing.T) {
+	ventilator, bus := setupTestVentilator(t)
+	someErr := fmt.Errorf("Some sending failure")
+	bus.respond = func(request encoding.Frame, response serial.Response) serial.Response {
+		return serial.Response{Err: someErr}
+	}
+
+	_, err := ventilator.FanLevel(context.Background())
+	test.ErrorIs(t, err, someErr)
+}
+
+func TestUnexpectedResponse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		respond func(request encoding.Frame, response serial.Response) serial.Response
+	}{
+		{"other address", func(request encoding.Frame, response serial.Response) serial.Response {
+			response.Response, _ = encoding.NewFrame(response.Response.FrameType(), 11, request.Function(), request.Value())
+			return response
+		}},
+		{"other function", func(request encoding.Frame, response serial.Response) serial.Response {
+			response.Response, _ = encoding.NewFrame(response.Response.FrameType(), request.Address(), request.Function()+1, request.Value())
+			return response
+		}},
+		{"other type", func(request encoding.Frame, response serial.Response) serial.Response {
+			response.Response, _ = encoding.NewReadResponse(request.Address(), request.Function(), request.Value())
+			return response
+		}},
+		{"other value", func(request encoding.Frame, response serial.Response) serial.Response {
+			response.Response, _ = encoding.NewFrame(response.Response.FrameType(), request.Address(), request.Function(), request.Value()+1)
+			return response
+		}},
+		{"no response", func(request encoding.Frame, response serial.Response) serial.Response {
+			return serial.Response{}
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ventilator, bus := setupTestVentilator(t)
+			bus.respond = tc.respond
+
+			err := ventilator.SetFanLevel(context.Background(), 2)
+			test.ErrorIs(t, err, UnexpectedResponseError)
+		})
+	}
+}
+
+func TestContextDone(t *testing.T) {
+	// Nobody reads the requests
+	ventilator, err := NewVentilator(10, make(chan serial.Request), catalog.Default())
+	must.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ventilator.FanLevel(ctx)
+	test.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Nobody answers the requests
+	requests := make(chan serial.Request, 1)
+	ventilator, err = NewVentilator(10, requests, catalog.Default())
+	must.NoError(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ventilator.FanLevel(ctx)
+	test.ErrorIs(t, err, context.DeadlineExceeded)
+	must.EqOp(t, 1, len(requests))
+	test.Eq(t, ctx, (<-requests).Context)
+}
+
+func TestDroppedRequest(t *testing.T) {
+	requests := make(chan serial.Request)
+	go func() {
+		request := <-requests
+		close(request.ResponseChannel)
+	}()
+	ventilator, err := NewVentilator(10, requests, catalog.Default())
+	must.NoError(t, err)
+
+	_, err = ventilator.FanLevel(context.Background())
+	test.ErrorContains(t, err, "dropped")
+}
+
+func TestRequestPriority(t *testing.T) {
+	requests := make(chan serial.Request, 2)
+	ventilator, err := NewVentilator(10, requests, catalog.Default())
+	must.NoError(t, err)
+
+	// Nobody answers the requests
+	for _, ctx := range []context.Context{context.Background(), serial.WithPriority(context.Background(), serial.Interactive)} {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		ventilator.FanLevel(ctx)
+	}
+	must.EqOp(t, 2, len(requests))
+	test.EqOp(t, serial.Control, (<-requests).Priority)
+	test.EqOp(t, serial.Interactive, (<-requests).Priority)
+}
@@ -0,0 +1,158 @@
+package encoding
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ansel1/merry/v2"
+)
+
+// DEFAULT_DIALECT_NAME is the name of the DefaultDialect
+const DEFAULT_DIALECT_NAME = "default"
+
+// MAXIMUM_DIGITS is the maximum number of digits of a field in any dialect
+const MAXIMUM_DIGITS = 5
+
+// Dialect describes a variant of the serial protocol.
+// It defines the characters used in a frame and the number of digits of its fields.
+type Dialect struct {
+	// Name is the name the dialect is registered under
+	Name string
+	// StartChar is the first character of a frame (CHAR_LF in the default dialect)
+	StartChar byte
+	// EndChar is the last character of a frame (CHAR_CR in the default dialect)
+	EndChar byte
+	// MarkerChar follows the ReadChar or WriteChar of a frame (CHAR_WRG in the default dialect)
+	MarkerChar byte
+	// ReadChar marks a read frame (CHAR_READ in the default dialect)
+	ReadChar byte
+	// WriteChar marks a write frame (CHAR_WRITE in the default dialect)
+	WriteChar byte
+	// ResponseChar marks a response frame (CHAR_RESPONSE in the default dialect)
+	ResponseChar byte
+	// InvalidChar replaces function and value in a response to a frame with an invalid function (CHAR_INVALID in the default dialect)
+	InvalidChar byte
+	// AddressDigits is the number of digits of the address
+	AddressDigits int
+	// FunctionDigits is the number of digits of the function
+	FunctionDigits int
+	// ValueDigits is the number of digits of the value
+	ValueDigits int
+}
+
+// DefaultDialect is the dialect spoken by most ventilators.
+// It is the dialect described by the TEMPLATE_* and REGEX_* constants.
+var DefaultDialect = Dialect{
+	Name:           DEFAULT_DIALECT_NAME,
+	StartChar:      CHAR_LF,
+	EndChar:        CHAR_CR,
+	MarkerChar:     CHAR_WRG,
+	ReadChar:       CHAR_READ,
+	WriteChar:      CHAR_WRITE,
+	ResponseChar:   CHAR_RESPONSE,
+	InvalidChar:    CHAR_INVALID,
+	AddressDigits:  3,
+	FunctionDigits: 3,
+	ValueDigits:    3,
+}
+
+// Validate checks whether the dialect can be used to encode and decode all valid frames unambiguously
+func (dialect Dialect) Validate() error {
+	if dialect.Name == "" {
+		return merry.New("The dialect must have a name")
+	}
+
+	chars := []struct {
+		name string
+		char byte
+	}{
+		{"start", dialect.StartChar},
+		{"end", dialect.EndChar},
+		{"marker", dialect.MarkerChar},
+		{"read", dialect.ReadChar},
+		{"write", dialect.WriteChar},
+		{"response", dialect.ResponseChar},
+		{"invalid", dialect.InvalidChar},
+	}
+	for i, char := range chars {
+		if char.char >= '0' && char.char <= '9' {
+			return merry.Errorf("The %s character of dialect %s must not be a digit", char.name, dialect.Name)
+		}
+		for _, other := range chars[i+1:] {
+			if char.char == other.char {
+				return merry.Errorf("The %s and %s character of dialect %s must be different", char.name, other.name, dialect.Name)
+			}
+		}
+	}
+
+	digits := []struct {
+		name    string
+		digits  int
+		maximum int
+	}{
+		{"address", dialect.AddressDigits, MAXIMUM_ADDRESS},
+		{"function", dialect.FunctionDigits, MAXIMUM_FUNCTION},
+		{"value", dialect.ValueDigits, MAXIMUM_VALUE},
+	}
+	for _, digit := range digits {
+		// The field must be able to hold the maximum value
+		minimumDigits := len(fmt.Sprint(digit.maximum))
+		if digit.digits < minimumDigits || digit.digits > MAXIMUM_DIGITS {
+			return merry.Errorf("The number of %s digits of dialect %s must be between %d and %d (inclusive). It was %d",
+				digit.name, dialect.Name, minimumDigits, MAXIMUM_DIGITS, digit.digits)
+		}
+	}
+
+	return nil
+}
+
+var (
+	dialectsLock sync.RWMutex
+	dialects     = map[string]Dialect{DEFAULT_DIALECT_NAME: DefaultDialect}
+)
+
+// RegisterDialect validates the given dialect and makes it available under its name
+func RegisterDialect(dialect Dialect) error {
+	if err := dialect.Validate(); err != nil {
+		return merry.Prependf(err, "Failed to register dialect %s", dialect.Name)
+	}
+
+	dialectsLock.Lock()
+	defer dialectsLock.Unlock()
+
+	if _, exists := dialects[dialect.Name]; exists {
+		return merry.Errorf("A dialect with the name %s is already registered", dialect.Name)
+	}
+	dialects[dialect.Name] = dialect
+	return nil
+}
+
+// LookupDialect returns the dialect registered under the given name
+func LookupDialect(name string) (Dialect, error) {
+	dialectsLock.RLock()
+	defer dialectsLock.RUnlock()
+
+	dialect, exists := dialects[name]
+	if !exists {
+		return Dialect{}, merry.Errorf("Unknown dialect %s. Known dialects are: %v", name, dialectNames())
+	}
+	return dialect, nil
+}
+
+// DialectNames returns the sorted names of all registered dialects
+func DialectNames() []string {
+	dialectsLock.RLock()
+	defer dialectsLock.RUnlock()
+
+	return dialectNames()
+}
+
+func dialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,171 @@
+package encoding
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+// testDialect is a dialect differing from the DefaultDialect in all characters and field widths
+var testDialect = Dialect{
+	Name:           "test",
+	StartChar:      '{',
+	EndChar:        '}',
+	MarkerChar:     'X',
+	ReadChar:       'r',
+	WriteChar:      'w',
+	ResponseChar:   '=',
+	InvalidChar:    '!',
+	AddressDigits:  4,
+	FunctionDigits: 4,
+	ValueDigits:    5,
+}
+
+func TestDefaultDialectMatchesConstants(t *testing.T) {
+	must.NoError(t, DefaultDialect.Validate())
+
+	test.EqOp(t, TEMPLATE_WRITE, DefaultDialect.writeTemplate())
+	test.EqOp(t, TEMPLATE_READ, DefaultDialect.readTemplate())
+	test.EqOp(t, TEMPLATE_WRITE_RESPONSE, DefaultDialect.responseTemplate(CHAR_WRITE))
+	test.EqOp(t, TEMPLATE_READ_RESPONSE, DefaultDialect.responseTemplate(CHAR_READ))
+	test.EqOp(t, TEMPLATE_INVALID_FUNCTION_RESPONSE, DefaultDialect.invalidFunctionResponseTemplate())
+	test.EqOp(t, REGEX_RESPONSE, DefaultDialect.responseRegex())
+	test.EqOp(t, REGEX_REQUEST, DefaultDialect.requestRegex())
+}
+
+func TestDialectValidateBad(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(*Dialect)
+	}{
+		{"no name", func(d *Dialect) { d.Name = "" }},
+		{"digit as start", func(d *Dialect) { d.StartChar = '1' }},
+		{"same start and end", func(d *Dialect) { d.EndChar = d.StartChar }},
+		{"same read and write", func(d *Dialect) { d.WriteChar = d.ReadChar }},
+		{"same marker and response", func(d *Dialect) { d.ResponseChar = d.MarkerChar }},
+		{"address too short", func(d *Dialect) { d.AddressDigits = 2 }},
+		{"function too short", func(d *Dialect) { d.FunctionDigits = 2 }},
+		{"value too short", func(d *Dialect) { d.ValueDigits = 0 }},
+		{"value too long", func(d *Dialect) { d.ValueDigits = MAXIMUM_DIGITS + 1 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dialect := DefaultDialect
+			tc.modify(&dialect)
+			test.Error(t, dialect.Validate())
+		})
+	}
+}
+
+func TestRegisterDialect(t *testing.T) {
+	dialect := testDialect
+	dialect.Name = "registerTest"
+
+	err := RegisterDialect(dialect)
+	must.NoError(t, err)
+	t.Cleanup(func() { unregisterDialect("registerTest") })
+
+	registered, err := LookupDialect("registerTest")
+	must.NoError(t, err)
+	test.Eq(t, dialect, registered)
+	test.SliceContains(t, DialectNames(), "registerTest")
+	test.SliceContains(t, DialectNames(), DEFAULT_DIALECT_NAME)
+
+	err = RegisterDialect(dialect)
+	test.ErrorContains(t, err, "already registered")
+
+	dialect.Name = "invalidRegisterTest"
+	dialect.EndChar = dialect.StartChar
+	err = RegisterDialect(dialect)
+	test.Error(t, err)
+	_, err = LookupDialect("invalidRegisterTest")
+	test.ErrorContains(t, err, "Unknown dialect")
+}
+
+// unregisterDialect removes a dialect registered by a test so the test can run repeatedly
+func unregisterDialect(name string) {
+	dialectsLock.Lock()
+	defer dialectsLock.Unlock()
+
+	delete(dialects, name)
+}
+
+func TestLookupDefaultDialect(t *testing.T) {
+	dialect, err := LookupDialect(DEFAULT_DIALECT_NAME)
+	must.NoError(t, err)
+	test.Eq(t, DefaultDialect, dialect)
+}
+
+func TestNewSerialEncoderForInvalidDialect(t *testing.T) {
+	dialect := testDialect
+	dialect.ValueDigits = 1
+
+	_, err := NewSerialEncoderForDialect(dialect)
+	test.Error(t, err)
+}
+
+func TestEncodeDecodeWithDialect(t *testing.T) {
+	encoder, err := NewSerialEncoderForDialect(testDialect)
+	must.NoError(t, err)
+	test.Eq(t, testDialect, encoder.Dialect())
+
+	readRequest, err := NewReadRequest(10, 20)
+	must.NoError(t, err)
+	writeRequest, err := NewWriteRequest(10, 20, 30)
+	must.NoError(t, err)
+	readResponse, err := NewReadResponse(10, 20, 30)
+	must.NoError(t, err)
+	writeResponse, err := NewWriteResponse(10, 20, 30)
+	must.NoError(t, err)
+
+	testCases := []struct {
+		frame  Frame
+		result string
+	}{
+		{readRequest, "{0010rX0020}"},
+		{writeRequest, "{0010wX002000030}"},
+		{readResponse, "{0010rX=002000030}"},
+		{writeResponse, "{0010wX=002000030}"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.frame.FrameType()), func(t *testing.T) {
+			result, err := encoder.Encode(tc.frame)
+			must.NoError(t, err)
+			test.EqOp(t, tc.result, result)
+
+			var decoded Frame
+			if tc.frame.FrameType() == ReadRequest || tc.frame.FrameType() == WriteRequest {
+				decoded, err = encoder.DecodeRequest(result)
+			} else {
+				decoded, err = encoder.Decode(result)
+			}
+			must.NoError(t, err)
+			test.Eq(t, tc.frame, decoded)
+		})
+	}
+
+	invalid, err := encoder.EncodeInvalidFunctionResponse(writeRequest)
+	must.NoError(t, err)
+	test.EqOp(t, "{0010wX=!}", invalid)
+	_, err = encoder.Decode(invalid)
+	test.ErrorIs(t, err, FunctionRejectedError)
+
+	_, err = encoder.Decode("\n010lW#020030\r")
+	test.Error(t, err)
+}
+
+func TestStreamDecoderWithDialect(t *testing.T) {
+	encoder, err := NewSerialEncoderForDialect(testDialect)
+	must.NoError(t, err)
+
+	decoder := NewStreamDecoderForDialect(strings.NewReader("\n010lW#020030\r{0010rX=00{0011rX=002100031}"), testDialect, encoder.DecodeBytes)
+
+	frame, skipped, err := decoder.Next()
+	must.NoError(t, err)
+	testFrameValues(t, frame, 11, ReadResponse, 21, 31)
+	test.EqOp(t, 24, skipped)
+}
@@ -1,6 +1,10 @@
 package encoding
 
-import "github.com/ansel1/merry/v2"
+import (
+	"math"
+
+	"github.com/ansel1/merry/v2"
+)
 
 const (
 	MINIMUM_ADDRESS  = 1
@@ -68,44 +72,160 @@ type Frame interface {
 	markAsValidFrame()
 }
 
-// NewReadRequest creates a new read request
-func NewReadRequest(address int, function int) (Frame, error) {
+// A DecodedFrame is a Frame that SerialEncoder.DecodeBytesInto decodes into.
+// Reusing it avoids allocating a frame for every decoded frame.
+// The zero value is not a valid frame, it becomes one once a frame has been decoded into it.
+type DecodedFrame struct {
+	frame frame
+}
+
+// FrameType gets the type of the frame
+func (f *DecodedFrame) FrameType() FrameType {
+	return f.frame.FrameType()
+}
+
+// Address gets the address of the ventilator this frame is for/from
+func (f *DecodedFrame) Address() int {
+	return f.frame.Address()
+}
+
+// Function gets the function of the ventilator to be read/written or that was read from / written to
+func (f *DecodedFrame) Function() int {
+	return f.frame.Function()
+}
+
+// Value gets the value to be written or the value that was read/written
+func (f *DecodedFrame) Value() int {
+	return f.frame.Value()
+}
+
+func (f *DecodedFrame) markAsValidFrame() { /*Intentionally empty*/ }
+
+// A FrameValidator checks frames against constraints beyond the valid ranges of their fields
+// (e.g. whether a device offers the function of the frame)
+type FrameValidator interface {
+	// ValidateFrame returns an error if the given frame is not valid
+	ValidateFrame(frame Frame) error
+}
+
+func checkAddress(address int) error {
 	if address < MINIMUM_ADDRESS || address > MAXIMUM_ADDRESS {
-		return nil, merry.Errorf("The address must be between %d and %d (inclusive). It was %d", MINIMUM_ADDRESS, MAXIMUM_ADDRESS, address)
+		return merry.Errorf("The address must be between %d and %d (inclusive). It was %d", MINIMUM_ADDRESS, MAXIMUM_ADDRESS, address)
 	}
+	return nil
+}
+
+func checkFunction(function int) error {
 	if function < MINIMUM_FUNCTION || function > MAXIMUM_FUNCTION {
-		return nil, merry.Errorf("The function must be between %d and %d (inclusive). It was %d", MINIMUM_FUNCTION, MAXIMUM_FUNCTION, function)
+		return merry.Errorf("The function must be between %d and %d (inclusive). It was %d", MINIMUM_FUNCTION, MAXIMUM_FUNCTION, function)
+	}
+	return nil
+}
+
+func checkValue(value int) error {
+	if value < MINIMUM_VALUE || value > MAXIMUM_VALUE {
+		return merry.Errorf("The value must be between %d and %d (inclusive). It was %d", MINIMUM_VALUE, MAXIMUM_VALUE, value)
+	}
+	return nil
+}
+
+// NewReadRequest creates a new read request
+func NewReadRequest(address int, function int) (Frame, error) {
+	if err := checkAddress(address); err != nil {
+		return nil, err
+	}
+	if err := checkFunction(function); err != nil {
+		return nil, err
 	}
 	return &frame{FrameType_: ReadRequest, Address_: uint16(address), Function_: uint16(function)}, nil
 }
 
 // NewWriteRequest creates a new Write request
 func NewWriteRequest(address int, function int, value int) (Frame, error) {
-	if address < MINIMUM_ADDRESS || address > MAXIMUM_ADDRESS {
-		return nil, merry.Errorf("The address must be between %d and %d (inclusive). It was %d", MINIMUM_ADDRESS, MAXIMUM_ADDRESS, address)
+	if err := checkAddress(address); err != nil {
+		return nil, err
 	}
-	if function < MINIMUM_FUNCTION || function > MAXIMUM_FUNCTION {
-		return nil, merry.Errorf("The function must be between %d and %d (inclusive). It was %d", MINIMUM_FUNCTION, MAXIMUM_FUNCTION, function)
+	if err := checkFunction(function); err != nil {
+		return nil, err
 	}
-	if value < MINIMUM_VALUE || value > MAXIMUM_VALUE {
-		return nil, merry.Errorf("The value must be between %d and %d (inclusive). It was %d", MINIMUM_VALUE, MAXIMUM_VALUE, value)
+	if err := checkValue(value); err != nil {
+		return nil, err
 	}
 	return &frame{FrameType_: WriteRequest, Address_: uint16(address), Function_: uint16(function), Value_: uint16(value)}, nil
 }
 
+// NewFrame creates a new frame of the given type.
+// A read request has no value, so the value must be 0 for it.
+func NewFrame(frameType FrameType, address int, function int, value int) (Frame, error) {
+	switch frameType {
+	case ReadRequest:
+		if value != 0 {
+			return nil, merry.Errorf("A read request has no value. It was %d", value)
+		}
+		return NewReadRequest(address, function)
+	case WriteRequest:
+		return NewWriteRequest(address, function, value)
+	case ReadResponse, WriteResponse:
+		return newResponseFromInts(frameType, address, function, value)
+	default:
+		return nil, merry.Errorf("Unknown frame type: %s", frameType)
+	}
+}
+
+// NewReadResponse creates a new response to a read request
+func NewReadResponse(address int, function int, value int) (Frame, error) {
+	return newResponseFromInts(ReadResponse, address, function, value)
+}
+
+// NewWriteResponse creates a new response to a write request
+func NewWriteResponse(address int, function int, value int) (Frame, error) {
+	return newResponseFromInts(WriteResponse, address, function, value)
+}
+
+// newResponseFromInts converts the given values and creates a new response.
+// Values outside the uint16 range are rejected before the conversion so they cannot wrap around into the valid range.
+func newResponseFromInts(frameType FrameType, address int, function int, value int) (Frame, error) {
+	address16, err := toUint16(address, checkAddress)
+	if err != nil {
+		return nil, err
+	}
+	function16, err := toUint16(function, checkFunction)
+	if err != nil {
+		return nil, err
+	}
+	value16, err := toUint16(value, checkValue)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := newReponse(frameType, address16, function16, value16)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// toUint16 converts the given value to uint16.
+// A value outside the uint16 range is also outside the range accepted by check, so the error of check is returned for it.
+func toUint16(value int, check func(int) error) (uint16, error) {
+	if value < 0 || value > math.MaxUint16 {
+		return 0, check(value)
+	}
+	return uint16(value), nil
+}
+
 // newResponse creates a new response
 func newReponse(frameType FrameType, address uint16, function uint16, value uint16) (*frame, error) {
 	if !(frameType == ReadResponse || frameType == WriteResponse) {
 		return nil, merry.Errorf("Invalid frame type for a response: %s", frameType)
 	}
-	if address < MINIMUM_ADDRESS || address > MAXIMUM_ADDRESS {
-		return nil, merry.Errorf("The address must be between %d and %d (inclusive). It was %d", MINIMUM_ADDRESS, MAXIMUM_ADDRESS, address)
+	if err := checkAddress(int(address)); err != nil {
+		return nil, err
 	}
-	if function < MINIMUM_FUNCTION || function > MAXIMUM_FUNCTION {
-		return nil, merry.Errorf("The function must be between %d and %d (inclusive). It was %d", MINIMUM_FUNCTION, MAXIMUM_FUNCTION, function)
+	if err := checkFunction(int(function)); err != nil {
+		return nil, err
 	}
-	if value < MINIMUM_VALUE || value > MAXIMUM_VALUE {
-		return nil, merry.Errorf("The value must be between %d and %d (inclusive). It was %d", MINIMUM_VALUE, MAXIMUM_VALUE, value)
+	if err := checkValue(int(value)); err != nil {
+		return nil, err
 	}
 	return &frame{FrameType_: frameType, Address_: address, Function_: function, Value_: value}, nil
 }
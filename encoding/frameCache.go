@@ -0,0 +1,49 @@
+package encoding
+
+import "sync/atomic"
+
+// frameCacheBits is the number of bits used to index the frameCache
+const frameCacheBits = 10
+
+// frameCache is a direct mapped cache of frames.
+// As frames are immutable, the same frame can be returned every time the same data is decoded.
+// This avoids allocating a new frame for every frame decoded while polling the same functions repeatedly.
+// Only the frames decoded repeatedly are free: every miss allocates a new frame. That includes every frame whose value
+// changed since the slot was filled and frames whose type, address, function and value hash to the same slot as
+// another frame decoded in turn with them. Decoding into a DecodedFrame does not allocate for any frame.
+// It is safe for concurrent use.
+type frameCache struct {
+	slots [1 << frameCacheBits]atomic.Pointer[frame]
+}
+
+// frameTypeIndex returns a small number unique for each valid frame type
+func frameTypeIndex(frameType FrameType) uint64 {
+	switch frameType {
+	case ReadRequest:
+		return 0
+	case WriteRequest:
+		return 1
+	case ReadResponse:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// get returns a frame with the given values.
+// It returns the cached frame if there is one and creates and caches a new frame otherwise.
+// The values must already have been validated.
+func (cache *frameCache) get(frameType FrameType, address uint16, function uint16, value uint16) *frame {
+	key := frameTypeIndex(frameType)<<48 | uint64(address)<<32 | uint64(function)<<16 | uint64(value)
+	// Fibonacci hashing
+	slot := &cache.slots[(key*0x9E3779B97F4A7C15)>>(64-frameCacheBits)]
+
+	cached := slot.Load()
+	if cached != nil && cached.FrameType_ == frameType && cached.Address_ == address && cached.Function_ == function && cached.Value_ == value {
+		return cached
+	}
+
+	newFrame := &frame{FrameType_: frameType, Address_: address, Function_: function, Value_: value}
+	slot.Store(newFrame)
+	return newFrame
+}
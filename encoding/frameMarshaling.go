@@ -0,0 +1,163 @@
+package encoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry/v2"
+)
+
+// jsonFrame is the JSON representation of a frame.
+// The value is omitted for read requests.
+type jsonFrame struct {
+	Type     FrameType `json:"type"`
+	Address  *int      `json:"address"`
+	Function *int      `json:"function"`
+	Value    *int      `json:"value,omitempty"`
+}
+
+// MarshalJSON encodes the frame as JSON object with the fields type, address, function and value
+func (f frame) MarshalJSON() ([]byte, error) {
+	address, function, value := f.Address(), f.Function(), f.Value()
+	data := jsonFrame{Type: f.FrameType_, Address: &address, Function: &function}
+	if f.FrameType_ != ReadRequest {
+		data.Value = &value
+	}
+	return json.Marshal(data)
+}
+
+// MarshalText encodes the frame as its type followed by address, function and value separated by spaces.
+// The value is omitted for read requests (e.g. "readRequest 10 20" or "writeRequest 10 20 30").
+func (f frame) MarshalText() ([]byte, error) {
+	text := fmt.Sprintf("%s %d %d", f.FrameType_, f.Address_, f.Function_)
+	if f.FrameType_ != ReadRequest {
+		text += fmt.Sprintf(" %d", f.Value_)
+	}
+	return []byte(text), nil
+}
+
+// UnmarshalFrameJSON decodes a frame from the JSON representation created by MarshalJSON.
+// The frame is validated like in NewFrame.
+func UnmarshalFrameJSON(data []byte) (Frame, error) {
+	var decoded jsonFrame
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, merry.Prepend(err, "Failed to decode frame from JSON")
+	}
+	if decoded.Address == nil {
+		return nil, merry.New("The frame has no address")
+	}
+	if decoded.Function == nil {
+		return nil, merry.New("The frame has no function")
+	}
+
+	value := 0
+	if decoded.Value != nil {
+		value = *decoded.Value
+	} else if decoded.Type != ReadRequest {
+		return nil, merry.Errorf("The frame of type %s has no value", decoded.Type)
+	}
+
+	return NewFrame(decoded.Type, *decoded.Address, *decoded.Function, value)
+}
+
+// ParseFrame decodes a frame from the text representation created by MarshalText.
+// The frame is validated like in NewFrame.
+func ParseFrame(text string) (Frame, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, merry.New("Can't parse a frame from empty text")
+	}
+
+	frameType := FrameType(fields[0])
+	expectedFields := 4
+	if frameType == ReadRequest {
+		expectedFields = 3
+	}
+	if len(fields) != expectedFields {
+		return nil, merry.Errorf("A frame of type %s must consist of %d fields. It had %d: %s", frameType, expectedFields, len(fields), text)
+	}
+
+	numbers := make([]int, 3)
+	for i, field := range fields[1:] {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, merry.Prependf(err, "Failed to parse frame: %s", text)
+		}
+		numbers[i] = number
+	}
+
+	return NewFrame(frameType, numbers[0], numbers[1], numbers[2])
+}
+
+// FrameValue holds a Frame and makes it usable with encoding/json and other packages
+// relying on the encoding.TextUnmarshaler interface (e.g. as field of a struct).
+// A zero FrameValue holds no frame and is marshaled as null or empty text.
+// The frame is held in a field instead of being embedded, so a zero FrameValue can't be used as a nil Frame by accident.
+type FrameValue struct {
+	// Frame is the frame held. It is nil if no frame is held.
+	Frame Frame
+}
+
+// IsZero returns whether no frame is held
+func (value FrameValue) IsZero() bool {
+	return value.Frame == nil
+}
+
+// String returns the text representation of the held frame or an empty string if none is held
+func (value FrameValue) String() string {
+	text, err := value.MarshalText()
+	if err != nil {
+		return fmt.Sprint(value.Frame)
+	}
+	return string(text)
+}
+
+// MarshalJSON encodes the held frame as JSON
+func (value FrameValue) MarshalJSON() ([]byte, error) {
+	if value.Frame == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(value.Frame)
+}
+
+// UnmarshalJSON decodes the held frame from JSON using UnmarshalFrameJSON
+func (value *FrameValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		value.Frame = nil
+		return nil
+	}
+	frame, err := UnmarshalFrameJSON(data)
+	if err != nil {
+		return err
+	}
+	value.Frame = frame
+	return nil
+}
+
+// MarshalText encodes the held frame as text
+func (value FrameValue) MarshalText() ([]byte, error) {
+	if value.Frame == nil {
+		return []byte{}, nil
+	}
+	marshaler, ok := value.Frame.(interface{ MarshalText() ([]byte, error) })
+	if !ok {
+		return nil, merry.Errorf("The frame %v can't be marshaled as text", value.Frame)
+	}
+	return marshaler.MarshalText()
+}
+
+// UnmarshalText decodes the held frame from text using ParseFrame
+func (value *FrameValue) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		value.Frame = nil
+		return nil
+	}
+	frame, err := ParseFrame(string(text))
+	if err != nil {
+		return err
+	}
+	value.Frame = frame
+	return nil
+}
@@ -0,0 +1,194 @@
+package encoding
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestNewFrameGood(t *testing.T) {
+	for _, frameType := range []FrameType{ReadRequest, WriteRequest, ReadResponse, WriteResponse} {
+		t.Run(string(frameType), func(t *testing.T) {
+			value := 30
+			if frameType == ReadRequest {
+				value = 0
+			}
+			frame, err := NewFrame(frameType, 10, 20, value)
+			must.NoError(t, err)
+			testFrameValues(t, frame, 10, frameType, 20, value)
+		})
+	}
+}
+
+func TestNewFrameBad(t *testing.T) {
+	testCases := []struct {
+		name      string
+		frameType FrameType
+		address   int
+		function  int
+		value     int
+	}{
+		{"unknown type", FrameType("foo"), 10, 20, 30},
+		{"empty type", FrameType(""), 10, 20, 30},
+		{"read request with value", ReadRequest, 10, 20, 30},
+		{"address too low", WriteRequest, MINIMUM_ADDRESS - 1, 20, 30},
+		{"function too high", ReadResponse, 10, MAXIMUM_FUNCTION + 1, 30},
+		{"value too high", WriteResponse, 10, 20, MAXIMUM_VALUE + 1},
+		{"value wraps around", WriteResponse, 10, 20, 65536 + 30},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			frame, err := NewFrame(tc.frameType, tc.address, tc.function, tc.value)
+			test.Error(t, err)
+			test.Nil(t, frame)
+		})
+	}
+}
+
+func TestFrameMarshaling(t *testing.T) {
+	readRequest, err := NewReadRequest(10, 20)
+	must.NoError(t, err)
+	writeRequest, err := NewWriteRequest(10, 20, 30)
+	must.NoError(t, err)
+	readResponse, err := NewReadResponse(10, 20, 30)
+	must.NoError(t, err)
+	writeResponse, err := NewWriteResponse(10, 20, 30)
+	must.NoError(t, err)
+
+	testCases := []struct {
+		frame Frame
+		json  string
+		text  string
+	}{
+		{readRequest, `{"type":"readRequest","address":10,"function":20}`, "readRequest 10 20"},
+		{writeRequest, `{"type":"writeRequest","address":10,"function":20,"value":30}`, "writeRequest 10 20 30"},
+		{readResponse, `{"type":"readResponse","address":10,"function":20,"value":30}`, "readResponse 10 20 30"},
+		{writeResponse, `{"type":"writeResponse","address":10,"function":20,"value":30}`, "writeResponse 10 20 30"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.frame.FrameType()), func(t *testing.T) {
+			data, err := json.Marshal(tc.frame)
+			must.NoError(t, err)
+			test.EqOp(t, tc.json, string(data))
+
+			decoded, err := UnmarshalFrameJSON(data)
+			must.NoError(t, err)
+			test.Eq(t, tc.frame, decoded)
+
+			var value FrameValue
+			must.NoError(t, json.Unmarshal(data, &value))
+			test.Eq(t, tc.frame, value.Frame)
+
+			text, err := FrameValue{tc.frame}.MarshalText()
+			must.NoError(t, err)
+			test.EqOp(t, tc.text, string(text))
+
+			decoded, err = ParseFrame(string(text))
+			must.NoError(t, err)
+			test.Eq(t, tc.frame, decoded)
+
+			value = FrameValue{}
+			must.NoError(t, value.UnmarshalText(text))
+			test.Eq(t, tc.frame, value.Frame)
+		})
+	}
+}
+
+func TestFrameValueInStruct(t *testing.T) {
+	frame, err := NewWriteRequest(10, 20, 30)
+	must.NoError(t, err)
+
+	type recording struct {
+		Sent     FrameValue            `json:"sent"`
+		Received FrameValue            `json:"received"`
+		ByName   map[string]FrameValue `json:"byName"`
+	}
+	data, err := json.Marshal(recording{Sent: FrameValue{frame}, ByName: map[string]FrameValue{"a": {frame}}})
+	must.NoError(t, err)
+	test.EqOp(t, `{"sent":{"type":"writeRequest","address":10,"function":20,"value":30},"received":null,`+
+		`"byName":{"a":{"type":"writeRequest","address":10,"function":20,"value":30}}}`, string(data))
+
+	var decoded recording
+	must.NoError(t, json.Unmarshal(data, &decoded))
+	test.Eq(t, frame, decoded.Sent.Frame)
+	test.Nil(t, decoded.Received.Frame)
+	test.Eq(t, frame, decoded.ByName["a"].Frame)
+}
+
+func TestFrameValueZero(t *testing.T) {
+	var value FrameValue
+	test.True(t, value.IsZero())
+	test.Nil(t, value.Frame)
+	test.EqOp(t, "", value.String())
+
+	data, err := json.Marshal(value)
+	must.NoError(t, err)
+	test.EqOp(t, "null", string(data))
+	text, err := value.MarshalText()
+	must.NoError(t, err)
+	test.EqOp(t, "", string(text))
+
+	frame, err := NewReadRequest(10, 20)
+	must.NoError(t, err)
+	value = FrameValue{frame}
+	test.False(t, value.IsZero())
+	test.EqOp(t, "readRequest 10 20", value.String())
+}
+
+func TestUnmarshalFrameJSONBad(t *testing.T) {
+	testCases := []struct {
+		name string
+		json string
+	}{
+		{"no json", `readRequest`},
+		{"no object", `[]`},
+		{"unknown type", `{"type":"foo","address":10,"function":20,"value":30}`},
+		{"no type", `{"address":10,"function":20,"value":30}`},
+		{"no address", `{"type":"writeRequest","function":20,"value":30}`},
+		{"no function", `{"type":"writeRequest","address":10,"value":30}`},
+		{"no value", `{"type":"writeResponse","address":10,"function":20}`},
+		{"read request with value", `{"type":"readRequest","address":10,"function":20,"value":30}`},
+		{"address out of range", `{"type":"readRequest","address":0,"function":20}`},
+		{"value out of range", `{"type":"writeRequest","address":10,"function":20,"value":1000}`},
+		{"fractional value", `{"type":"writeRequest","address":10,"function":20,"value":1.5}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			frame, err := UnmarshalFrameJSON([]byte(tc.json))
+			test.Error(t, err)
+			test.Nil(t, frame)
+
+			var value FrameValue
+			test.Error(t, json.Unmarshal([]byte(tc.json), &value))
+		})
+	}
+}
+
+func TestParseFrameBad(t *testing.T) {
+	testCases := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"unknown type", "foo 10 20 30"},
+		{"missing value", "writeRequest 10 20"},
+		{"read request with value", "readRequest 10 20 30"},
+		{"too many fields", "writeResponse 10 20 30 40"},
+		{"no number", "writeRequest 10 x 30"},
+		{"address out of range", "readResponse 251 20 30"},
+		{"negative value", "writeRequest 10 20 -1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			frame, err := ParseFrame(tc.text)
+			test.Error(t, err)
+			test.Nil(t, frame)
+		})
+	}
+}
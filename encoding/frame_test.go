@@ -170,3 +170,41 @@ func TestNewReponseBadFrameType(t *testing.T) {
 		})
 	}
 }
+
+func TestNewPublicResponseGood(t *testing.T) {
+	testCases := testCasesForCombiantionsOfExcept([]int{DEFAULT_ADDRESS, MINIMUM_ADDRESS, MAXIMUM_ADDRESS}, []int{DEFAULT_FUNCTION, MINIMUM_FUNCTION, MAXIMUM_FUNCTION}, []int{DEFAULT_VALUE, MINIMUM_VALUE, MAXIMUM_VALUE}, func(tc frameTestCase) bool {
+		return false
+	})
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf(`NewReadResponse(%d, %d, %d)`, tc.address, tc.function, tc.value), func(t *testing.T) {
+			frame, err := NewReadResponse(tc.address, tc.function, tc.value)
+			must.NoError(t, err)
+			testFrameValues(t, frame, tc.address, ReadResponse, tc.function, tc.value)
+		})
+		t.Run(fmt.Sprintf(`NewWriteResponse(%d, %d, %d)`, tc.address, tc.function, tc.value), func(t *testing.T) {
+			frame, err := NewWriteResponse(tc.address, tc.function, tc.value)
+			must.NoError(t, err)
+			testFrameValues(t, frame, tc.address, WriteResponse, tc.function, tc.value)
+		})
+	}
+}
+
+func TestNewPublicResponseBad(t *testing.T) {
+	testCases := testCasesForCombiantionsOfExcept([]int{DEFAULT_ADDRESS, MINIMUM_ADDRESS - 1, MAXIMUM_ADDRESS + 1, 65536 + DEFAULT_ADDRESS}, []int{DEFAULT_FUNCTION, MINIMUM_FUNCTION - 1, MAXIMUM_FUNCTION + 1, 65536 + DEFAULT_FUNCTION}, []int{DEFAULT_VALUE, MINIMUM_VALUE - 1, MAXIMUM_VALUE + 1, 65536 + DEFAULT_VALUE}, func(tc frameTestCase) bool {
+		return tc.address == DEFAULT_ADDRESS && tc.function == DEFAULT_FUNCTION && tc.value == DEFAULT_VALUE
+	})
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf(`NewReadResponse(%d, %d, %d)`, tc.address, tc.function, tc.value), func(t *testing.T) {
+			frame, err := NewReadResponse(tc.address, tc.function, tc.value)
+			test.Error(t, err)
+			test.Nil(t, frame)
+		})
+		t.Run(fmt.Sprintf(`NewWriteResponse(%d, %d, %d)`, tc.address, tc.function, tc.value), func(t *testing.T) {
+			frame, err := NewWriteResponse(tc.address, tc.function, tc.value)
+			test.Error(t, err)
+			test.Nil(t, frame)
+		})
+	}
+}
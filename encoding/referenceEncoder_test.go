@@ -0,0 +1,409 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"testing"
+	"text/template"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/shoenig/test/must"
+)
+
+// templateNumber returns the template action printing the given field with the given number of digits
+func templateNumber(field string, digits int) string {
+	return fmt.Sprintf("{{printf \"%%0%dd\" .%s}}", digits, field)
+}
+
+// templateLiteral returns the template text printing the given character.
+// Braces are printed using an action, as they would be mistaken for template delimiters otherwise.
+func templateLiteral(char byte) string {
+	if char == '{' || char == '}' {
+		return fmt.Sprintf("{{%q}}", string(char))
+	}
+	return string(char)
+}
+
+// regexDigits returns the regex matching a number with the given number of digits
+func regexDigits(digits int) string {
+	return fmt.Sprintf("([0-9]{%d})", digits)
+}
+
+// regexQuote returns the regex matching exactly the given character
+func regexQuote(char byte) string {
+	return regexp.QuoteMeta(string(char))
+}
+
+// writeTemplate returns the template for creating a write frame in this dialect
+func (dialect Dialect) writeTemplate() string {
+	return templateLiteral(dialect.StartChar) + templateNumber("Address", dialect.AddressDigits) + templateLiteral(dialect.WriteChar) + templateLiteral(dialect.MarkerChar) +
+		templateNumber("Function", dialect.FunctionDigits) + templateNumber("Value", dialect.ValueDigits) + templateLiteral(dialect.EndChar)
+}
+
+// readTemplate returns the template for creating a read frame in this dialect
+func (dialect Dialect) readTemplate() string {
+	return templateLiteral(dialect.StartChar) + templateNumber("Address", dialect.AddressDigits) + templateLiteral(dialect.ReadChar) + templateLiteral(dialect.MarkerChar) +
+		templateNumber("Function", dialect.FunctionDigits) + templateLiteral(dialect.EndChar)
+}
+
+// responseTemplate returns the template for creating a response to a read or write frame in this dialect
+func (dialect Dialect) responseTemplate(typeChar byte) string {
+	return templateLiteral(dialect.StartChar) + templateNumber("Address", dialect.AddressDigits) + templateLiteral(typeChar) + templateLiteral(dialect.MarkerChar) + templateLiteral(dialect.ResponseChar) +
+		templateNumber("Function", dialect.FunctionDigits) + templateNumber("Value", dialect.ValueDigits) + templateLiteral(dialect.EndChar)
+}
+
+// invalidFunctionResponseTemplate returns the template for creating the response to a frame with an invalid function in this dialect
+func (dialect Dialect) invalidFunctionResponseTemplate() string {
+	return templateLiteral(dialect.StartChar) + templateNumber("Address", dialect.AddressDigits) + "{{.Type}}" + templateLiteral(dialect.MarkerChar) + templateLiteral(dialect.ResponseChar) +
+		templateLiteral(dialect.InvalidChar) + templateLiteral(dialect.EndChar)
+}
+
+// responseRegex returns the regex that matches a response frame in this dialect
+func (dialect Dialect) responseRegex() string {
+	return regexQuote(dialect.StartChar) + regexDigits(dialect.AddressDigits) + "(" + regexQuote(dialect.ReadChar) + "|" + regexQuote(dialect.WriteChar) + ")" +
+		regexQuote(dialect.MarkerChar) + regexQuote(dialect.ResponseChar) +
+		"(" + regexDigits(dialect.FunctionDigits) + regexDigits(dialect.ValueDigits) + "|" + regexQuote(dialect.InvalidChar) + ")" + regexQuote(dialect.EndChar)
+}
+
+// requestRegex returns the regex that matches a read or write request frame in this dialect
+func (dialect Dialect) requestRegex() string {
+	return regexQuote(dialect.StartChar) + regexDigits(dialect.AddressDigits) +
+		"(?:" + regexQuote(dialect.ReadChar) + regexQuote(dialect.MarkerChar) + regexDigits(dialect.FunctionDigits) +
+		"|" + regexQuote(dialect.WriteChar) + regexQuote(dialect.MarkerChar) + regexDigits(dialect.FunctionDigits) + regexDigits(dialect.ValueDigits) + ")" +
+		regexQuote(dialect.EndChar)
+}
+
+// referenceEncoder is the text/template and regexp based implementation the serialEncoder replaced.
+// It is kept to test the serialEncoder against it.
+type referenceEncoder struct {
+	dialect                         Dialect
+	writeTemplate                   *template.Template
+	readTemplate                    *template.Template
+	writeResponseTemplate           *template.Template
+	readResponseTemplate            *template.Template
+	invalidFunctionResponseTemplate *template.Template
+	responseRegex                   *regexp.Regexp
+	requestRegex                    *regexp.Regexp
+}
+
+func buildTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, merry.Prependf(err, "Failed building %s template", name)
+	}
+	return tmpl, nil
+}
+
+func (referenceEncoder *referenceEncoder) buildTemplates() error {
+	dialect := referenceEncoder.dialect
+	var err error
+	if referenceEncoder.writeTemplate, err = buildTemplate("WriteFrame", dialect.writeTemplate()); err != nil {
+		return err
+	}
+	if referenceEncoder.readTemplate, err = buildTemplate("ReadFrame", dialect.readTemplate()); err != nil {
+		return err
+	}
+	if referenceEncoder.writeResponseTemplate, err = buildTemplate("WriteResponseFrame", dialect.responseTemplate(dialect.WriteChar)); err != nil {
+		return err
+	}
+	if referenceEncoder.readResponseTemplate, err = buildTemplate("ReadResponseFrame", dialect.responseTemplate(dialect.ReadChar)); err != nil {
+		return err
+	}
+	if referenceEncoder.invalidFunctionResponseTemplate, err = buildTemplate("InvalidFunctionResponseFrame", dialect.invalidFunctionResponseTemplate()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (referenceEncoder *referenceEncoder) buildRegex() error {
+	re, err := regexp.Compile(referenceEncoder.dialect.responseRegex())
+	if err != nil {
+		return merry.Prepend(err, "Failed building Response regex")
+	}
+	referenceEncoder.responseRegex = re
+
+	re, err = regexp.Compile(referenceEncoder.dialect.requestRegex())
+	if err != nil {
+		return merry.Prepend(err, "Failed building Request regex")
+	}
+	referenceEncoder.requestRegex = re
+
+	return nil
+}
+
+// newReferenceEncoder initializes and returns a new referenceEncoder for the given dialect
+func newReferenceEncoder(dialect Dialect) (*referenceEncoder, error) {
+	referenceEncoder := &referenceEncoder{dialect: dialect}
+	if err := referenceEncoder.buildTemplates(); err != nil {
+		return nil, err
+	}
+	if err := referenceEncoder.buildRegex(); err != nil {
+		return nil, err
+	}
+	return referenceEncoder, nil
+}
+
+// Encode encodes the given frame into its string representation
+func (referenceEncoder *referenceEncoder) Encode(frame Frame) (string, error) {
+	var buf bytes.Buffer
+
+	var tmpl *template.Template
+	switch frame.FrameType() {
+	case ReadRequest:
+		tmpl = referenceEncoder.readTemplate
+	case WriteRequest:
+		tmpl = referenceEncoder.writeTemplate
+	case ReadResponse:
+		tmpl = referenceEncoder.readResponseTemplate
+	case WriteResponse:
+		tmpl = referenceEncoder.writeResponseTemplate
+	default:
+		return "", merry.Errorf("Can't encode a frame of type %s", frame.FrameType())
+	}
+
+	if err := tmpl.Execute(&buf, frame); err != nil {
+		return "", merry.Prependf(err, "Failed executing %s template", tmpl.Name())
+	}
+
+	data := buf.String()
+
+	return data, nil
+}
+
+// EncodeInvalidFunctionResponse encodes the response a device sends
+// if the function of the given request is invalid
+func (referenceEncoder *referenceEncoder) EncodeInvalidFunctionResponse(request Frame) (string, error) {
+	var buf bytes.Buffer
+
+	var frameTypeChar string
+	switch request.FrameType() {
+	case ReadRequest:
+		frameTypeChar = string(referenceEncoder.dialect.ReadChar)
+	case WriteRequest:
+		frameTypeChar = string(referenceEncoder.dialect.WriteChar)
+	default:
+		return "", merry.Errorf("Can't encode an invalid function response to a frame of type %s", request.FrameType())
+	}
+
+	data := struct {
+		Address int
+		Type    string
+	}{request.Address(), frameTypeChar}
+
+	if err := referenceEncoder.invalidFunctionResponseTemplate.Execute(&buf, data); err != nil {
+		return "", merry.Prepend(err, "Failed executing InvalidFunctionResponseFrame template")
+	}
+
+	return buf.String(), nil
+}
+
+func referenceParseUint16(data string) (uint16, error) {
+	value, err := strconv.ParseUint(data, 10, 16)
+	return uint16(value), merry.Prependf(err, "Failed to decode string into uint16: %s", data)
+}
+
+// Decode decodes the given frame from its string representation
+func (referenceEncoder *referenceEncoder) Decode(data string) (Frame, error) {
+	strings := referenceEncoder.responseRegex.FindStringSubmatch(data)
+	if strings == nil {
+		return nil, merry.Errorf("Unable to decode the following data: %s", data)
+	}
+
+	address, err := referenceParseUint16(strings[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if strings[3] == string(referenceEncoder.dialect.InvalidChar) {
+		rejection := &FunctionRejection{Address: int(address), Data: data}
+		if strings[2] == string(referenceEncoder.dialect.ReadChar) {
+			rejection.RequestType = ReadRequest
+		} else {
+			rejection.RequestType = WriteRequest
+		}
+		return nil, merry.Wrap(rejection)
+	}
+	function, err := referenceParseUint16(strings[4])
+	if err != nil {
+		return nil, err
+	}
+	value, err := referenceParseUint16(strings[5])
+	if err != nil {
+		return nil, err
+	}
+
+	var frameType FrameType
+	if strings[2] == string(referenceEncoder.dialect.ReadChar) {
+		frameType = ReadResponse
+	} else if strings[2] == string(referenceEncoder.dialect.WriteChar) {
+		frameType = WriteResponse
+	} else {
+		return nil, merry.Errorf("Unknown frame response type: %s", strings[2])
+	}
+
+	frame, err := newReponse(frameType, address, function, value)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// DecodeRequest decodes the given request frame from its string representation
+func (referenceEncoder *referenceEncoder) DecodeRequest(data string) (Frame, error) {
+	strings := referenceEncoder.requestRegex.FindStringSubmatch(data)
+	if strings == nil {
+		return nil, merry.Errorf("Unable to decode the following data as request: %s", data)
+	}
+
+	address, err := referenceParseUint16(strings[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if strings[2] != "" {
+		function, err := referenceParseUint16(strings[2])
+		if err != nil {
+			return nil, err
+		}
+		return NewReadRequest(int(address), int(function))
+	}
+
+	function, err := referenceParseUint16(strings[3])
+	if err != nil {
+		return nil, err
+	}
+	value, err := referenceParseUint16(strings[4])
+	if err != nil {
+		return nil, err
+	}
+	return NewWriteRequest(int(address), int(function), int(value))
+}
+
+// differentialFrames returns frames of all types with values at and around the limits of their fields
+func differentialFrames(t *testing.T) []Frame {
+	addresses := []int{MINIMUM_ADDRESS, 9, 10, 99, 100, MAXIMUM_ADDRESS}
+	functions := []int{MINIMUM_FUNCTION, 1, 42, 99, 100, MAXIMUM_FUNCTION}
+	values := []int{MINIMUM_VALUE, 1, 7, 99, 100, 500, MAXIMUM_VALUE}
+
+	frames := []Frame{}
+	for _, address := range addresses {
+		for _, function := range functions {
+			frame, err := NewReadRequest(address, function)
+			must.NoError(t, err)
+			frames = append(frames, frame)
+			for _, value := range values {
+				frame, err = NewWriteRequest(address, function, value)
+				must.NoError(t, err)
+				frames = append(frames, frame)
+				frame, err = NewReadResponse(address, function, value)
+				must.NoError(t, err)
+				frames = append(frames, frame)
+				frame, err = NewWriteResponse(address, function, value)
+				must.NoError(t, err)
+				frames = append(frames, frame)
+			}
+		}
+	}
+	return frames
+}
+
+// mutations returns variations of the given data that may or may not still contain a valid frame
+func mutations(dialect Dialect, data string, random *rand.Rand) []string {
+	result := []string{
+		data,
+		"noise" + data,
+		data + "noise",
+		string(dialect.StartChar) + data,
+		data[:len(data)-1] + string(dialect.StartChar) + data,
+		string(dialect.EndChar) + data,
+		data[1:],
+	}
+	for i := 1; i < len(data); i++ {
+		result = append(result, data[:i])
+	}
+
+	replacements := []byte{'0', '9', 'x', dialect.StartChar, dialect.EndChar, dialect.MarkerChar, dialect.ReadChar,
+		dialect.WriteChar, dialect.ResponseChar, dialect.InvalidChar, 0x00, 0xff}
+	for i := range data {
+		mutated := []byte(data)
+		mutated[i] = replacements[random.Intn(len(replacements))]
+		result = append(result, string(mutated))
+	}
+	return result
+}
+
+func TestSerialEncoderMatchesReferenceEncoder(t *testing.T) {
+	for _, dialect := range []Dialect{DefaultDialect, testDialect} {
+		t.Run(dialect.Name, func(t *testing.T) {
+			encoder, err := NewSerialEncoderForDialect(dialect)
+			must.NoError(t, err)
+			reference, err := newReferenceEncoder(dialect)
+			must.NoError(t, err)
+			random := rand.New(rand.NewSource(1))
+
+			for _, frame := range differentialFrames(t) {
+				expected, err := reference.Encode(frame)
+				must.NoError(t, err)
+				encoded, err := encoder.Encode(frame)
+				must.NoError(t, err)
+				must.EqOp(t, expected, encoded)
+
+				appended, err := encoder.AppendEncode([]byte("prefix"), frame)
+				must.NoError(t, err)
+				must.EqOp(t, "prefix"+expected, string(appended))
+
+				if frame.FrameType() == ReadRequest || frame.FrameType() == WriteRequest {
+					expected, err = reference.EncodeInvalidFunctionResponse(frame)
+					must.NoError(t, err)
+					encoded, err = encoder.EncodeInvalidFunctionResponse(frame)
+					must.NoError(t, err)
+					must.EqOp(t, expected, encoded)
+					testDecodersMatch(t, encoder, reference, mutations(dialect, encoded, random))
+				}
+				testDecodersMatch(t, encoder, reference, mutations(dialect, expected, random))
+			}
+		})
+	}
+}
+
+// testDecodersMatch decodes each data as request and as response and compares the results of both encoders
+func testDecodersMatch(t *testing.T, encoder SerialEncoder, reference *referenceEncoder, data []string) {
+	t.Helper()
+	for _, data := range data {
+		expected, expectedErr := reference.Decode(data)
+		decodeInto := func(data string) (Frame, error) {
+			var decoded DecodedFrame
+			if err := encoder.DecodeBytesInto([]byte(data), &decoded); err != nil {
+				return nil, err
+			}
+			return &decoded.frame, nil
+		}
+		for _, decode := range []func(string) (Frame, error){encoder.Decode, func(data string) (Frame, error) { return encoder.DecodeBytes([]byte(data)) }, decodeInto} {
+			decoded, err := decode(data)
+			must.EqOp(t, expectedErr == nil, err == nil, must.Sprintf("Decode %q: %v <-> %v", data, expectedErr, err))
+			must.EqOp(t, errors.Is(expectedErr, FunctionRejectedError), errors.Is(err, FunctionRejectedError), must.Sprintf("Decode %q", data))
+			if expectedErr == nil {
+				must.Eq(t, expected, decoded, must.Sprintf("Decode %q", data))
+			}
+			var expectedRejection, rejection *FunctionRejection
+			if errors.As(expectedErr, &expectedRejection) {
+				must.True(t, errors.As(err, &rejection))
+				must.Eq(t, expectedRejection, rejection, must.Sprintf("Decode %q", data))
+			}
+		}
+
+		expected, expectedErr = reference.DecodeRequest(data)
+		for _, decode := range []func(string) (Frame, error){encoder.DecodeRequest, func(data string) (Frame, error) { return encoder.DecodeRequestBytes([]byte(data)) }} {
+			decoded, err := decode(data)
+			must.EqOp(t, expectedErr == nil, err == nil, must.Sprintf("DecodeRequest %q: %v <-> %v", data, expectedErr, err))
+			if expectedErr == nil {
+				must.Eq(t, expected, decoded, must.Sprintf("DecodeRequest %q", data))
+			}
+		}
+	}
+}
@@ -3,11 +3,8 @@
 package encoding
 
 import (
-	"bytes"
-	"regexp"
-	"strconv"
+	"fmt"
 	"strings"
-	"text/template"
 
 	"github.com/ansel1/merry/v2"
 	log "github.com/sirupsen/logrus"
@@ -20,6 +17,7 @@ const (
 	CHAR_WRITE    = 's'
 	CHAR_READ     = 'l'
 	CHAR_RESPONSE = '#'
+	CHAR_INVALID  = '?'
 )
 
 const (
@@ -29,6 +27,7 @@ const (
 	CHAR_S_WRITE    = string(CHAR_WRITE)
 	CHAR_S_READ     = string(CHAR_READ)
 	CHAR_S_RESPONSE = string(CHAR_RESPONSE)
+	CHAR_S_INVALID  = string(CHAR_INVALID)
 )
 
 const (
@@ -36,147 +35,433 @@ const (
 	TEMPLATE_WRITE = CHAR_S_LF + "{{printf \"%03d\" .Address}}" + CHAR_S_WRITE + CHAR_S_WRG + "{{printf \"%03d\" .Function}}{{printf \"%03d\" .Value}}" + CHAR_S_CR
 	// TEMPLATE_READ is the template for creating a read frame
 	TEMPLATE_READ = CHAR_S_LF + "{{printf \"%03d\" .Address}}" + CHAR_S_READ + CHAR_S_WRG + "{{printf \"%03d\" .Function}}" + CHAR_S_CR
+	// TEMPLATE_WRITE_RESPONSE is the template for creating a response to a write frame
+	TEMPLATE_WRITE_RESPONSE = CHAR_S_LF + "{{printf \"%03d\" .Address}}" + CHAR_S_WRITE + CHAR_S_WRG + CHAR_S_RESPONSE + "{{printf \"%03d\" .Function}}{{printf \"%03d\" .Value}}" + CHAR_S_CR
+	// TEMPLATE_READ_RESPONSE is the template for creating a response to a read frame
+	TEMPLATE_READ_RESPONSE = CHAR_S_LF + "{{printf \"%03d\" .Address}}" + CHAR_S_READ + CHAR_S_WRG + CHAR_S_RESPONSE + "{{printf \"%03d\" .Function}}{{printf \"%03d\" .Value}}" + CHAR_S_CR
+	// TEMPLATE_INVALID_FUNCTION_RESPONSE is the template for creating the response to a frame with an invalid function
+	TEMPLATE_INVALID_FUNCTION_RESPONSE = CHAR_S_LF + "{{printf \"%03d\" .Address}}{{.Type}}" + CHAR_S_WRG + CHAR_S_RESPONSE + CHAR_S_INVALID + CHAR_S_CR
 )
 
 const (
 	REGEX_3DIGIT_NUM = "([0-9]{3})"
 	REGEX_READ_WRITE = "(" + CHAR_S_READ + "|" + CHAR_S_WRITE + ")"
 	// REGEX_RESPONSE is the regex that machtes a response frame
-	REGEX_RESPONSE = CHAR_S_LF + REGEX_3DIGIT_NUM + REGEX_READ_WRITE + CHAR_S_WRG + CHAR_S_RESPONSE + "(" + REGEX_3DIGIT_NUM + REGEX_3DIGIT_NUM + "|\\?)" + CHAR_S_CR
+	REGEX_RESPONSE = CHAR_S_LF + REGEX_3DIGIT_NUM + REGEX_READ_WRITE + CHAR_S_WRG + CHAR_S_RESPONSE + "(" + REGEX_3DIGIT_NUM + REGEX_3DIGIT_NUM + "|\\" + CHAR_S_INVALID + ")" + CHAR_S_CR
+	// REGEX_REQUEST is the regex that matches a read or write request frame
+	REGEX_REQUEST = CHAR_S_LF + REGEX_3DIGIT_NUM + "(?:" + CHAR_S_READ + CHAR_S_WRG + REGEX_3DIGIT_NUM + "|" + CHAR_S_WRITE + CHAR_S_WRG + REGEX_3DIGIT_NUM + REGEX_3DIGIT_NUM + ")" + CHAR_S_CR
 )
 
+// FunctionRejectedError is the error returned when a device responded with CHAR_INVALID instead of data.
+// Use errors.As with a *FunctionRejection to get the details.
+var FunctionRejectedError = merry.Sentinel("Device rejected the function")
+
+// FunctionRejection describes a response in which a device rejected the function of a request.
+// It matches FunctionRejectedError when used with errors.Is.
+type FunctionRejection struct {
+	// Address is the address of the device that rejected the function
+	Address int
+	// RequestType is the type of the rejected request (ReadRequest or WriteRequest)
+	RequestType FrameType
+	// Data is the raw frame received from the device
+	Data string
+}
+
+// Error returns the description of the rejection
+func (rejection *FunctionRejection) Error() string {
+	return fmt.Sprintf("Device %d returned frame with questionmark instead of data for a %s. Was the function valid? (Data='%s')",
+		rejection.Address, rejection.RequestType, DataWithEscapeChars(rejection.Data))
+}
+
+// Is makes the FunctionRejection match FunctionRejectedError
+func (rejection *FunctionRejection) Is(target error) bool {
+	return target == FunctionRejectedError
+}
+
 // A SerialEncoder can be used to encode and decode frames to and from their string representation
 type SerialEncoder interface {
 	// Encode encodes the given frame into its string representation
 	Encode(frame Frame) (string, error)
+	// AppendEncode appends the string representation of the given frame to dst and returns the extended buffer.
+	// It does not allocate if dst has enough capacity (MAXIMUM_FRAME_LENGTH is always enough).
+	AppendEncode(dst []byte, frame Frame) ([]byte, error)
+	// EncodeInvalidFunctionResponse encodes the response a device sends
+	// if the function of the given request is invalid
+	EncodeInvalidFunctionResponse(request Frame) (string, error)
 	// Decode decodes the given frame from its string representation
 	Decode(data string) (Frame, error)
+	// DecodeBytes decodes the given frame from its string representation given as bytes.
+	// It does not retain data.
+	DecodeBytes(data []byte) (Frame, error)
+	// DecodeBytesInto decodes the given frame like DecodeBytes but stores it in dst instead of returning a new frame.
+	// It does not retain data and does not allocate unless decoding fails.
+	DecodeBytesInto(data []byte, dst *DecodedFrame) error
+	// DecodeRequest decodes the given request frame from its string representation
+	DecodeRequest(data string) (Frame, error)
+	// DecodeRequestBytes decodes the given request frame from its string representation given as bytes.
+	// It does not retain data.
+	DecodeRequestBytes(data []byte) (Frame, error)
+	// Dialect returns the dialect the encoder encodes and decodes
+	Dialect() Dialect
 }
 
-// A SerialEncoder can be used to encode and decode frames to and from their string representation
+// A SerialEncoder can be used to encode and decode frames to and from their string representation.
+// It does not allocate while encoding or while decoding into a DecodedFrame. Otherwise, decoding only allocates
+// if the decoded frame is not in its frameCache (e.g. because its value changed since it was decoded last).
 type serialEncoder struct {
-	writeTemplate *template.Template
-	readTemplate  *template.Template
-	responseRegex *regexp.Regexp
+	dialect Dialect
+	cache   frameCache
 }
 
-func (serialEncoder *serialEncoder) buildTemplates() error {
-	tmpl, err := template.New("WriteFrame").Parse(TEMPLATE_WRITE)
-	if err != nil {
-		return merry.Prepend(err, "Failed building WriteFrame template")
+// NewSerialEncoder initializes and returns a new SerialEncoder for the DefaultDialect
+func NewSerialEncoder() (SerialEncoder, error) {
+	return NewSerialEncoderForDialect(DefaultDialect)
+}
+
+// NewSerialEncoderForDialect initializes and returns a new SerialEncoder for the given dialect
+func NewSerialEncoderForDialect(dialect Dialect) (SerialEncoder, error) {
+	if err := dialect.Validate(); err != nil {
+		return nil, err
 	}
-	serialEncoder.writeTemplate = tmpl
+	return &serialEncoder{dialect: dialect}, nil
+}
 
-	tmpl, err = template.New("ReadFrame").Parse(TEMPLATE_READ)
+// Dialect returns the dialect the encoder encodes and decodes
+func (serialEncoder *serialEncoder) Dialect() Dialect {
+	return serialEncoder.dialect
+}
+
+// powersOfTen contains the powers of ten up to 10^MAXIMUM_DIGITS
+var powersOfTen = [MAXIMUM_DIGITS + 1]int{1, 10, 100, 1000, 10000, 100000}
+
+// appendDigits appends the given number with exactly the given number of digits (padded with zeros)
+func appendDigits(dst []byte, number int, digits int) ([]byte, error) {
+	if number < 0 || number >= powersOfTen[digits] {
+		return dst, merry.Errorf("Can't encode %d with %d digits", number, digits)
+	}
+	for power := powersOfTen[digits-1]; power > 0; power /= 10 {
+		dst = append(dst, byte('0'+number/power%10))
+	}
+	return dst, nil
+}
+
+// Encode encodes the given frame into its string representation
+func (serialEncoder *serialEncoder) Encode(frame Frame) (string, error) {
+	var buf [MAXIMUM_FRAME_LENGTH]byte
+	data, err := serialEncoder.AppendEncode(buf[:0], frame)
 	if err != nil {
-		return merry.Prepend(err, "Failed building ReadFrame template")
+		return "", err
 	}
-	serialEncoder.readTemplate = tmpl
+	return string(data), nil
+}
 
-	return nil
+// AppendEncode appends the string representation of the given frame to dst and returns the extended buffer.
+func (serialEncoder *serialEncoder) AppendEncode(dst []byte, frame Frame) ([]byte, error) {
+	dialect := &serialEncoder.dialect
+
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("frame", frame).Trace("Encoding frame")
+	}
+
+	var typeChar byte
+	isResponse := false
+	hasValue := true
+	switch frame.FrameType() {
+	case ReadRequest:
+		typeChar = dialect.ReadChar
+		hasValue = false
+	case WriteRequest:
+		typeChar = dialect.WriteChar
+	case ReadResponse:
+		typeChar = dialect.ReadChar
+		isResponse = true
+	case WriteResponse:
+		typeChar = dialect.WriteChar
+		isResponse = true
+	default:
+		return dst, merry.Errorf("Can't encode a frame of type %s", frame.FrameType())
+	}
+
+	start := len(dst)
+	var err error
+
+	dst = append(dst, dialect.StartChar)
+	if dst, err = appendDigits(dst, frame.Address(), dialect.AddressDigits); err != nil {
+		return dst[:start], merry.Prepend(err, "Failed to encode address")
+	}
+	dst = append(dst, typeChar, dialect.MarkerChar)
+	if isResponse {
+		dst = append(dst, dialect.ResponseChar)
+	}
+	if dst, err = appendDigits(dst, frame.Function(), dialect.FunctionDigits); err != nil {
+		return dst[:start], merry.Prepend(err, "Failed to encode function")
+	}
+	if hasValue {
+		if dst, err = appendDigits(dst, frame.Value(), dialect.ValueDigits); err != nil {
+			return dst[:start], merry.Prepend(err, "Failed to encode value")
+		}
+	}
+	dst = append(dst, dialect.EndChar)
+
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("data", DataWithEscapeChars(string(dst[start:]))).Trace("Encoded frame")
+	}
+
+	return dst, nil
 }
 
-func (serialEncoder *serialEncoder) buildRegex() error {
-	re, err := regexp.Compile(REGEX_RESPONSE)
+// EncodeInvalidFunctionResponse encodes the response a device sends
+// if the function of the given request is invalid
+func (serialEncoder *serialEncoder) EncodeInvalidFunctionResponse(request Frame) (string, error) {
+	dialect := &serialEncoder.dialect
+
+	var typeChar byte
+	switch request.FrameType() {
+	case ReadRequest:
+		typeChar = dialect.ReadChar
+	case WriteRequest:
+		typeChar = dialect.WriteChar
+	default:
+		return "", merry.Errorf("Can't encode an invalid function response to a frame of type %s", request.FrameType())
+	}
+
+	var buf [MAXIMUM_FRAME_LENGTH]byte
+	data := append(buf[:0], dialect.StartChar)
+	data, err := appendDigits(data, request.Address(), dialect.AddressDigits)
 	if err != nil {
-		return merry.Prepend(err, "Failed building Response regex")
+		return "", merry.Prepend(err, "Failed to encode address")
 	}
-	serialEncoder.responseRegex = re
+	data = append(data, typeChar, dialect.MarkerChar, dialect.ResponseChar, dialect.InvalidChar, dialect.EndChar)
 
-	return nil
+	return string(data), nil
 }
 
-// NewSerialEncoder initializes and returns a new SerialEncoder
-func NewSerialEncoder() (SerialEncoder, error) {
-	serialEncoder := &serialEncoder{}
-	if err := serialEncoder.buildTemplates(); err != nil {
-		return nil, err
+// rawFrame is the data on the wire every frame consists of
+type rawFrame interface {
+	~string | ~[]byte
+}
+
+// parseDigits parses the number with the given number of digits starting at the given offset of data
+func parseDigits[T rawFrame](data T, offset int, digits int) (int, bool) {
+	if offset+digits > len(data) {
+		return 0, false
 	}
-	if err := serialEncoder.buildRegex(); err != nil {
-		return nil, err
+	number := 0
+	for i := offset; i < offset+digits; i++ {
+		char := data[i]
+		if char < '0' || char > '9' {
+			return 0, false
+		}
+		number = number*10 + int(char-'0')
 	}
-	return serialEncoder, nil
+	return number, true
 }
 
-// Encode encodes the given frame into its string representation
-func (serialEncoder *serialEncoder) Encode(frame Frame) (string, error) {
-	var buf bytes.Buffer
+// parsedFrame contains the fields of a frame found by parseResponse or parseRequest
+type parsedFrame struct {
+	typeChar byte
+	invalid  bool
+	address  int
+	function int
+	value    int
+}
 
-	log.WithField("frame", frame).Trace("Encoding frame")
+// parseResponse parses the response frame starting at the beginning of data
+func parseResponse[T rawFrame](dialect *Dialect, data T) (parsedFrame, bool) {
+	var parsed parsedFrame
+	var ok bool
 
-	if frame.FrameType() == ReadRequest {
-		if err := serialEncoder.readTemplate.Execute(&buf, frame); err != nil {
-			return "", merry.Prepend(err, "Failed executing ReadFrame template")
-		}
-	} else if frame.FrameType() == WriteRequest {
-		if err := serialEncoder.writeTemplate.Execute(&buf, frame); err != nil {
-			return "", merry.Prepend(err, "Failed executing WriteFrame template")
-		}
-	} else {
-		return "", merry.Errorf("Can't encode a frame of type %d", frame.FrameType())
+	offset := 1 // StartChar
+	if parsed.address, ok = parseDigits(data, offset, dialect.AddressDigits); !ok {
+		return parsed, false
+	}
+	offset += dialect.AddressDigits
+
+	if offset+3 > len(data) {
+		return parsed, false
+	}
+	parsed.typeChar = data[offset]
+	if (parsed.typeChar != dialect.ReadChar && parsed.typeChar != dialect.WriteChar) ||
+		data[offset+1] != dialect.MarkerChar || data[offset+2] != dialect.ResponseChar {
+		return parsed, false
 	}
+	offset += 3
 
-	data := buf.String()
+	if offset+2 <= len(data) && data[offset] == dialect.InvalidChar && data[offset+1] == dialect.EndChar {
+		parsed.invalid = true
+		return parsed, true
+	}
 
-	log.WithField("data", DataWithEscapeChars(data)).Trace("Encoded frame")
+	if parsed.function, ok = parseDigits(data, offset, dialect.FunctionDigits); !ok {
+		return parsed, false
+	}
+	offset += dialect.FunctionDigits
+	if parsed.value, ok = parseDigits(data, offset, dialect.ValueDigits); !ok {
+		return parsed, false
+	}
+	offset += dialect.ValueDigits
 
-	return data, nil
+	return parsed, offset < len(data) && data[offset] == dialect.EndChar
 }
 
-func parseUint16(data string) (uint16, error) {
-	value, err := strconv.ParseUint(data, 10, 16)
-	return uint16(value), merry.Prependf(err, "Failed to decode string into uint16: %s", data)
-}
+// parseRequest parses the request frame starting at the beginning of data
+func parseRequest[T rawFrame](dialect *Dialect, data T) (parsedFrame, bool) {
+	var parsed parsedFrame
+	var ok bool
 
-// Decode decodes the given frame from its string representation
-func (serialEncoder *serialEncoder) Decode(data string) (Frame, error) {
-	log.WithField("data", DataWithEscapeChars(data)).Trace("Decoding frame")
+	offset := 1 // StartChar
+	if parsed.address, ok = parseDigits(data, offset, dialect.AddressDigits); !ok {
+		return parsed, false
+	}
+	offset += dialect.AddressDigits
 
-	strings := serialEncoder.responseRegex.FindStringSubmatch(data)
-	if strings == nil {
-		return nil, merry.Errorf("Unable to decode the following data: %s", data)
+	if offset+2 > len(data) {
+		return parsed, false
+	}
+	parsed.typeChar = data[offset]
+	if (parsed.typeChar != dialect.ReadChar && parsed.typeChar != dialect.WriteChar) || data[offset+1] != dialect.MarkerChar {
+		return parsed, false
 	}
+	offset += 2
 
-	stringsToPrint := make([]string, len(strings))
-	for i, s := range strings {
-		stringsToPrint[i] = DataWithEscapeChars(s)
+	if parsed.function, ok = parseDigits(data, offset, dialect.FunctionDigits); !ok {
+		return parsed, false
 	}
+	offset += dialect.FunctionDigits
+	if parsed.typeChar == dialect.WriteChar {
+		if parsed.value, ok = parseDigits(data, offset, dialect.ValueDigits); !ok {
+			return parsed, false
+		}
+		offset += dialect.ValueDigits
+	}
+
+	return parsed, offset < len(data) && data[offset] == dialect.EndChar
+}
 
-	if strings[3] == "?" {
-		return nil, merry.New("Device returned frame with questionmark instead of data. Was the function valid?")
+// find finds the first frame in data using the given parse function
+func find[T rawFrame](dialect *Dialect, data T, parse func(*Dialect, T) (parsedFrame, bool)) (parsedFrame, bool) {
+	for start := 0; start < len(data); start++ {
+		if data[start] != dialect.StartChar {
+			continue
+		}
+		if parsed, ok := parse(dialect, data[start:]); ok {
+			return parsed, true
+		}
 	}
+	return parsedFrame{}, false
+}
 
-	address, err := parseUint16(strings[1])
-	if err != nil {
-		return nil, err
+// checkFrameFields checks whether the parsed fields are in the valid range of a frame
+func checkFrameFields(parsed parsedFrame) error {
+	if err := checkAddress(parsed.address); err != nil {
+		return err
 	}
-	function, err := parseUint16(strings[4])
+	if err := checkFunction(parsed.function); err != nil {
+		return err
+	}
+	return checkValue(parsed.value)
+}
+
+// Decode decodes the given frame from its string representation
+func (serialEncoder *serialEncoder) Decode(data string) (Frame, error) {
+	return decodeResponse(serialEncoder, data)
+}
+
+// DecodeBytes decodes the given frame from its string representation given as bytes.
+func (serialEncoder *serialEncoder) DecodeBytes(data []byte) (Frame, error) {
+	return decodeResponse(serialEncoder, data)
+}
+
+// DecodeBytesInto decodes the given frame from its string representation given as bytes and stores it in dst
+func (serialEncoder *serialEncoder) DecodeBytesInto(data []byte, dst *DecodedFrame) error {
+	decoded, err := parseResponseFrame(serialEncoder, data)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	value, err := parseUint16(strings[5])
+	dst.frame = decoded
+	return nil
+}
+
+func decodeResponse[T rawFrame](serialEncoder *serialEncoder, data T) (Frame, error) {
+	decoded, err := parseResponseFrame(serialEncoder, data)
 	if err != nil {
 		return nil, err
 	}
+	return serialEncoder.cache.get(decoded.FrameType_, decoded.Address_, decoded.Function_, decoded.Value_), nil
+}
+
+// parseResponseFrame finds the response frame in data and checks its fields
+func parseResponseFrame[T rawFrame](serialEncoder *serialEncoder, data T) (frame, error) {
+	dialect := &serialEncoder.dialect
+
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("data", DataWithEscapeChars(string(data))).Trace("Decoding frame")
+	}
+
+	parsed, ok := find(dialect, data, parseResponse[T])
+	if !ok {
+		return frame{}, merry.Errorf("Unable to decode the following data: %s", data)
+	}
 
-	var frameType FrameType
-	if strings[2] == CHAR_S_READ {
+	if parsed.invalid {
+		rejection := &FunctionRejection{Address: parsed.address, Data: string(data), RequestType: WriteRequest}
+		if parsed.typeChar == dialect.ReadChar {
+			rejection.RequestType = ReadRequest
+		}
+		return frame{}, merry.Wrap(rejection)
+	}
+
+	frameType := WriteResponse
+	if parsed.typeChar == dialect.ReadChar {
 		frameType = ReadResponse
-	} else if strings[2] == CHAR_S_WRITE {
-		frameType = WriteResponse
-	} else {
-		return nil, merry.Errorf("Unknown frame response type: %s", strings[2])
 	}
 
-	frame, err := newReponse(frameType, address, function, value)
+	if err := checkFrameFields(parsed); err != nil {
+		log.WithField("data", DataWithEscapeChars(string(data))).WithError(err).Debug("Error decoding frame")
+		return frame{}, err
+	}
+
+	decoded := frame{FrameType_: frameType, Address_: uint16(parsed.address), Function_: uint16(parsed.function), Value_: uint16(parsed.value)}
+
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("frame", decoded).Trace("Succsesfully decoded frame")
+	}
+
+	return decoded, nil
+}
+
+// DecodeRequest decodes the given request frame from its string representation
+func (serialEncoder *serialEncoder) DecodeRequest(data string) (Frame, error) {
+	return decodeRequest(serialEncoder, data)
+}
+
+// DecodeRequestBytes decodes the given request frame from its string representation given as bytes.
+func (serialEncoder *serialEncoder) DecodeRequestBytes(data []byte) (Frame, error) {
+	return decodeRequest(serialEncoder, data)
+}
+
+func decodeRequest[T rawFrame](serialEncoder *serialEncoder, data T) (Frame, error) {
+	dialect := &serialEncoder.dialect
+
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("data", DataWithEscapeChars(string(data))).Trace("Decoding request frame")
+	}
+
+	parsed, ok := find(dialect, data, parseRequest[T])
+	if !ok {
+		return nil, merry.Errorf("Unable to decode the following data as request: %s", data)
+	}
 
-	if err == nil {
-		log.WithField("frame", frame).Trace("Succsesfully decoded frame")
-	} else {
-		log.WithField("frame", frame).WithError(err).Debug("Error decoding frame")
+	frameType := WriteRequest
+	if parsed.typeChar == dialect.ReadChar {
+		frameType = ReadRequest
+	}
+
+	if err := checkFrameFields(parsed); err != nil {
+		return nil, err
 	}
 
-	return frame, err
+	return serialEncoder.cache.get(frameType, uint16(parsed.address), uint16(parsed.function), uint16(parsed.value)), nil
 }
 
 func DataWithEscapeChars(data string) string {
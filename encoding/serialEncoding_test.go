@@ -1,9 +1,11 @@
 package encoding
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/ansel1/merry/v2"
 	"github.com/shoenig/test"
 	"github.com/shoenig/test/must"
 )
@@ -14,9 +16,7 @@ func TestNewSerialEncoder(t *testing.T) {
 	inner_encoder, ok := encoder.(*serialEncoder)
 	must.True(t, ok)
 
-	test.NotNil(t, inner_encoder.readTemplate)
-	test.NotNil(t, inner_encoder.writeTemplate)
-	test.NotNil(t, inner_encoder.responseRegex)
+	test.Eq(t, DefaultDialect, inner_encoder.dialect)
 }
 
 func TestEncodeReadRequest(t *testing.T) {
@@ -70,6 +70,145 @@ func TestEncodeWriteRequest(t *testing.T) {
 	}
 }
 
+func TestEncodeResponse(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+
+	testCases := []struct {
+		frameType FrameType
+		address   int
+		function  int
+		value     int
+		result    string
+	}{
+		{ReadResponse, 10, 20, 30, "\n010lW#020030\r"},
+		{ReadResponse, 1, 0, 0, "\n001lW#000000\r"},
+		{ReadResponse, 250, 999, 999, "\n250lW#999999\r"},
+		{WriteResponse, 10, 20, 30, "\n010sW#020030\r"},
+		{WriteResponse, 1, 0, 0, "\n001sW#000000\r"},
+		{WriteResponse, 250, 999, 999, "\n250sW#999999\r"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf(`Encode(%s(%d, %d, %d)) == %s`, tc.frameType, tc.address, tc.function, tc.value, tc.result), func(t *testing.T) {
+			var frame Frame
+			if tc.frameType == ReadResponse {
+				frame, err = NewReadResponse(tc.address, tc.function, tc.value)
+			} else {
+				frame, err = NewWriteResponse(tc.address, tc.function, tc.value)
+			}
+			must.NoError(t, err)
+			result, err := encoder.Encode(frame)
+			must.NoError(t, err)
+			test.EqOp(t, tc.result, result)
+
+			decoded, err := encoder.Decode(result)
+			must.NoError(t, err)
+			testFrameValues(t, decoded, tc.address, tc.frameType, tc.function, tc.value)
+		})
+	}
+}
+
+func TestEncodeInvalidFunctionResponse(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+
+	readRequest, err := NewReadRequest(10, 20)
+	must.NoError(t, err)
+	writeRequest, err := NewWriteRequest(250, 20, 30)
+	must.NoError(t, err)
+	readResponse, err := NewReadResponse(10, 20, 30)
+	must.NoError(t, err)
+
+	testCases := []struct {
+		request Frame
+		result  string
+	}{
+		{readRequest, "\n010lW#?\r"},
+		{writeRequest, "\n250sW#?\r"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf(`EncodeInvalidFunctionResponse(%s) == %s`, tc.request.FrameType(), tc.result), func(t *testing.T) {
+			result, err := encoder.EncodeInvalidFunctionResponse(tc.request)
+			must.NoError(t, err)
+			test.EqOp(t, tc.result, result)
+
+			_, err = encoder.Decode(result)
+			test.ErrorContains(t, err, "questionmark")
+		})
+	}
+
+	_, err = encoder.EncodeInvalidFunctionResponse(readResponse)
+	test.Error(t, err)
+}
+
+func TestDecodeRequestGood(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+
+	testCases := []struct {
+		address   int
+		frameType FrameType
+		function  int
+		value     int
+		input     string
+	}{
+		{10, ReadRequest, 20, 0, "\n010lW020\r"},
+		{1, ReadRequest, 0, 0, "\n001lW000\r"},
+		{250, ReadRequest, 999, 0, "\n250lW999\r"},
+		{10, WriteRequest, 20, 30, "\n010sW020030\r"},
+		{1, WriteRequest, 0, 0, "\n001sW000000\r"},
+		{250, WriteRequest, 999, 999, "\n250sW999999\r"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf(`DecodeRequest(%s) == Request(%d, %s, %d, %d)`, tc.input, tc.address, tc.frameType, tc.function, tc.value), func(t *testing.T) {
+			frame, err := encoder.DecodeRequest(tc.input)
+			must.NoError(t, err)
+			testFrameValues(t, frame, tc.address, tc.frameType, tc.function, tc.value)
+
+			encoded, err := encoder.Encode(frame)
+			must.NoError(t, err)
+			test.EqOp(t, tc.input, encoded)
+		})
+	}
+}
+
+func TestDecodeRequestBad(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+
+	testCases := []struct {
+		input string
+	}{
+		{"\n01lW020\r"},      //Address only 2 chars
+		{"\n010lW02\r"},      //Function only 2 chars
+		{"\n010lW020030\r"},  //Read with value
+		{"\n010sW020\r"},     //Write without value
+		{"\n010sW02003\r"},   //Value only 2 chars
+		{"\n010lW020"},       //Missing CR
+		{"010lW020\r"},       //Missing NL
+		{"\n010l020\r"},      //Missing W
+		{"\n010W020\r"},      //Missing request type
+		{"\n010lW#020030\r"}, //Response
+		{"\n010lW#?\r"},      //Invalid function response
+		{"\n000lW020\r"},     //Address too small
+		{"\n251sW020030\r"},  //Address too big
+		{"\n01alW020\r"},     //Not an int
+		{"\n010xW020\r"},     //Wrong request type
+		{"\n\r"},             //Missing all but CR NL
+		{""},                 //Empty String
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf(`DecodeRequest(%s)`, tc.input), func(t *testing.T) {
+			_, err := encoder.DecodeRequest(tc.input)
+			test.Error(t, err)
+		})
+	}
+}
+
 func TestDecodeResponseGood(t *testing.T) {
 	encoder, err := NewSerialEncoder()
 	must.NoError(t, err)
@@ -151,20 +290,194 @@ func TestDecodeResponseNoData(t *testing.T) {
 	must.NoError(t, err)
 
 	testCases := []struct {
-		input string
+		input       string
+		address     int
+		requestType FrameType
 	}{
-		{"\n010lW#?\r"},
-		{"\n001lW#?\r"},
-		{"\n250lW#?\r"},
-		{"\n010sW#?\r"},
-		{"\n001sW#?\r"},
-		{"\n250sW#?\r"},
+		{"\n010lW#?\r", 10, ReadRequest},
+		{"\n001lW#?\r", 1, ReadRequest},
+		{"\n250lW#?\r", 250, ReadRequest},
+		{"\n010sW#?\r", 10, WriteRequest},
+		{"\n001sW#?\r", 1, WriteRequest},
+		{"\n250sW#?\r", 250, WriteRequest},
 	}
 
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf(`Decode(%s)`, tc.input), func(t *testing.T) {
 			_, err := encoder.Decode(tc.input)
 			test.ErrorContains(t, err, "questionmark")
+			test.ErrorIs(t, err, FunctionRejectedError)
+
+			var rejection *FunctionRejection
+			must.True(t, errors.As(merry.Prepend(err, "Some context"), &rejection))
+			test.EqOp(t, tc.address, rejection.Address)
+			test.EqOp(t, tc.requestType, rejection.RequestType)
+			test.EqOp(t, tc.input, rejection.Data)
 		})
 	}
 }
+
+// TestSerialEncoderDoesNotAllocateForRepeatedFrames checks the allocation-free path.
+// Decoding only avoids allocations for frames that hit the frameCache.
+func TestSerialEncoderDoesNotAllocateForRepeatedFrames(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+	frame, err := NewWriteRequest(10, 20, 30)
+	must.NoError(t, err)
+	buf := make([]byte, 0, MAXIMUM_FRAME_LENGTH)
+	data := []byte("\n010lW#020030\r")
+	request := []byte("\n010sW020030\r")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = encoder.AppendEncode(buf[:0], frame)
+	})
+	test.EqOp(t, 0.0, allocs, test.Sprint("AppendEncode"))
+
+	allocs = testing.AllocsPerRun(100, func() {
+		_, _ = encoder.DecodeBytes(data)
+	})
+	test.EqOp(t, 0.0, allocs, test.Sprint("DecodeBytes"))
+
+	allocs = testing.AllocsPerRun(100, func() {
+		_, _ = encoder.DecodeRequestBytes(request)
+	})
+	test.EqOp(t, 0.0, allocs, test.Sprint("DecodeRequestBytes"))
+}
+
+func TestSerialEncoderChangingValues(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+
+	// Enough different frames to evict each other from the cache
+	for round := 0; round < 2; round++ {
+		for value := 0; value < 2*(1<<frameCacheBits); value += 7 {
+			address, function := value%250+1, value%999
+			data := fmt.Sprintf("\n%03dlW#%03d%03d\r", address, function, value%1000)
+			decoded, err := encoder.DecodeBytes([]byte(data))
+			must.NoError(t, err)
+			test.Eq(t, Frame(&frame{FrameType_: ReadResponse, Address_: uint16(address), Function_: uint16(function), Value_: uint16(value % 1000)}), decoded)
+		}
+	}
+
+	// A changing value misses the cache, so each decode allocates one frame at most
+	data := []byte("\n010lW#020000\r")
+	value := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		value = (value + 1) % 1000
+		data[10], data[11], data[12] = byte('0'+value/100), byte('0'+value/10%10), byte('0'+value%10)
+		_, _ = encoder.DecodeBytes(data)
+	})
+	test.EqOp(t, 1.0, allocs)
+}
+
+func TestDecodeBytesInto(t *testing.T) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+
+	var decoded DecodedFrame
+	must.NoError(t, encoder.DecodeBytesInto([]byte("\n010lW#020030\r"), &decoded))
+	testFrameValues(t, &decoded, 10, ReadResponse, 20, 30)
+	must.NoError(t, encoder.DecodeBytesInto([]byte("xx\n011sW#021031\r"), &decoded))
+	testFrameValues(t, &decoded, 11, WriteResponse, 21, 31)
+
+	err = encoder.DecodeBytesInto([]byte("\n012lW#?\r"), &decoded)
+	test.ErrorIs(t, err, FunctionRejectedError)
+	testFrameValues(t, &decoded, 11, WriteResponse, 21, 31)
+	test.Error(t, encoder.DecodeBytesInto([]byte("\n251lW#020030\r"), &decoded))
+	test.Error(t, encoder.DecodeBytesInto([]byte("\n010lW020030\r"), &decoded))
+
+	// Unlike DecodeBytes, a changing value does not allocate
+	data := []byte("\n010lW#020000\r")
+	value := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		value = (value + 1) % 1000
+		data[10], data[11], data[12] = byte('0'+value/100), byte('0'+value/10%10), byte('0'+value%10)
+		_ = encoder.DecodeBytesInto(data, &decoded)
+	})
+	test.EqOp(t, 0.0, allocs)
+	testFrameValues(t, &decoded, 10, ReadResponse, 20, value)
+}
+
+func BenchmarkEncode(b *testing.B) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(b, err)
+	reference, err := newReferenceEncoder(DefaultDialect)
+	must.NoError(b, err)
+	frame, err := NewWriteRequest(10, 20, 30)
+	must.NoError(b, err)
+
+	b.Run("AppendEncode", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, MAXIMUM_FRAME_LENGTH)
+		for i := 0; i < b.N; i++ {
+			_, _ = encoder.AppendEncode(buf[:0], frame)
+		}
+	})
+	b.Run("Encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = encoder.Encode(frame)
+		}
+	})
+	b.Run("Reference", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = reference.Encode(frame)
+		}
+	})
+}
+
+// BenchmarkDecodeRepeatedFrame decodes the same frame repeatedly, which is served by the frameCache.
+// See BenchmarkDecodeChangingValues for frames missing it.
+func BenchmarkDecodeRepeatedFrame(b *testing.B) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(b, err)
+	reference, err := newReferenceEncoder(DefaultDialect)
+	must.NoError(b, err)
+	data := "\n010lW#020030\r"
+	dataBytes := []byte(data)
+
+	b.Run("DecodeBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = encoder.DecodeBytes(dataBytes)
+		}
+	})
+	b.Run("Decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = encoder.Decode(data)
+		}
+	})
+	b.Run("Reference", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = reference.Decode(data)
+		}
+	})
+}
+
+// BenchmarkDecodeChangingValues decodes frames whose value changes every time, so each one misses the frameCache.
+// Only DecodeBytesInto avoids allocating for them.
+func BenchmarkDecodeChangingValues(b *testing.B) {
+	encoder, err := NewSerialEncoder()
+	must.NoError(b, err)
+	frames := make([][]byte, 1000)
+	for value := range frames {
+		frames[value] = []byte(fmt.Sprintf("\n010lW#020%03d\r", value))
+	}
+
+	b.Run("DecodeBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = encoder.DecodeBytes(frames[i%len(frames)])
+		}
+	})
+	b.Run("DecodeBytesInto", func(b *testing.B) {
+		b.ReportAllocs()
+		var decoded DecodedFrame
+		for i := 0; i < b.N; i++ {
+			_ = encoder.DecodeBytesInto(frames[i%len(frames)], &decoded)
+		}
+	})
+}
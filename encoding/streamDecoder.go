@@ -0,0 +1,227 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// MAXIMUM_FRAME_LENGTH is the maximum length of a frame including the start and end characters.
+	// Data starting with a start character that does not end within this length is discarded as noise.
+	MAXIMUM_FRAME_LENGTH = 32
+	// maxConsecutiveEmptyReads is the number of reads returning neither data nor an error after which
+	// a StreamDecoder without deadline gives up with io.ErrNoProgress (The same value bufio uses).
+	maxConsecutiveEmptyReads = 100
+	// readChunkSize is the number of bytes the StreamDecoder tries to read from the reader at once
+	readChunkSize = 64
+)
+
+// A DecodeFunc decodes a single frame from its string representation given as bytes.
+// It must not retain data. SerialEncoder.DecodeBytes is a DecodeFunc.
+type DecodeFunc func(data []byte) (Frame, error)
+
+// A StreamDecoder reads frames from a stream of data.
+// It discards everything that is not part of a complete frame (e.g. noise on the line or half frames)
+// and resynchronizes on the start character of the next frame.
+type StreamDecoder interface {
+	// Next reads from the stream until the next frame has been decoded.
+	// It returns the frame and the number of bytes that have been skipped before it.
+	// If the device rejected the function of the request, the FunctionRejectedError is returned.
+	// If reading from the stream fails, the error is returned together with the number of bytes skipped so far.
+	// Data not yet consumed (including a partially read frame) is kept for the next call.
+	Next() (Frame, int, error)
+	// NextRaw reads from the stream until the next frame delimited by the start and end character has been found
+	// and returns it without decoding it (e.g. to compare it with the echo of a request).
+	// Otherwise it behaves like Next. The returned slice is only valid until the next call.
+	NextRaw() ([]byte, int, error)
+	// SetDeadline makes Next and NextRaw give up with os.ErrDeadlineExceeded once the given time has passed.
+	// Reads returning no data (e.g. because the read timeout of a serial port expired) are retried until then
+	// instead of giving up with io.ErrNoProgress after a number of them.
+	// The reader has to return by the deadline for it to be met. The zero time removes the deadline.
+	SetDeadline(deadline time.Time)
+	// Buffered returns the data read from the stream but not consumed yet
+	Buffered() []byte
+	// Discard drops the data read from the stream but not consumed yet
+	Discard()
+	markAsValidStreamDecoder()
+}
+
+type streamDecoder struct {
+	reader    io.Reader
+	startChar byte
+	endChar   byte
+	decode    DecodeFunc
+	deadline  time.Time
+	buffer    []byte
+	readChunk []byte
+	// returned is the length of the frame at the start of the buffer returned by NextRaw.
+	// It is consumed by the next call.
+	returned int
+}
+
+// NewStreamDecoder creates a new StreamDecoder that reads from the given reader
+// and uses the given function to decode the frames of the DefaultDialect found in the stream.
+func NewStreamDecoder(reader io.Reader, decode DecodeFunc) StreamDecoder {
+	return NewStreamDecoderForDialect(reader, DefaultDialect, decode)
+}
+
+// NewStreamDecoderForDialect creates a new StreamDecoder that reads from the given reader
+// and uses the given function to decode the frames of the given dialect found in the stream.
+func NewStreamDecoderForDialect(reader io.Reader, dialect Dialect, decode DecodeFunc) StreamDecoder {
+	return &streamDecoder{
+		reader:    reader,
+		startChar: dialect.StartChar,
+		endChar:   dialect.EndChar,
+		decode:    decode,
+		buffer:    make([]byte, 0, MAXIMUM_FRAME_LENGTH+readChunkSize),
+		readChunk: make([]byte, readChunkSize),
+	}
+}
+
+// Next reads from the stream until the next frame has been decoded.
+// It returns the frame and the number of bytes that have been skipped before it.
+func (decoder *streamDecoder) Next() (Frame, int, error) {
+	skipped := 0
+	for {
+		data, skippedNow, err := decoder.NextRaw()
+		skipped += skippedNow
+		if err != nil {
+			return nil, skipped, err
+		}
+
+		frame, err := decoder.decode(data)
+		if errors.Is(err, FunctionRejectedError) {
+			return nil, skipped, err
+		}
+		if err != nil {
+			if log.IsLevelEnabled(log.DebugLevel) {
+				log.WithField("data", DataWithEscapeChars(string(data))).WithError(err).Debug("Skipping data that could not be decoded")
+			}
+			skipped += len(data)
+			continue
+		}
+		return frame, skipped, nil
+	}
+}
+
+// NextRaw reads from the stream until the next frame has been found and returns it without decoding it
+func (decoder *streamDecoder) NextRaw() ([]byte, int, error) {
+	if decoder.returned > 0 {
+		decoder.consume(decoder.returned)
+		decoder.returned = 0
+	}
+
+	data, skipped := decoder.scan()
+	emptyReads := 0
+
+	for data == nil {
+		if !decoder.deadline.IsZero() && !time.Now().Before(decoder.deadline) {
+			return nil, skipped, os.ErrDeadlineExceeded
+		}
+		n, readErr := decoder.reader.Read(decoder.readChunk)
+		decoder.buffer = append(decoder.buffer, decoder.readChunk[:n]...)
+
+		if n > 0 {
+			emptyReads = 0
+			var skippedNow int
+			data, skippedNow = decoder.scan()
+			skipped += skippedNow
+		} else if readErr == nil && decoder.deadline.IsZero() {
+			emptyReads++
+			if emptyReads >= maxConsecutiveEmptyReads {
+				return nil, skipped, io.ErrNoProgress
+			}
+		}
+
+		if data == nil && readErr != nil {
+			return nil, skipped, readErr
+		}
+	}
+
+	return data, skipped, nil
+}
+
+// SetDeadline sets the time Next and NextRaw give up at
+func (decoder *streamDecoder) SetDeadline(deadline time.Time) {
+	decoder.deadline = deadline
+}
+
+// Buffered returns the data read from the stream but not consumed yet
+func (decoder *streamDecoder) Buffered() []byte {
+	return decoder.buffer[decoder.returned:]
+}
+
+// Discard drops the data read from the stream but not consumed yet
+func (decoder *streamDecoder) Discard() {
+	decoder.buffer = decoder.buffer[:0]
+	decoder.returned = 0
+}
+
+// scan looks for a complete frame in the buffered data.
+// It discards all data in front of the frame and returns the frame (or nil if there is none)
+// together with the number of bytes discarded. The frame is kept in the buffer until the next call of NextRaw.
+func (decoder *streamDecoder) scan() ([]byte, int) {
+	skipped := 0
+
+	for {
+		start := bytes.IndexByte(decoder.buffer, decoder.startChar)
+		if start < 0 {
+			skipped += decoder.skip(len(decoder.buffer))
+			return nil, skipped
+		}
+		skipped += decoder.skip(start)
+
+		end := decoder.indexOfFrameBoundary(decoder.buffer[1:])
+		if end < 0 {
+			if len(decoder.buffer) > MAXIMUM_FRAME_LENGTH {
+				skipped += decoder.skip(len(decoder.buffer))
+			}
+			// Wait for more data
+			return nil, skipped
+		}
+		end++ // Account for the start character
+
+		if decoder.buffer[end] == decoder.startChar {
+			// A new frame starts before the current one ended. Drop the half frame.
+			skipped += decoder.skip(end)
+			continue
+		}
+
+		decoder.returned = end + 1
+		return decoder.buffer[:end+1], skipped
+	}
+}
+
+// indexOfFrameBoundary returns the index of the first start or end character in the given data or -1
+func (decoder *streamDecoder) indexOfFrameBoundary(data []byte) int {
+	for i, char := range data {
+		if char == decoder.startChar || char == decoder.endChar {
+			return i
+		}
+	}
+	return -1
+}
+
+// skip discards the first n bytes of the buffer and logs them as skipped.
+// It returns n.
+func (decoder *streamDecoder) skip(n int) int {
+	if n > 0 {
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.WithField("data", DataWithEscapeChars(string(decoder.buffer[:n]))).Debug("Skipping data not belonging to a frame")
+		}
+		decoder.consume(n)
+	}
+	return n
+}
+
+// consume removes the first n bytes from the buffer
+func (decoder *streamDecoder) consume(n int) {
+	decoder.buffer = decoder.buffer[:copy(decoder.buffer, decoder.buffer[n:])]
+}
+
+func (decoder *streamDecoder) markAsValidStreamDecoder() { /*Intentionally empty*/ }
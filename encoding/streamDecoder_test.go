@@ -0,0 +1,195 @@
+package encoding
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+// chunkReader returns the given chunks one per read and the given error once all chunks have been read
+type chunkReader struct {
+	chunks []string
+	err    error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+type expectedFrame struct {
+	address  int
+	function int
+	value    int
+	skipped  int
+}
+
+func setupStreamDecoder(t *testing.T, reader io.Reader) StreamDecoder {
+	encoder, err := NewSerialEncoder()
+	must.NoError(t, err)
+	return NewStreamDecoder(reader, encoder.DecodeBytes)
+}
+
+func TestStreamDecoderGood(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected []expectedFrame
+	}{
+		{"single frame", "\n010lW#020030\r", []expectedFrame{{10, 20, 30, 0}}},
+		{"multiple frames", "\n010lW#020030\r\n011lW#021031\r", []expectedFrame{{10, 20, 30, 0}, {11, 21, 31, 0}}},
+		{"leading noise", "xyz\n010lW#020030\r", []expectedFrame{{10, 20, 30, 3}}},
+		{"noise between frames", "\n010lW#020030\r\x00\xff\n011lW#021031\r", []expectedFrame{{10, 20, 30, 0}, {11, 21, 31, 2}}},
+		{"half frame", "\n010lW#02\n011lW#021031\r", []expectedFrame{{11, 21, 31, 9}}},
+		{"carriage return without start", "10lW#020030\r\n011lW#021031\r", []expectedFrame{{11, 21, 31, 12}}},
+		{"undecodable frame", "\n010lX#020030\r\n011lW#021031\r", []expectedFrame{{11, 21, 31, 14}}},
+		{"overlong noise", "\n" + strings.Repeat("0", MAXIMUM_FRAME_LENGTH) + "\n011lW#021031\r", []expectedFrame{{11, 21, 31, MAXIMUM_FRAME_LENGTH + 1}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, oneByte := range []bool{false, true} {
+				t.Run(fmt.Sprintf("oneByte=%t", oneByte), func(t *testing.T) {
+					var reader io.Reader = strings.NewReader(tc.input)
+					if oneByte {
+						reader = iotest.OneByteReader(reader)
+					}
+					decoder := setupStreamDecoder(t, reader)
+
+					for _, expected := range tc.expected {
+						frame, skipped, err := decoder.Next()
+						must.NoError(t, err)
+						testFrameValues(t, frame, expected.address, ReadResponse, expected.function, expected.value)
+						test.EqOp(t, expected.skipped, skipped)
+					}
+
+					_, _, err := decoder.Next()
+					test.ErrorIs(t, err, io.EOF)
+				})
+			}
+		})
+	}
+}
+
+func TestStreamDecoderNoiseOnly(t *testing.T) {
+	decoder := setupStreamDecoder(t, strings.NewReader("noise\r\nmore noise"))
+
+	frame, skipped, err := decoder.Next()
+
+	test.ErrorIs(t, err, io.EOF)
+	test.Nil(t, frame)
+	test.EqOp(t, 6, skipped)
+}
+
+func TestStreamDecoderKeepsPartialFrameOnError(t *testing.T) {
+	someErr := fmt.Errorf("Some Read failure")
+	reader := &chunkReader{chunks: []string{"ab\n010lW#02"}, err: someErr}
+	decoder := setupStreamDecoder(t, reader)
+
+	_, skipped, err := decoder.Next()
+	test.ErrorIs(t, err, someErr)
+	test.EqOp(t, 2, skipped)
+
+	reader.chunks = []string{"0030\r"}
+	frame, skipped, err := decoder.Next()
+	must.NoError(t, err)
+	testFrameValues(t, frame, 10, ReadResponse, 20, 30)
+	test.EqOp(t, 0, skipped)
+}
+
+func TestStreamDecoderReadError(t *testing.T) {
+	someErr := fmt.Errorf("Some Read failure")
+	decoder := setupStreamDecoder(t, iotest.ErrReader(someErr))
+
+	_, _, err := decoder.Next()
+
+	test.ErrorIs(t, err, someErr)
+}
+
+func TestStreamDecoderDataAndErrorInSameRead(t *testing.T) {
+	decoder := setupStreamDecoder(t, iotest.DataErrReader(strings.NewReader("\n010lW#020030\r")))
+
+	frame, _, err := decoder.Next()
+	must.NoError(t, err)
+	testFrameValues(t, frame, 10, ReadResponse, 20, 30)
+
+	_, _, err = decoder.Next()
+	test.ErrorIs(t, err, io.EOF)
+}
+
+func TestStreamDecoderNoProgress(t *testing.T) {
+	reader := &chunkReader{}
+	decoder := setupStreamDecoder(t, reader)
+
+	_, _, err := decoder.Next()
+
+	test.ErrorIs(t, err, io.ErrNoProgress)
+}
+
+func TestStreamDecoderDeadline(t *testing.T) {
+	reader := &chunkReader{chunks: []string{"ab\n010lW#02"}}
+	decoder := setupStreamDecoder(t, reader)
+	decoder.SetDeadline(time.Now().Add(20 * time.Millisecond))
+
+	// Empty reads are retried until the deadline instead of giving up with io.ErrNoProgress
+	_, skipped, err := decoder.Next()
+	test.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	test.EqOp(t, 2, skipped)
+	test.EqOp(t, "\n010lW#02", string(decoder.Buffered()))
+
+	decoder.Discard()
+	test.SliceEmpty(t, decoder.Buffered())
+
+	reader.chunks = []string{"\n010lW#020030\r"}
+	decoder.SetDeadline(time.Time{})
+	frame, _, err := decoder.Next()
+	must.NoError(t, err)
+	testFrameValues(t, frame, 10, ReadResponse, 20, 30)
+}
+
+func TestStreamDecoderNextRaw(t *testing.T) {
+	decoder := setupStreamDecoder(t, strings.NewReader("xx\n010lW#020030\r\n010sW#?\r\n011"))
+
+	data, skipped, err := decoder.NextRaw()
+	must.NoError(t, err)
+	test.EqOp(t, "\n010lW#020030\r", string(data))
+	test.EqOp(t, 2, skipped)
+	test.EqOp(t, "\n010sW#?\r\n011", string(decoder.Buffered()))
+
+	// Rejections are not decoded either
+	data, skipped, err = decoder.NextRaw()
+	must.NoError(t, err)
+	test.EqOp(t, "\n010sW#?\r", string(data))
+	test.EqOp(t, 0, skipped)
+
+	_, _, err = decoder.NextRaw()
+	test.ErrorIs(t, err, io.EOF)
+	test.EqOp(t, "\n011", string(decoder.Buffered()))
+}
+
+func TestStreamDecoderFunctionRejected(t *testing.T) {
+	decoder := setupStreamDecoder(t, strings.NewReader("ab\n010sW#?\r\n011lW#021031\r"))
+
+	_, skipped, err := decoder.Next()
+	test.ErrorIs(t, err, FunctionRejectedError)
+	test.EqOp(t, 2, skipped)
+
+	frame, skipped, err := decoder.Next()
+	must.NoError(t, err)
+	testFrameValues(t, frame, 11, ReadResponse, 21, 31)
+	test.EqOp(t, 0, skipped)
+}
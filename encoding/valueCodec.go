@@ -0,0 +1,229 @@
+package encoding
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/ansel1/merry/v2"
+)
+
+// ValueOutOfRangeError is the error returned when a value is outside of the valid range.
+// The message describes the range in engineering units.
+var ValueOutOfRangeError = merry.Sentinel("The value is out of range")
+
+// ValueCodec converts the raw value of a frame (MINIMUM_VALUE to MAXIMUM_VALUE) into a value in engineering units and back.
+// A raw value is decoded in three steps:
+// If Signed is set, raw values above MAXIMUM_VALUE/2 are negative numbers in ten's complement (e.g. 999 is -1).
+// Then the Offset is subtracted and finally the result is multiplied by the Scale.
+// The zero ValueCodec leaves the raw value unchanged.
+type ValueCodec struct {
+	// Signed makes raw values above MAXIMUM_VALUE/2 negative
+	Signed bool
+	// Offset is subtracted from the (signed) raw value
+	Offset int
+	// Scale is the factor the value is multiplied with after subtracting the offset (e.g. 0.1 for one decimal).
+	// 0 is treated as 1.
+	Scale float64
+	// Minimum is the smallest valid value before scaling.
+	// If it is nil, the smallest value representable by a raw value is the minimum.
+	Minimum *int
+	// Maximum is the largest valid value before scaling.
+	// If it is nil, the largest value representable by a raw value is the maximum.
+	Maximum *int
+	// Unit is the unit of the value in engineering units
+	Unit string
+}
+
+// Bound returns a pointer to the given value to be used as the Minimum or Maximum of a ValueCodec
+func Bound(value int) *int {
+	return &value
+}
+
+// scale returns the Scale with the default applied
+func (codec ValueCodec) scale() float64 {
+	if codec.Scale == 0 {
+		return 1
+	}
+	return codec.Scale
+}
+
+// representableRange returns the smallest and largest value before scaling a raw value can represent
+func (codec ValueCodec) representableRange() (int, int) {
+	if codec.Signed {
+		return -(MAXIMUM_VALUE+1)/2 - codec.Offset, MAXIMUM_VALUE/2 - codec.Offset
+	}
+	return MINIMUM_VALUE - codec.Offset, MAXIMUM_VALUE - codec.Offset
+}
+
+// IntRange returns the smallest and largest valid value before scaling
+func (codec ValueCodec) IntRange() (int, int) {
+	minimum, maximum := codec.representableRange()
+	if codec.Minimum != nil {
+		minimum = *codec.Minimum
+	}
+	if codec.Maximum != nil {
+		maximum = *codec.Maximum
+	}
+	return minimum, maximum
+}
+
+// Range returns the smallest and largest valid value in engineering units
+func (codec ValueCodec) Range() (float64, float64) {
+	minimum, maximum := codec.IntRange()
+	return codec.scaleInt(minimum), codec.scaleInt(maximum)
+}
+
+// Validate checks whether the valid range can be represented by raw values
+func (codec ValueCodec) Validate() error {
+	if codec.Scale < 0 || math.IsNaN(codec.Scale) || math.IsInf(codec.Scale, 0) {
+		return merry.Errorf("The scale must be a positive number. It was %g", codec.Scale)
+	}
+	representableMinimum, representableMaximum := codec.representableRange()
+	minimum, maximum := codec.IntRange()
+	if minimum > maximum || minimum < representableMinimum || maximum > representableMaximum {
+		return merry.Errorf("The range must be within %d and %d (inclusive) before scaling. It was %d to %d",
+			representableMinimum, representableMaximum, minimum, maximum)
+	}
+	return nil
+}
+
+// scaleInt multiplies the given value with the scale.
+// Scales like 0.1 are applied by dividing by their inverse to get the closest float64 (21.5 instead of 21.500000000000004).
+func (codec ValueCodec) scaleInt(value int) float64 {
+	scale := codec.scale()
+	if inverse := math.Round(1 / scale); scale < 1 && math.Abs(1/scale-inverse) < 1e-9 {
+		return float64(value) / inverse
+	}
+	return float64(value) * scale
+}
+
+// formatWithUnit formats the given value followed by the given unit for error messages
+func formatWithUnit(value float64, unit string) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	if unit != "" {
+		formatted += " " + unit
+	}
+	return formatted
+}
+
+// rangeError returns ValueOutOfRangeError with a message describing the valid range in engineering units
+func (codec ValueCodec) rangeError(value float64) error {
+	minimum, maximum := codec.Range()
+	return merry.Prependf(ValueOutOfRangeError, "The value must be between %s and %s (inclusive). It was %s",
+		formatWithUnit(minimum, codec.Unit), formatWithUnit(maximum, codec.Unit), formatWithUnit(value, codec.Unit))
+}
+
+// checkRange returns the rangeError if the given value before scaling is not in the valid range
+func (codec ValueCodec) checkRange(value int) error {
+	minimum, maximum := codec.IntRange()
+	if value < minimum || value > maximum {
+		return codec.rangeError(codec.scaleInt(value))
+	}
+	return nil
+}
+
+// DecodeInt converts the given raw value into the value before scaling
+func (codec ValueCodec) DecodeInt(raw int) (int, error) {
+	if err := checkValue(raw); err != nil {
+		return 0, err
+	}
+	value := raw
+	if codec.Signed && value > MAXIMUM_VALUE/2 {
+		value -= MAXIMUM_VALUE + 1
+	}
+	value -= codec.Offset
+	if err := codec.checkRange(value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Decode converts the given raw value into the value in engineering units
+func (codec ValueCodec) Decode(raw int) (float64, error) {
+	value, err := codec.DecodeInt(raw)
+	if err != nil {
+		return 0, err
+	}
+	return codec.scaleInt(value), nil
+}
+
+// DecodeFrame converts the value of the given frame into the value in engineering units
+func (codec ValueCodec) DecodeFrame(frame Frame) (float64, error) {
+	return codec.Decode(frame.Value())
+}
+
+// EncodeInt converts the given value before scaling into the raw value
+func (codec ValueCodec) EncodeInt(value int) (int, error) {
+	if err := codec.checkRange(value); err != nil {
+		return 0, err
+	}
+	raw := value + codec.Offset
+	if codec.Signed && raw < 0 {
+		raw += MAXIMUM_VALUE + 1
+	}
+	return raw, nil
+}
+
+// Encode converts the given value in engineering units into the raw value.
+// The value is rounded to the nearest value the raw value can represent.
+func (codec ValueCodec) Encode(value float64) (int, error) {
+	scaled := math.Round(value / codec.scale())
+	minimum, maximum := codec.IntRange()
+	// Checked before converting to int to prevent overflows. NaN fails both comparisons.
+	if !(scaled >= float64(minimum) && scaled <= float64(maximum)) {
+		return 0, codec.rangeError(value)
+	}
+	return codec.EncodeInt(int(scaled))
+}
+
+// SplitCodec combines a value that is split across a high and a low part read from two functions
+// (e.g. an operating hour counter too large for a single raw value).
+// The combined value is high * Base + low.
+type SplitCodec struct {
+	// Base is the factor the high part is multiplied with (e.g. 256 for a 16-bit value split into two bytes).
+	// 0 is treated as MAXIMUM_VALUE+1, the base of a value split into decimal digits.
+	Base int
+	// Unit is the unit of the combined value
+	Unit string
+}
+
+// base returns the Base with the default applied
+func (codec SplitCodec) base() int {
+	if codec.Base == 0 {
+		return MAXIMUM_VALUE + 1
+	}
+	return codec.Base
+}
+
+// Validate checks whether both parts can be represented by raw values
+func (codec SplitCodec) Validate() error {
+	if codec.Base < 0 || codec.Base == 1 || codec.Base > MAXIMUM_VALUE+1 {
+		return merry.Errorf("The base must be between 2 and %d (inclusive). It was %d", MAXIMUM_VALUE+1, codec.Base)
+	}
+	return nil
+}
+
+// Maximum returns the largest combined value
+func (codec SplitCodec) Maximum() int {
+	return MAXIMUM_VALUE*codec.base() + codec.base() - 1
+}
+
+// Combine combines the given raw high and low parts
+func (codec SplitCodec) Combine(high int, low int) (int, error) {
+	if err := checkValue(high); err != nil {
+		return 0, merry.Prepend(err, "Invalid high part")
+	}
+	if low < MINIMUM_VALUE || low >= codec.base() {
+		return 0, merry.Prependf(ValueOutOfRangeError, "The low part must be between %d and %d (inclusive). It was %d", MINIMUM_VALUE, codec.base()-1, low)
+	}
+	return high*codec.base() + low, nil
+}
+
+// Split splits the given value into its raw high and low parts
+func (codec SplitCodec) Split(value int) (int, int, error) {
+	if value < 0 || value > codec.Maximum() {
+		return 0, 0, merry.Prependf(ValueOutOfRangeError, "The value must be between %s and %s (inclusive). It was %s",
+			formatWithUnit(0, codec.Unit), formatWithUnit(float64(codec.Maximum()), codec.Unit), formatWithUnit(float64(value), codec.Unit))
+	}
+	return value / codec.base(), value % codec.base(), nil
+}
@@ -0,0 +1,201 @@
+package encoding
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestValueCodecGood(t *testing.T) {
+	testCases := []struct {
+		name    string
+		codec   ValueCodec
+		raw     int
+		integer int
+		value   float64
+	}{
+		{"zero codec", ValueCodec{}, 123, 123, 123},
+		{"scale", ValueCodec{Scale: 0.1}, 215, 215, 21.5},
+		{"scale above one", ValueCodec{Scale: 5}, 20, 20, 100},
+		{"signed positive", ValueCodec{Signed: true, Scale: 0.1}, 499, 499, 49.9},
+		{"signed negative", ValueCodec{Signed: true, Scale: 0.1}, 999, -1, -0.1},
+		{"signed most negative", ValueCodec{Signed: true, Scale: 0.1}, 500, -500, -50},
+		{"offset", ValueCodec{Offset: 400, Scale: 0.1}, 285, -115, -11.5},
+		{"signed and offset", ValueCodec{Signed: true, Offset: -100}, 990, 90, 90},
+		{"range", ValueCodec{Minimum: Bound(10), Maximum: Bound(20)}, 15, 15, 15},
+		{"only zero", ValueCodec{Minimum: Bound(0), Maximum: Bound(0)}, 0, 0, 0},
+		{"only minimum", ValueCodec{Signed: true, Minimum: Bound(-10)}, 499, 499, 499},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			must.NoError(t, tc.codec.Validate())
+
+			integer, err := tc.codec.DecodeInt(tc.raw)
+			must.NoError(t, err)
+			test.EqOp(t, tc.integer, integer)
+
+			value, err := tc.codec.Decode(tc.raw)
+			must.NoError(t, err)
+			test.EqOp(t, tc.value, value)
+
+			frame, err := NewReadResponse(10, 20, tc.raw)
+			must.NoError(t, err)
+			value, err = tc.codec.DecodeFrame(frame)
+			must.NoError(t, err)
+			test.EqOp(t, tc.value, value)
+
+			raw, err := tc.codec.EncodeInt(tc.integer)
+			must.NoError(t, err)
+			test.EqOp(t, tc.raw, raw)
+
+			raw, err = tc.codec.Encode(tc.value)
+			must.NoError(t, err)
+			test.EqOp(t, tc.raw, raw)
+		})
+	}
+}
+
+func TestValueCodecEncodeRounds(t *testing.T) {
+	codec := ValueCodec{Scale: 0.1}
+
+	raw, err := codec.Encode(21.54)
+	must.NoError(t, err)
+	test.EqOp(t, 215, raw)
+
+	raw, err = codec.Encode(21.56)
+	must.NoError(t, err)
+	test.EqOp(t, 216, raw)
+}
+
+func TestValueCodecRange(t *testing.T) {
+	testCases := []struct {
+		codec    ValueCodec
+		minimum  float64
+		maximum  float64
+		intRange [2]int
+	}{
+		{ValueCodec{}, MINIMUM_VALUE, MAXIMUM_VALUE, [2]int{MINIMUM_VALUE, MAXIMUM_VALUE}},
+		{ValueCodec{Scale: 0.1}, 0, 99.9, [2]int{0, 999}},
+		{ValueCodec{Signed: true, Scale: 0.1}, -50, 49.9, [2]int{-500, 499}},
+		{ValueCodec{Offset: 400}, -400, 599, [2]int{-400, 599}},
+		{ValueCodec{Signed: true, Scale: 0.5, Minimum: Bound(-20), Maximum: Bound(80)}, -10, 40, [2]int{-20, 80}},
+		{ValueCodec{Minimum: Bound(0), Maximum: Bound(0)}, 0, 0, [2]int{0, 0}},
+		{ValueCodec{Signed: true, Maximum: Bound(10)}, -500, 10, [2]int{-500, 10}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.codec), func(t *testing.T) {
+			minimum, maximum := tc.codec.Range()
+			test.EqOp(t, tc.minimum, minimum)
+			test.EqOp(t, tc.maximum, maximum)
+
+			intMinimum, intMaximum := tc.codec.IntRange()
+			test.EqOp(t, tc.intRange, [2]int{intMinimum, intMaximum})
+		})
+	}
+}
+
+func TestValueCodecOutOfRange(t *testing.T) {
+	codec := ValueCodec{Signed: true, Scale: 0.1, Minimum: Bound(-300), Maximum: Bound(450), Unit: "°C"}
+
+	_, err := codec.Decode(460)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	test.ErrorContains(t, err, "The value must be between -30 °C and 45 °C (inclusive). It was 46 °C")
+
+	_, err = codec.Decode(MAXIMUM_VALUE + 1)
+	test.Error(t, err)
+
+	for _, value := range []float64{-30.1, 45.1, 1e300, -1e300, math.Inf(1), math.NaN()} {
+		_, err = codec.Encode(value)
+		test.ErrorIs(t, err, ValueOutOfRangeError, test.Sprintf("value %g", value))
+	}
+	_, err = codec.Encode(50)
+	test.ErrorContains(t, err, "It was 50 °C")
+
+	_, err = codec.EncodeInt(451)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	test.ErrorContains(t, err, "It was 45.1 °C")
+}
+
+func TestValueCodecOnlyZero(t *testing.T) {
+	codec := ValueCodec{Minimum: Bound(0), Maximum: Bound(0)}
+	must.NoError(t, codec.Validate())
+
+	_, err := codec.Decode(1)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	test.ErrorContains(t, err, "The value must be between 0 and 0 (inclusive). It was 1")
+	_, err = codec.Encode(1)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+}
+
+func TestValueCodecValidateBad(t *testing.T) {
+	testCases := []struct {
+		name  string
+		codec ValueCodec
+	}{
+		{"negative scale", ValueCodec{Scale: -1}},
+		{"nan scale", ValueCodec{Scale: math.NaN()}},
+		{"minimum above maximum", ValueCodec{Minimum: Bound(10), Maximum: Bound(5)}},
+		{"maximum not representable", ValueCodec{Maximum: Bound(MAXIMUM_VALUE + 1)}},
+		{"negative not representable", ValueCodec{Minimum: Bound(-1), Maximum: Bound(10)}},
+		{"signed maximum not representable", ValueCodec{Signed: true, Maximum: Bound(500)}},
+		{"offset maximum not representable", ValueCodec{Offset: 10, Maximum: Bound(990)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test.Error(t, tc.codec.Validate())
+		})
+	}
+}
+
+func TestSplitCodec(t *testing.T) {
+	testCases := []struct {
+		codec SplitCodec
+		high  int
+		low   int
+		value int
+	}{
+		{SplitCodec{}, 12, 345, 12345},
+		{SplitCodec{}, MAXIMUM_VALUE, MAXIMUM_VALUE, 999999},
+		{SplitCodec{Base: 256}, 0x12, 0x34, 0x1234},
+		{SplitCodec{Base: 256}, 0, 255, 255},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v %d", tc.codec, tc.value), func(t *testing.T) {
+			must.NoError(t, tc.codec.Validate())
+
+			value, err := tc.codec.Combine(tc.high, tc.low)
+			must.NoError(t, err)
+			test.EqOp(t, tc.value, value)
+
+			high, low, err := tc.codec.Split(tc.value)
+			must.NoError(t, err)
+			test.EqOp(t, tc.high, high)
+			test.EqOp(t, tc.low, low)
+		})
+	}
+}
+
+func TestSplitCodecBad(t *testing.T) {
+	codec := SplitCodec{Base: 256, Unit: "h"}
+
+	_, err := codec.Combine(1, 256)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	_, err = codec.Combine(MAXIMUM_VALUE+1, 0)
+	test.Error(t, err)
+	_, _, err = codec.Split(-1)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	_, _, err = codec.Split(codec.Maximum() + 1)
+	test.ErrorIs(t, err, ValueOutOfRangeError)
+	test.ErrorContains(t, err, "between 0 h and 255999 h")
+
+	for _, base := range []int{-1, 1, MAXIMUM_VALUE + 2} {
+		test.Error(t, SplitCodec{Base: base}.Validate())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/ventcon/ventcon-hwio/device"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/serial"
+)
+
+// SCAN_COMMAND is the name of the subcommand scanning the configured buses for ventilators
+const SCAN_COMMAND = "scan"
+
+// runScan scans the buses of the configured ports for ventilators and writes the devices found to out.
+// The args are the flags of the subcommand.
+func runScan(ctx context.Context, config Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(SCAN_COMMAND, flag.ContinueOnError)
+	flags.SetOutput(out)
+	first := flags.Int("first", encoding.MINIMUM_ADDRESS, "The first address probed")
+	last := flags.Int("last", encoding.MAXIMUM_ADDRESS, "The last address probed")
+	timeout := flags.Duration("timeout", serial.DEFAULT_PROBE_TIMEOUT, "The time a single probe may take including its retries")
+	function := flags.String("function", device.FUNCTION_FAN_LEVEL, "The name of the catalog function read by the probes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(config.Ports) == 0 {
+		return merry.New("No ports configured to scan")
+	}
+
+	functionCatalog := config.Catalog.OrDefault()
+	probed, ok := functionCatalog.FunctionByName(*function)
+	if !ok {
+		return merry.Errorf("The function %s is not in the catalog %s", *function, functionCatalog.Name())
+	}
+	options := serial.ScanOptions{First: *first, Last: *last, Timeout: *timeout, Probe: serial.ReadProbe(probed.Number)}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	buses, _, err := newBusManager(config)
+	if err != nil {
+		return err
+	}
+	if err := buses.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := buses.Stop(); err != nil {
+			log.WithError(err).Warn("Failed to stop bus manager.")
+		}
+	}()
+
+	devices, err := buses.Discover(ctx, options)
+	if printErr := printScannedDevices(out, devices); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// newBusManager creates a bus manager for the configured ports and routes
+func newBusManager(config Config) (*serial.BusManager, chan<- serial.Request, error) {
+	var options []serial.ManagerOptions
+	for _, port := range config.Ports {
+		options = append(options, serial.ManagerOptions{
+			Port:      serial.PortOptions(port),
+			Dialect:   encoding.Dialect(config.Dialect),
+			Validator: config.Catalog.OrDefault(),
+			Retry:     config.Retry.Policy(),
+			Reconnect: config.Reconnect.Policy(),
+		})
+	}
+	return serial.NewBusManager(options, config.Routes)
+}
+
+// printScannedDevices writes the given devices as a table to out
+func printScannedDevices(out io.Writer, devices []serial.ScannedDevice) error {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PORT\tADDRESS\tLATENCY\tNOTE")
+	for _, found := range devices {
+		note := ""
+		if found.Rejected {
+			note = "rejected the probed function"
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\n", found.Port, found.Address, found.Latency.Round(100*time.Microsecond), note)
+	}
+	fmt.Fprintf(table, "%d devices found\n", len(devices))
+	return table.Flush()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/serial"
+)
+
+// startTCPBus listens on a local TCP port standing in for a bus with a single ventilator with the given address
+func startTCPBus(t *testing.T, address int) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	encoder, err := encoding.NewSerialEncoder()
+	must.NoError(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var received []byte
+		var buffer [64]byte
+		for {
+			n, err := conn.Read(buffer[:])
+			if err != nil {
+				return
+			}
+			received = append(received, buffer[:n]...)
+			for {
+				end := bytes.IndexByte(received, encoding.DefaultDialect.EndChar)
+				if end < 0 {
+					break
+				}
+				request, err := encoder.DecodeRequestBytes(received[:end+1])
+				received = received[end+1:]
+				if err != nil || request.Address() != address {
+					continue
+				}
+				response, err := encoding.NewReadResponse(request.Address(), request.Function(), 2)
+				if err != nil {
+					continue
+				}
+				encoded, err := encoder.Encode(response)
+				if err != nil {
+					continue
+				}
+				conn.Write([]byte(encoded))
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func scanConfig(t *testing.T, ports ...string) Config {
+	config := Config{Retry: Retry{MaxAttempts: 1}}
+	for _, port := range ports {
+		options, err := serial.ParsePortOptions(port)
+		must.NoError(t, err)
+		config.Ports = append(config.Ports, Port(options))
+	}
+	return config
+}
+
+func TestRunScan(t *testing.T) {
+	address := startTCPBus(t, 7)
+	port := serial.TCP_SCHEME + address + "?responseTimeout=10ms"
+	config := scanConfig(t, port)
+
+	var out bytes.Buffer
+	err := runScan(context.Background(), config, []string{"-first", "5", "-last", "9", "-timeout", "50ms"}, &out)
+	must.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	must.Len(t, 3, lines)
+	test.StrHasPrefix(t, "PORT", lines[0])
+	test.StrHasPrefix(t, serial.TCP_SCHEME+address+"  7  ", lines[1])
+	test.EqOp(t, "1 devices found", lines[2])
+}
+
+func TestRunScanConflict(t *testing.T) {
+	first := serial.TCP_SCHEME + startTCPBus(t, 7)
+	second := serial.TCP_SCHEME + startTCPBus(t, 7)
+	config := scanConfig(t, first+"?responseTimeout=10ms", second+"?responseTimeout=10ms")
+
+	var out bytes.Buffer
+	err := runScan(context.Background(), config, []string{"-first", "6", "-last", "8", "-timeout", "50ms"}, &out)
+	test.ErrorContains(t, err, "The address 7 has been found on the ports")
+	test.StrContains(t, out.String(), "2 devices found")
+}
+
+func TestRunScanBad(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   Config
+		args     []string
+		expected string
+	}{
+		{"no ports", Config{}, nil, "No ports configured"},
+		{"unknown function", scanConfig(t, "/dev/ttyUSB0"), []string{"-function", "foo"}, "The function foo is not in the catalog default"},
+		{"unknown route", Config{Ports: scanConfig(t, "/dev/ttyUSB0").Ports, Routes: Routes{1: "/dev/ttyUSB1"}}, nil, "Can't route address 1 to the unknown port /dev/ttyUSB1"},
+		{"invalid reconnect policy", Config{Ports: scanConfig(t, "/dev/ttyUSB0").Ports, Reconnect: Reconnect{Backoff: -time.Second}}, nil, "The reconnect backoff must not be negative"},
+		{"invalid range", scanConfig(t, "/dev/ttyUSB0"), []string{"-first", "9", "-last", "5"}, "first must not be larger than the last"},
+		{"unknown flag", scanConfig(t, "/dev/ttyUSB0"), []string{"-foo"}, "flag provided but not defined"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			var out bytes.Buffer
+			test.ErrorContains(t, runScan(ctx, tc.config, tc.args, &out), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/ventcon/ventcon-hwio/encoding"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UnknownAddressError is returned for requests to an address that is not routed to any bus
+var UnknownAddressError = merry.Sentinel("No bus is known for the address")
+
+// bus is a single bus of a BusManager
+type bus struct {
+	manager  SerialManager
+	requests chan<- Request
+	port     string
+}
+
+// BusManager owns the SerialManagers of several buses and routes each request to the bus of its address.
+// The buses handle their requests concurrently. Requests to a bus that is busy are queued until it takes them,
+// so they don't hold up the requests to the other buses. It is safe for concurrent use.
+type BusManager struct {
+	buses    []bus
+	requests chan Request
+	done     chan struct{}
+	stopped  chan struct{}
+
+	mutex sync.RWMutex
+	// routes maps the addresses to the index of their bus
+	routes map[int]int
+
+	// lifecycle guards starting and stopping the bus manager
+	lifecycle sync.Mutex
+	// started is set once Start has been called
+	started bool
+	// routing is set once the requests are routed
+	routing bool
+	// stopping is set once Stop has been called
+	stopping bool
+	// stopErr is the result of stopping the bus manager
+	stopErr error
+}
+
+// NewBusManager creates a BusManager with a SerialManager for each of the given options
+// and routes the given addresses to the bus of the port with the given name.
+// Requests are sent to the returned channel.
+func NewBusManager(options []ManagerOptions, routes map[int]string) (*BusManager, chan<- Request, error) {
+	if len(options) == 0 {
+		return nil, nil, merry.New("A bus manager needs at least one port")
+	}
+	requests := make(chan Request)
+	busManager := &BusManager{
+		requests: requests,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		routes:   make(map[int]int),
+	}
+	for _, busOptions := range options {
+		if slices.ContainsFunc(busManager.buses, func(bus bus) bool { return bus.port == busOptions.Port.Name }) {
+			return nil, nil, merry.Errorf("The port %s is configured more than once", busOptions.Port.Name)
+		}
+		manager, requests, err := NewSerialManagerWithOptions(busOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		busManager.buses = append(busManager.buses, bus{manager: manager, requests: requests, port: busOptions.Port.Name})
+	}
+	for _, address := range slices.Sorted(maps.Keys(routes)) {
+		if err := busManager.Route(address, routes[address]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return busManager, requests, nil
+}
+
+// Start starts the managers of all buses and routing the requests.
+// If a bus fails to start, the buses started already are stopped again.
+// A bus manager can only be started once.
+func (busManager *BusManager) Start() error {
+	busManager.lifecycle.Lock()
+	defer busManager.lifecycle.Unlock()
+	if busManager.stopping {
+		return merry.New("The bus manager has already been stopped")
+	}
+	if busManager.started {
+		return merry.New("The bus manager has already been started")
+	}
+	busManager.started = true
+	for i, bus := range busManager.buses {
+		if err := bus.manager.Start(); err != nil {
+			for _, started := range busManager.buses[:i] {
+				if stopErr := started.manager.Stop(); stopErr != nil {
+					log.WithField("port", started.port).WithError(stopErr).Warn("Failed to stop serial manager")
+				}
+			}
+			return merry.Prependf(err, "Failed to start the bus of port %s", bus.port)
+		}
+	}
+	busManager.routing = true
+	go busManager.run()
+	return nil
+}
+
+// Stop stops routing the requests and the managers of all buses.
+// Stopping a bus manager that has not been started or has been stopped already does nothing
+// but returning the result of stopping it.
+func (busManager *BusManager) Stop() error {
+	busManager.lifecycle.Lock()
+	defer busManager.lifecycle.Unlock()
+	if busManager.stopping {
+		return busManager.stopErr
+	}
+	busManager.stopping = true
+	close(busManager.done)
+	if !busManager.routing {
+		// Not started or the buses have been stopped again after one failed to start
+		return nil
+	}
+	<-busManager.stopped
+	var errs []error
+	for _, bus := range busManager.buses {
+		if err := bus.manager.Stop(); err != nil {
+			errs = append(errs, merry.Prependf(err, "Failed to stop the bus of port %s", bus.port))
+		}
+	}
+	busManager.stopErr = errors.Join(errs...)
+	return busManager.stopErr
+}
+
+// Route routes the requests to the given address to the bus of the port with the given name
+func (busManager *BusManager) Route(address int, port string) error {
+	if address < encoding.MINIMUM_ADDRESS || address > encoding.MAXIMUM_ADDRESS {
+		return merry.Errorf("The address must be between %d and %d (inclusive). It was %d", encoding.MINIMUM_ADDRESS, encoding.MAXIMUM_ADDRESS, address)
+	}
+	index := slices.IndexFunc(busManager.buses, func(bus bus) bool { return bus.port == port })
+	if index < 0 {
+		return merry.Errorf("Can't route address %d to the unknown port %s", address, port)
+	}
+	busManager.mutex.Lock()
+	defer busManager.mutex.Unlock()
+	busManager.routes[address] = index
+	return nil
+}
+
+// Routes returns the names of the ports of the buses the addresses are routed to
+func (busManager *BusManager) Routes() map[int]string {
+	busManager.mutex.RLock()
+	defer busManager.mutex.RUnlock()
+	routes := make(map[int]string, len(busManager.routes))
+	for address, index := range busManager.routes {
+		routes[address] = busManager.buses[index].port
+	}
+	return routes
+}
+
+// Buses returns the managers of all buses in the order of their options
+func (busManager *BusManager) Buses() []SerialManager {
+	managers := make([]SerialManager, len(busManager.buses))
+	for i, bus := range busManager.buses {
+		managers[i] = bus.manager
+	}
+	return managers
+}
+
+// Statistics returns a snapshot of the statistics of all buses in the order of their options
+func (busManager *BusManager) Statistics() []Statistics {
+	statistics := make([]Statistics, len(busManager.buses))
+	for i, bus := range busManager.buses {
+		statistics[i] = bus.manager.Statistics()
+	}
+	return statistics
+}
+
+// Discover scans all buses (see ScanBuses) and routes the addresses of the devices found to their bus.
+// An address found on more than one bus is not routed at all and reported in the error.
+func (busManager *BusManager) Discover(ctx context.Context, options ScanOptions) ([]ScannedDevice, error) {
+	devices, err := ScanBuses(ctx, busManager.Buses(), options)
+
+	ports := make(map[int][]string)
+	for _, found := range devices {
+		ports[found.Address] = append(ports[found.Address], found.Port)
+	}
+	errs := []error{err}
+	busManager.mutex.Lock()
+	defer busManager.mutex.Unlock()
+	for _, address := range slices.Sorted(maps.Keys(ports)) {
+		found := ports[address]
+		if len(found) > 1 {
+			delete(busManager.routes, address)
+			errs = append(errs, merry.Errorf("The address %d has been found on the ports %v", address, found))
+			continue
+		}
+		index := slices.IndexFunc(busManager.buses, func(bus bus) bool { return bus.port == found[0] })
+		if previous, ok := busManager.routes[address]; ok && previous != index {
+			log.WithField("address", address).WithField("previous", busManager.buses[previous].port).WithField("port", found[0]).Warn("Device found on another bus")
+		}
+		busManager.routes[address] = index
+	}
+	return devices, errors.Join(errs...)
+}
+
+// The indices of the cases run selects from.
+// Each bus has a case passing the first of its queued requests on and a case for its context being done.
+const (
+	routeDoneCase = iota
+	routeRequestsCase
+	routeBusCases
+)
+
+// run routes the requests until the manager is stopped.
+// The requests of each bus are queued until the bus takes them, so a busy or disconnected bus
+// does not hold up the requests to the other buses. A queued request is skipped once its context is done.
+// Closing the request channel closes the request channel of each bus once its queued requests have been passed on.
+func (busManager *BusManager) run() {
+	defer close(busManager.stopped)
+	requests := busManager.requests
+	queues := make([][]Request, len(busManager.buses))
+	closed := make([]bool, len(busManager.buses))
+	cases := make([]reflect.SelectCase, routeBusCases+2*len(busManager.buses))
+	cases[routeDoneCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(busManager.done)}
+
+	for {
+		cases[routeRequestsCase] = reflect.SelectCase{Dir: reflect.SelectRecv}
+		if requests != nil {
+			cases[routeRequestsCase].Chan = reflect.ValueOf(requests)
+		}
+		for i, queue := range queues {
+			send := reflect.SelectCase{Dir: reflect.SelectSend}
+			cancelled := reflect.SelectCase{Dir: reflect.SelectRecv}
+			if len(queue) > 0 {
+				send.Chan, send.Send = reflect.ValueOf(busManager.buses[i].requests), reflect.ValueOf(queue[0])
+				cancelled.Chan = reflect.ValueOf(queue[0].context().Done())
+			} else if requests == nil && !closed[i] {
+				close(busManager.buses[i].requests)
+				closed[i] = true
+			}
+			cases[routeBusCases+2*i], cases[routeBusCases+2*i+1] = send, cancelled
+		}
+
+		chosen, value, ok := reflect.Select(cases)
+		switch {
+		case chosen == routeDoneCase:
+			for _, queue := range queues {
+				for _, request := range queue {
+					skipRequest(request)
+				}
+			}
+			return
+		case chosen == routeRequestsCase:
+			if !ok {
+				requests = nil
+				continue
+			}
+			request := value.Interface().(Request)
+			if index, routed := busManager.route(request); routed {
+				queues[index] = append(queues[index], request)
+			}
+		default:
+			index := (chosen - routeBusCases) / 2
+			if (chosen-routeBusCases)%2 == 1 {
+				// Skipped like any request whose context is done before it has been sent
+				skipRequest(queues[index][0])
+			}
+			queues[index][0] = Request{}
+			queues[index] = queues[index][1:]
+		}
+	}
+}
+
+// route returns the index of the bus of the address of the given request.
+// A request without data is skipped and a request to an unknown address is failed.
+func (busManager *BusManager) route(request Request) (int, bool) {
+	if request.Data == nil {
+		skipRequest(request)
+		return 0, false
+	}
+	address := request.Data.Address()
+	busManager.mutex.RLock()
+	index, ok := busManager.routes[address]
+	busManager.mutex.RUnlock()
+	if !ok {
+		// Failed in the background, so the other requests are not held up by a slow receiver
+		go failRequest(request, merry.Prependf(UnknownAddressError, "Can't send request to address %d", address))
+		return 0, false
+	}
+	return index, true
+}
+
+// skipRequest closes the ResponseChannel of a request that is not passed on to a bus
+func skipRequest(request Request) {
+	if request.ResponseChannel != nil {
+		close(request.ResponseChannel)
+	}
+}
+
+// failRequest writes a response with the given error to the ResponseChannel of the request
+// unless the context of the request is done
+func failRequest(request Request, err error) {
+	if request.ResponseChannel == nil {
+		return
+	}
+	defer close(request.ResponseChannel)
+	select {
+	case request.ResponseChannel <- Response{Err: err}:
+	case <-request.context().Done():
+	}
+}
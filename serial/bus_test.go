@@ -0,0 +1,207 @@
+package serial
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"go.bug.st/serial"
+)
+
+// startBusManager starts a bus manager for the given simulated buses
+func startBusManager(t *testing.T, routes map[int]string, options ...ManagerOptions) (*BusManager, chan<- Request) {
+	busManager, requests, err := NewBusManager(options, routes)
+	must.NoError(t, err)
+	must.NoError(t, busManager.Start())
+	t.Cleanup(func() { busManager.Stop() })
+	return busManager, requests
+}
+
+// sendTo sends a read request to the given address and returns the error of its response
+func sendTo(t *testing.T, requests chan<- Request, address int) error {
+	req, err := encoding.NewReadRequest(address, 2)
+	must.NoError(t, err)
+	responses := make(chan Response, 1)
+	requests <- Request{Data: req, ResponseChannel: responses}
+	response, ok := <-responses
+	must.True(t, ok)
+	return response.Err
+}
+
+func TestNewBusManagerBad(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  []ManagerOptions
+		routes   map[int]string
+		expected string
+	}{
+		{"no ports", nil, nil, "needs at least one port"},
+		{"duplicate port", []ManagerOptions{{Port: DefaultPortOptions("bus1")}, {Port: DefaultPortOptions("bus1")}}, nil, "The port bus1 is configured more than once"},
+		{"invalid port", []ManagerOptions{{Port: PortOptions{Name: "bus1"}}}, nil, "baud rate of port bus1 must be positive"},
+		{"unknown port", []ManagerOptions{{Port: DefaultPortOptions("bus1")}}, map[int]string{3: "bus2"}, "Can't route address 3 to the unknown port bus2"},
+		{"invalid address", []ManagerOptions{{Port: DefaultPortOptions("bus1")}}, map[int]string{0: "bus1"}, "The address must be between 1 and 250 (inclusive). It was 0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := NewBusManager(tc.options, tc.routes)
+			test.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
+
+func TestBusManagerRoutes(t *testing.T) {
+	busManager, requests := startBusManager(t, map[int]string{3: "bus1", 4: "bus2"},
+		simulatedBusOptions(t, "bus1", map[int]bool{3: false}),
+		simulatedBusOptions(t, "bus2", map[int]bool{4: false, 5: false}),
+	)
+
+	test.NoError(t, sendTo(t, requests, 3))
+	test.NoError(t, sendTo(t, requests, 4))
+	err := sendTo(t, requests, 5)
+	test.ErrorIs(t, err, UnknownAddressError)
+	test.ErrorContains(t, err, "Can't send request to address 5")
+
+	must.NoError(t, busManager.Route(5, "bus2"))
+	test.NoError(t, sendTo(t, requests, 5))
+	test.Eq(t, map[int]string{3: "bus1", 4: "bus2", 5: "bus2"}, busManager.Routes())
+
+	statistics := busManager.Statistics()
+	must.Len(t, 2, statistics)
+	test.EqOp(t, "bus1", statistics[0].Port)
+	test.EqOp(t, 1, statistics[0].Successes)
+	test.EqOp(t, "bus2", statistics[1].Port)
+	test.EqOp(t, 2, statistics[1].Successes)
+}
+
+func TestBusManagerDiscover(t *testing.T) {
+	busManager, requests := startBusManager(t, map[int]string{4: "bus1"},
+		simulatedBusOptions(t, "bus1", map[int]bool{3: false, 6: false}),
+		simulatedBusOptions(t, "bus2", map[int]bool{4: false, 6: true}),
+	)
+
+	devices, err := busManager.Discover(context.Background(), ScanOptions{Last: 8, Timeout: 50 * time.Millisecond})
+	test.ErrorContains(t, err, "The address 6 has been found on the ports [bus1 bus2]")
+	test.Len(t, 4, devices)
+	test.Eq(t, map[int]string{3: "bus1", 4: "bus2"}, busManager.Routes())
+
+	test.NoError(t, sendTo(t, requests, 4))
+	test.ErrorIs(t, sendTo(t, requests, 6), UnknownAddressError)
+}
+
+func TestBusManagerStartFailure(t *testing.T) {
+	failing := DefaultPortOptions("bus2")
+	busManager, _, err := NewBusManager([]ManagerOptions{
+		simulatedBusOptions(t, "bus1", nil),
+		{Port: failing, Transport: func(portName string, mode *serial.Mode) (Transport, error) {
+			return nil, &serial.PortError{}
+		}},
+	}, nil)
+	must.NoError(t, err)
+
+	test.ErrorContains(t, busManager.Start(), "Failed to start the bus of port bus2")
+	for _, manager := range busManager.Buses() {
+		test.EqOp(t, Closed, manager.State())
+	}
+}
+
+func TestBusManagerStop(t *testing.T) {
+	busManager, _, err := NewBusManager([]ManagerOptions{simulatedBusOptions(t, "bus1", nil)}, nil)
+	must.NoError(t, err)
+
+	// Stopping before starting neither waits nor panics
+	test.NoError(t, busManager.Stop())
+	test.NoError(t, busManager.Stop())
+	test.ErrorContains(t, busManager.Start(), "already been stopped")
+
+	busManager, _, err = NewBusManager([]ManagerOptions{simulatedBusOptions(t, "bus1", nil)}, nil)
+	must.NoError(t, err)
+	must.NoError(t, busManager.Start())
+	test.ErrorContains(t, busManager.Start(), "already been started")
+	test.NoError(t, busManager.Stop())
+	test.NoError(t, busManager.Stop())
+	test.EqOp(t, Closed, busManager.Buses()[0].State())
+}
+
+func TestBusManagerBusyBus(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	_, requests := startBusManager(t, map[int]string{1: "bus1", 2: "bus1", 4: "bus2"},
+		ManagerOptions{Port: blockedBusOptions(), Transport: pipeOpener(host)},
+		simulatedBusOptions(t, "bus2", map[int]bool{4: false}),
+	)
+
+	blocking, _ := readRequest(t, 1, Control)
+	requests <- blocking
+	<-bus.received
+	// More requests to the blocked bus than it takes ahead, none of them with a context
+	var queued []Request
+	var responses []<-chan Response
+	for range maxPendingRequests + 2 {
+		req, response := readRequest(t, 2, Control)
+		queued = append(queued, req)
+		responses = append(responses, response)
+	}
+	other, otherResponse := readRequest(t, 4, Control)
+
+	routed := make(chan bool)
+	go func() {
+		for _, req := range queued {
+			requests <- req
+		}
+		requests <- other
+		close(routed)
+	}()
+	select {
+	case response := <-otherResponse:
+		test.NoError(t, response.Err)
+	case <-time.After(time.Second):
+		t.Fatal("The request to the other bus waits for the blocked bus")
+	}
+	<-routed
+
+	// The queued requests are passed on even after the request channel has been closed
+	close(requests)
+	close(bus.release)
+	expected := []int{1}
+	for _, response := range responses {
+		test.NoError(t, (<-response).Err)
+		expected = append(expected, 2)
+	}
+	test.Eq(t, expected, bus.requested())
+}
+
+func TestBusManagerRouteCancelled(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	defer close(bus.release)
+	_, requests := startBusManager(t, map[int]string{1: "bus1", 2: "bus1", 4: "bus2"},
+		ManagerOptions{Port: blockedBusOptions(), Transport: pipeOpener(host)},
+		simulatedBusOptions(t, "bus2", map[int]bool{4: false}),
+	)
+
+	blocking, _ := readRequest(t, 1, Control)
+	requests <- blocking
+	<-bus.received
+	// The blocked bus takes a few requests ahead, the bus manager queues the others
+	ctx, cancel := context.WithCancel(context.Background())
+	var waiting <-chan Response
+	for range maxPendingRequests + 2 {
+		req, response := readRequest(t, 2, Control)
+		req.Context = ctx
+		requests <- req
+		waiting = response
+	}
+
+	cancel()
+	select {
+	case _, ok := <-waiting:
+		test.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("The cancelled request is still waiting for its bus")
+	}
+	test.NoError(t, sendTo(t, requests, 4))
+}
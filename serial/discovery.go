@@ -0,0 +1,147 @@
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry/v2"
+	"go.bug.st/serial/enumerator"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// USB_SCHEME is the URL scheme of ports found by their USB metadata instead of their device name
+// (e.g. usb://0403:6001?serialNumber=A10K1Q2X matching the vendor ID, product ID and serial number)
+const USB_SCHEME = "usb://"
+
+// PortNotFoundError is the error returned when no port matches a PortMatcher
+var PortNotFoundError = merry.Sentinel("No matching serial port found")
+
+// listPorts lists the serial ports of the system
+var listPorts = enumerator.GetDetailedPortsList
+
+// ListPorts lists the serial ports of the system with the USB metadata of the ones connected by USB
+func ListPorts() ([]*enumerator.PortDetails, error) {
+	ports, err := listPorts()
+	if err != nil {
+		return nil, merry.Prepend(err, "Failed to list the serial ports")
+	}
+	return ports, nil
+}
+
+// PortMatcher selects a USB serial port by its metadata.
+// Empty fields match all ports. The zero value does not select any port.
+type PortMatcher struct {
+	// VID is the USB vendor ID as 4 hexadecimal digits (e.g. 0403)
+	VID string
+	// PID is the USB product ID as 4 hexadecimal digits (e.g. 6001)
+	PID string
+	// SerialNumber is the serial number of the USB adapter
+	SerialNumber string
+	// Product matches ports whose product description contains it (ignoring case)
+	Product string
+}
+
+// parseUSBPortName parses the vendor and product ID of a port name with the USB_SCHEME
+// (e.g. usb://0403:6001, usb://0403 or usb://)
+func parseUSBPortName(name string) PortMatcher {
+	ids := strings.TrimPrefix(name, USB_SCHEME)
+	vid, pid, _ := strings.Cut(ids, ":")
+	return PortMatcher{VID: vid, PID: pid}
+}
+
+// IsZero returns whether the matcher has no criteria
+func (matcher PortMatcher) IsZero() bool {
+	return matcher == PortMatcher{}
+}
+
+// Validate checks whether the matcher can be used
+func (matcher PortMatcher) Validate() error {
+	if matcher.IsZero() {
+		return merry.New("The port matcher must have at least one of vid, pid, serialNumber or product")
+	}
+	for _, id := range []string{matcher.VID, matcher.PID} {
+		if id == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(id, 16, 16); err != nil || len(id) != 4 {
+			return merry.Errorf("The USB ID %s must consist of 4 hexadecimal digits", id)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the given port matches all criteria of the matcher
+func (matcher PortMatcher) Matches(port *enumerator.PortDetails) bool {
+	if !port.IsUSB || matcher.IsZero() {
+		return false
+	}
+	if matcher.VID != "" && !strings.EqualFold(matcher.VID, port.VID) {
+		return false
+	}
+	if matcher.PID != "" && !strings.EqualFold(matcher.PID, port.PID) {
+		return false
+	}
+	if matcher.SerialNumber != "" && matcher.SerialNumber != port.SerialNumber {
+		return false
+	}
+	if matcher.Product != "" && !strings.Contains(strings.ToLower(port.Product), strings.ToLower(matcher.Product)) {
+		return false
+	}
+	return true
+}
+
+// Resolve returns the name of the only port matching the matcher.
+// It returns PortNotFoundError if there is none and an error if several ports match.
+func (matcher PortMatcher) Resolve() (string, error) {
+	ports, err := ListPorts()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, port := range ports {
+		if matcher.Matches(port) {
+			matches = append(matches, port.Name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", merry.Prepend(PortNotFoundError, fmt.Sprintf("No port matches %s", matcher))
+	case 1:
+		return matches[0], nil
+	default:
+		return "", merry.Errorf("The ports %s all match %s. Add criteria (e.g. the serial number) to select one of them", strings.Join(matches, ", "), matcher)
+	}
+}
+
+// String describes the criteria of the matcher
+func (matcher PortMatcher) String() string {
+	var criteria []string
+	for _, criterion := range []struct{ name, value string }{
+		{"vid", matcher.VID},
+		{"pid", matcher.PID},
+		{"serialNumber", matcher.SerialNumber},
+		{"product", matcher.Product},
+	} {
+		if criterion.value != "" {
+			criteria = append(criteria, fmt.Sprintf("%s=%q", criterion.name, criterion.value))
+		}
+	}
+	return strings.Join(criteria, " ")
+}
+
+// resolve returns the options with the name of the port matching Match if it is set
+func (options PortOptions) resolve() (PortOptions, error) {
+	if options.Match.IsZero() {
+		return options, nil
+	}
+	name, err := options.Match.Resolve()
+	if err != nil {
+		return options, merry.Prependf(err, "Failed to find port %s", options.Name)
+	}
+	log.WithField("port", options.Name).WithField("device", name).Info("Found serial port")
+	options.Name = name
+	options.Match = PortMatcher{}
+	return options, nil
+}
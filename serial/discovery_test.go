@@ -0,0 +1,150 @@
+package serial
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+var testPorts = []*enumerator.PortDetails{
+	{Name: "/dev/ttyS0"},
+	{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A10K1Q2X", Product: "FT232R USB UART"},
+	{Name: "/dev/ttyUSB1", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "B20L2R3Y", Product: "FT232R USB UART"},
+	{Name: "/dev/ttyACM0", IsUSB: true, VID: "2341", PID: "0043", SerialNumber: "7583", Product: "Arduino Uno"},
+}
+
+// stubListPorts makes the system appear to have the ports returned by the given function
+func stubListPorts(t *testing.T, ports func() ([]*enumerator.PortDetails, error)) {
+	oldListPorts := listPorts
+	listPorts = ports
+	t.Cleanup(func() { listPorts = oldListPorts })
+}
+
+func TestPortMatcherValidate(t *testing.T) {
+	test.NoError(t, PortMatcher{VID: "0403", PID: "6001"}.Validate())
+	test.NoError(t, PortMatcher{SerialNumber: "A10K1Q2X"}.Validate())
+
+	test.ErrorContains(t, PortMatcher{}.Validate(), "at least one of")
+	test.ErrorContains(t, PortMatcher{VID: "403"}.Validate(), "USB ID 403 must consist of 4 hexadecimal digits")
+	test.ErrorContains(t, PortMatcher{PID: "60x1"}.Validate(), "USB ID 60x1 must consist of 4 hexadecimal digits")
+}
+
+func TestPortMatcherResolve(t *testing.T) {
+	stubListPorts(t, func() ([]*enumerator.PortDetails, error) { return testPorts, nil })
+
+	testCases := []struct {
+		matcher  PortMatcher
+		expected string
+	}{
+		{PortMatcher{VID: "0403", PID: "6001", SerialNumber: "B20L2R3Y"}, "/dev/ttyUSB1"},
+		{PortMatcher{VID: "2341"}, "/dev/ttyACM0"},
+		{PortMatcher{VID: "0403", SerialNumber: "A10K1Q2X"}, "/dev/ttyUSB0"},
+		{PortMatcher{Product: "arduino"}, "/dev/ttyACM0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.matcher.String(), func(t *testing.T) {
+			name, err := tc.matcher.Resolve()
+			must.NoError(t, err)
+			test.EqOp(t, tc.expected, name)
+		})
+	}
+
+	_, err := PortMatcher{VID: "0403", PID: "6001"}.Resolve()
+	test.ErrorContains(t, err, "/dev/ttyUSB0, /dev/ttyUSB1 all match")
+
+	_, err = PortMatcher{SerialNumber: "C30M3S4Z"}.Resolve()
+	test.ErrorIs(t, err, PortNotFoundError)
+
+	// Ports not connected by USB never match
+	_, err = PortMatcher{Product: "ttyS0"}.Resolve()
+	test.ErrorIs(t, err, PortNotFoundError)
+}
+
+func TestPortMatcherResolveListFailure(t *testing.T) {
+	stubListPorts(t, func() ([]*enumerator.PortDetails, error) { return nil, fmt.Errorf("Some enumeration failure") })
+
+	_, err := PortMatcher{VID: "0403"}.Resolve()
+	test.ErrorContains(t, err, "Failed to list the serial ports: Some enumeration failure")
+}
+
+func TestOpenFindsUSBPort(t *testing.T) {
+	stubListPorts(t, func() ([]*enumerator.PortDetails, error) { return testPorts, nil })
+
+	host, _ := NewPipe()
+	var opened string
+	serial, err := NewSerialForTransport(encoding.DefaultDialect, func(portName string, mode *serial.Mode) (Transport, error) {
+		opened = portName
+		return host, nil
+	})
+	must.NoError(t, err)
+
+	options, err := ParsePortOptions("usb://0403:6001?serialNumber=B20L2R3Y")
+	must.NoError(t, err)
+	must.NoError(t, serial.Open(options))
+	test.EqOp(t, "/dev/ttyUSB1", opened)
+
+	options, err = ParsePortOptions("usb://0403:6001?serialNumber=C30M3S4Z")
+	must.NoError(t, err)
+	test.ErrorIs(t, serial.Open(options), PortNotFoundError)
+}
+
+func TestRunFindsUSBPortAgainWhenReconnecting(t *testing.T) {
+	var mutex sync.Mutex
+	ports := testPorts
+	stubListPorts(t, func() ([]*enumerator.PortDetails, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return ports, nil
+	})
+
+	opened := make(chan string, 2)
+	devices := make(chan Transport, 2)
+	opener := func(portName string, mode *serial.Mode) (Transport, error) {
+		host, device := NewPipe()
+		opened <- portName
+		devices <- device
+		return host, nil
+	}
+
+	options, err := ParsePortOptions("usb://0403?serialNumber=A10K1Q2X")
+	must.NoError(t, err)
+	stateEvents := make(chan StateEvent, 10)
+	reconnect := DefaultReconnectPolicy()
+	reconnect.Backoff = time.Millisecond
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:        options,
+		Reconnect:   reconnect,
+		StateEvents: stateEvents,
+		Transport:   opener,
+	})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+	test.EqOp(t, "/dev/ttyUSB0", <-opened)
+	test.EqOp(t, Connected, (<-stateEvents).State)
+
+	// The adapter is plugged in again and gets another device name
+	mutex.Lock()
+	ports = []*enumerator.PortDetails{{Name: "/dev/ttyUSB2", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A10K1Q2X"}}
+	mutex.Unlock()
+	must.NoError(t, (<-devices).Close())
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+	responses := make(chan Response, 1)
+	requests <- Request{Data: req, ResponseChannel: responses}
+	test.ErrorIs(t, (<-responses).Err, PortFailureError)
+
+	test.EqOp(t, Disconnected, (<-stateEvents).State)
+	test.EqOp(t, Connected, (<-stateEvents).State)
+	test.EqOp(t, "/dev/ttyUSB2", <-opened)
+	test.EqOp(t, options.Name, manager.(*serialManager).port.Name)
+}
@@ -0,0 +1,133 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// IncompleteFrameError is the error returned when only a part of a frame has been received before the response deadline
+var IncompleteFrameError = merry.Sentinel("Incomplete frame")
+
+// frameReader reads frames delimited by the start and end character of a dialect from a serial port
+// with an encoding.StreamDecoder.
+// Unlike a bufio.Reader, it gives up once the response deadline has passed instead of after a number of empty reads.
+// Bytes received after a frame and partial frames are kept for the next read.
+type frameReader struct {
+	port    Transport
+	decoder encoding.StreamDecoder
+	// readTimeout is the read timeout of the port
+	readTimeout time.Duration
+	// responseTimeout is the time a response may take if no deadline has been set
+	responseTimeout time.Duration
+	// portTimeout is the read timeout currently set on the port
+	portTimeout time.Duration
+
+	// ctx ends reading early. It is set for the duration of a request.
+	ctx context.Context
+	// deadline is the time the response has to be received by. It is set for the duration of a request.
+	deadline time.Time
+	// frameDeadline is the time the frame being read has to be received by
+	frameDeadline time.Time
+}
+
+// newFrameReader creates a frame reader for the given port and the dialect of the given encoder
+// using the read timeout set on the port
+func newFrameReader(port Transport, encoder encoding.SerialEncoder, readTimeout time.Duration, responseTimeout time.Duration) *frameReader {
+	reader := &frameReader{
+		port:            port,
+		readTimeout:     readTimeout,
+		responseTimeout: responseTimeout,
+		portTimeout:     readTimeout,
+		ctx:             context.Background(),
+	}
+	reader.decoder = encoding.NewStreamDecoderForDialect(reader, encoder.Dialect(), encoder.DecodeBytes)
+	return reader
+}
+
+// expectResponse bounds the following reads by the given context and the response timeout.
+// The returned function removes the bounds again.
+func (reader *frameReader) expectResponse(ctx context.Context) func() {
+	reader.ctx = ctx
+	reader.deadline = time.Now().Add(reader.responseTimeout)
+	return func() {
+		reader.ctx = context.Background()
+		reader.deadline = time.Time{}
+	}
+}
+
+// ReadFrame returns the next frame including its start and end character.
+// Bytes preceding the start character of the frame (e.g. noise or the rest of a truncated frame) are skipped.
+// It returns NoDataOnSerialError if nothing has been received before the deadline
+// and IncompleteFrameError if only a part of a frame has been received.
+// The returned slice is only valid until the next read.
+func (reader *frameReader) ReadFrame() ([]byte, error) {
+	reader.frameDeadline = reader.deadline
+	if reader.frameDeadline.IsZero() {
+		reader.frameDeadline = time.Now().Add(reader.responseTimeout)
+	}
+	reader.decoder.SetDeadline(reader.frameDeadline)
+
+	frame, _, err := reader.decoder.NextRaw()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		if len(reader.Buffered()) == 0 {
+			return nil, merry.Prepend(NoDataOnSerialError, "No response before the deadline")
+		}
+		return reader.Buffered(), merry.Prepend(IncompleteFrameError, "The response has not been completed before the deadline")
+	}
+	if err != nil {
+		return reader.Buffered(), err
+	}
+	return frame, nil
+}
+
+// Read reads from the port for the decoder.
+// The read returns by the deadline of the frame being read or of the context, whichever comes first.
+func (reader *frameReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	wait := time.Until(reader.frameDeadline)
+	if ctxDeadline, ok := reader.ctx.Deadline(); ok && time.Until(ctxDeadline) < wait {
+		wait = time.Until(ctxDeadline)
+	}
+	// The decoder gives up at the deadline, the context is checked before the next read
+	if err := reader.setPortTimeout(max(wait, time.Millisecond)); err != nil {
+		return 0, err
+	}
+	n, err := reader.port.Read(p)
+	if err != nil {
+		return n, &portFailure{err: err}
+	}
+	return n, nil
+}
+
+// setPortTimeout sets the read timeout of the port so that a read returns by the given remaining time
+func (reader *frameReader) setPortTimeout(remaining time.Duration) error {
+	timeout := reader.readTimeout
+	if remaining < timeout {
+		timeout = remaining
+	}
+	if timeout == reader.portTimeout {
+		return nil
+	}
+	if err := reader.port.SetReadTimeout(timeout); err != nil {
+		return &portFailure{err: merry.Prepend(err, "Failed to set the read timeout")}
+	}
+	reader.portTimeout = timeout
+	return nil
+}
+
+// Buffered returns the bytes received but not read yet
+func (reader *frameReader) Buffered() []byte {
+	return reader.decoder.Buffered()
+}
+
+// Discard drops all bytes received but not read yet
+func (reader *frameReader) Discard() {
+	reader.decoder.Discard()
+}
@@ -0,0 +1,169 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"go.bug.st/serial"
+)
+
+// chunkedTestPort returns one chunk per read and waits for its read timeout once all chunks have been read
+type chunkedTestPort struct {
+	serial.Port
+	chunks       []string
+	emptyReads   int
+	readTimeout  time.Duration
+	timeouts     []time.Duration
+	reads        int
+	failOnChunks bool
+}
+
+func (port *chunkedTestPort) Read(p []byte) (int, error) {
+	port.reads++
+	if len(port.chunks) == 0 || port.reads <= port.emptyReads {
+		if port.failOnChunks {
+			return 0, fmt.Errorf("Some Read failure")
+		}
+		time.Sleep(port.readTimeout)
+		return 0, nil
+	}
+	n := copy(p, port.chunks[0])
+	port.chunks[0] = port.chunks[0][n:]
+	if port.chunks[0] == "" {
+		port.chunks = port.chunks[1:]
+	}
+	return n, nil
+}
+
+func (port *chunkedTestPort) SetReadTimeout(timeout time.Duration) error {
+	port.readTimeout = timeout
+	port.timeouts = append(port.timeouts, timeout)
+	return nil
+}
+
+func newTestFrameReader(t *testing.T, port *chunkedTestPort, responseTimeout time.Duration) *frameReader {
+	encoder, err := encoding.NewSerialEncoder()
+	must.NoError(t, err)
+	port.readTimeout = 5 * time.Millisecond
+	return newFrameReader(port, encoder, port.readTimeout, responseTimeout)
+}
+
+func TestFrameReaderReadsFrames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chunks   []string
+		expected []string
+	}{
+		{"single frame", []string{"\n111lW#222333\r"}, []string{"\n111lW#222333\r"}},
+		{"split frame", []string{"\n111l", "W#222", "333\r"}, []string{"\n111lW#222333\r"}},
+		{"frames in one read", []string{"\n111lW#222333\r\n112lW#?\r"}, []string{"\n111lW#222333\r", "\n112lW#?\r"}},
+		{"frames across reads", []string{"\n111lW#222333\r\n112l", "W#?\r"}, []string{"\n111lW#222333\r", "\n112lW#?\r"}},
+		{"noise before frame", []string{"xx\r\n111lW#222333\r"}, []string{"\n111lW#222333\r"}},
+		{"truncated frame before frame", []string{"\n111lW#2\n112lW#222333\r"}, []string{"\n112lW#222333\r"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := newTestFrameReader(t, &chunkedTestPort{chunks: tc.chunks}, 50*time.Millisecond)
+
+			for _, expected := range tc.expected {
+				frame, err := reader.ReadFrame()
+				must.NoError(t, err)
+				test.EqOp(t, expected, string(frame))
+			}
+			test.SliceEmpty(t, reader.Buffered())
+		})
+	}
+}
+
+func TestFrameReaderNoData(t *testing.T) {
+	port := &chunkedTestPort{}
+	reader := newTestFrameReader(t, port, 20*time.Millisecond)
+	reader.readTimeout, reader.portTimeout = 50*time.Millisecond, 50*time.Millisecond
+
+	start := time.Now()
+	frame, err := reader.ReadFrame()
+
+	test.ErrorIs(t, err, NoDataOnSerialError)
+	test.Nil(t, frame)
+	// The read only waits for the rest of the deadline instead of the read timeout
+	test.Less(t, 45*time.Millisecond, time.Since(start))
+	must.SliceNotEmpty(t, port.timeouts)
+	test.LessEq(t, 20*time.Millisecond, port.timeouts[0])
+}
+
+func TestFrameReaderManyEmptyReads(t *testing.T) {
+	// More empty reads than a bufio.Reader or a StreamDecoder without deadline accept
+	port := &chunkedTestPort{chunks: []string{"\n111lW#222333\r"}, emptyReads: 150}
+	reader := newTestFrameReader(t, port, time.Second)
+	reader.readTimeout = time.Microsecond
+
+	frame, err := reader.ReadFrame()
+	must.NoError(t, err)
+	test.EqOp(t, "\n111lW#222333\r", string(frame))
+	test.EqOp(t, 151, port.reads)
+}
+
+func TestFrameReaderIncompleteFrame(t *testing.T) {
+	port := &chunkedTestPort{chunks: []string{"\n111lW#"}}
+	reader := newTestFrameReader(t, port, 20*time.Millisecond)
+
+	frame, err := reader.ReadFrame()
+	test.ErrorIs(t, err, IncompleteFrameError)
+	test.EqOp(t, "\n111lW#", string(frame))
+
+	// The partial frame is kept for the next read
+	port.chunks = []string{"222333\r"}
+	frame, err = reader.ReadFrame()
+	must.NoError(t, err)
+	test.EqOp(t, "\n111lW#222333\r", string(frame))
+}
+
+func TestFrameReaderDropsOverlongData(t *testing.T) {
+	noise := make([]byte, 2*encoding.MAXIMUM_FRAME_LENGTH+3)
+	for i := range noise {
+		noise[i] = 'x'
+	}
+	port := &chunkedTestPort{chunks: []string{string(noise), "\n111lW#222333\r"}}
+	reader := newTestFrameReader(t, port, 50*time.Millisecond)
+
+	frame, err := reader.ReadFrame()
+	must.NoError(t, err)
+	test.EqOp(t, "\n111lW#222333\r", string(frame))
+}
+
+func TestFrameReaderDeadline(t *testing.T) {
+	port := &chunkedTestPort{}
+	reader := newTestFrameReader(t, port, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := reader.expectResponse(ctx)
+	_, err := reader.ReadFrame()
+	test.ErrorIs(t, err, context.DeadlineExceeded)
+	done()
+
+	reader.responseTimeout = 20 * time.Millisecond
+	done = reader.expectResponse(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	_, err = reader.ReadFrame()
+	test.ErrorIs(t, err, NoDataOnSerialError)
+	done()
+}
+
+func TestFrameReaderPortFailure(t *testing.T) {
+	port := &chunkedTestPort{chunks: []string{"\n111"}, failOnChunks: true}
+	reader := newTestFrameReader(t, port, time.Hour)
+
+	frame, err := reader.ReadFrame()
+	test.ErrorIs(t, err, PortFailureError)
+	test.ErrorContains(t, err, "Some Read failure")
+	test.EqOp(t, "\n111", string(frame))
+	test.False(t, errors.Is(err, NoDataOnSerialError))
+}
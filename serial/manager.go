@@ -1,7 +1,16 @@
 package serial
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansel1/merry/v2"
 	"github.com/ventcon/ventcon-hwio/encoding"
+	"github.com/ventcon/ventcon-hwio/scheduling"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -9,75 +18,507 @@ import (
 type Response struct {
 	Response encoding.Frame
 	Err      error
+	// Attempts is the number of times the request has been sent (see RetryPolicy)
+	Attempts int
+	// Latency is the time the last attempt took from writing the request until receiving the response
+	Latency time.Duration
 }
 
 type Request struct {
 	ResponseChannel chan<- Response
 	Data            encoding.Frame
+	// Context bounds the time the request may take.
+	// A request whose context is done before it has been sent is skipped.
+	// Once the context is done, the response is no longer written to the ResponseChannel (it is only closed).
+	// A nil Context never ends.
+	Context context.Context
+	// Priority is the class of the request. Requests of a higher class are sent first.
+	// The zero value is Control.
+	Priority Priority
+}
+
+// context returns the context of the request or the background context if it has none
+func (request Request) context() context.Context {
+	if request.Context == nil {
+		return context.Background()
+	}
+	return request.Context
 }
 
 type SerialManager interface {
 	Start() error
 	Stop() error
+	// State returns the current state of the connection to the port
+	State() ConnectionState
+	// Scan probes the addresses of the bus one after the other and returns the devices that responded.
+	// The probes are sent between the other requests.
+	Scan(ctx context.Context, options ScanOptions) ([]ScannedDevice, error)
+	// Statistics returns a snapshot of the statistics of the bus since the manager has been created
+	Statistics() Statistics
+	// AddSource adds another channel of requests (e.g. one per client). It must be called before Start.
+	// Within a priority class, the sources take turns.
+	AddSource(source <-chan Request) error
 	markAsValidSerialManager()
 }
 
+// ManagerOptions are the options of a SerialManager
+type ManagerOptions struct {
+	// Port are the options of the port managed
+	Port PortOptions
+	// Dialect is the dialect spoken on the bus. The zero value uses encoding.DefaultDialect.
+	Dialect encoding.Dialect
+	// Validator checks all requests (e.g. a catalog.Catalog) before they are sent. It is optional.
+	Validator encoding.FrameValidator
+	// Retry describes when failed requests are sent again. The zero value does not retry.
+	Retry RetryPolicy
+	// Reconnect describes how the port is reopened after it failed. The zero value does not reopen it.
+	Reconnect ReconnectPolicy
+	// StateEvents receives an event whenever the state of the connection changes. It is optional.
+	// Events are dropped if the channel is not ready to receive them, so it should be buffered.
+	StateEvents chan<- StateEvent
+	// Observer receives the traffic on the port (see Observers to pass it to several ones). It is optional.
+	Observer Observer
+	// Transport opens the port (e.g. returning one end of a NewPipe connected to a simulated device).
+	// It is optional and defaults to OpenTransport.
+	Transport TransportOpener
+	// QueueSize is the number of requests the request channel buffers.
+	// The manager only takes a few requests ahead of the one being sent to send them by priority.
+	// Once the channel is full (immediately for the zero value), senders wait until the manager takes their request.
+	QueueSize int
+}
+
 type serialManager struct {
-	port     string
-	serial   Serial
-	requests <-chan Request
-	stop     chan (chan<- error)
+	port        PortOptions
+	retry       RetryPolicy
+	reconnect   ReconnectPolicy
+	serial      Serial
+	requests    <-chan Request
+	stop        chan (chan<- error)
+	stateEvents chan<- StateEvent
+	state       atomic.Value
+	// probes receives the requests of scans. Unlike requests, it is never closed.
+	probes     chan Request
+	statistics *statistics
+	// sources are the channels of requests including requests and probes
+	sources []<-chan Request
+	// schedulers merge the requests of all sources for each lane fairly
+	schedulers [priorities]scheduling.Scheduler[Request]
+	// lanes receive the requests of each priority class from the schedulers. The lane of the highest class is first.
+	lanes [priorities]chan Request
+	// done is closed when the manager stops to stop splitting the requests of the sources
+	done chan struct{}
+	// queued is the number of requests taken from the sources that have not been handled yet
+	queued atomic.Int64
+
+	// The following fields are only used by the goroutine handling the requests
+
+	// reopenOptions are the options used to reopen the port after it failed
+	reopenOptions PortOptions
+	// reconnectTimer fires when the port should be reopened. It is nil while the port is connected.
+	reconnectTimer *time.Timer
+	// reconnectAttempts is the number of attempts to reopen the port since it failed
+	reconnectAttempts int
+	// quietUntil is the time the bus has been quiet long enough to write the next request
+	quietUntil time.Time
 }
 
-func NewSerialManager(port string) (SerialManager, chan<- Request, error) {
-	serial, err := NewSerial()
-	if err != nil {
+func NewSerialManager(port PortOptions) (SerialManager, chan<- Request, error) {
+	return NewSerialManagerWithValidator(port, nil)
+}
+
+// NewSerialManagerWithValidator creates a new SerialManager that checks all requests using the given validator
+// (e.g. a catalog.Catalog) before sending them.
+func NewSerialManagerWithValidator(port PortOptions, validator encoding.FrameValidator) (SerialManager, chan<- Request, error) {
+	return NewSerialManagerWithOptions(ManagerOptions{Port: port, Validator: validator})
+}
+
+// NewSerialManagerWithOptions creates a new SerialManager using the given options
+func NewSerialManagerWithOptions(options ManagerOptions) (SerialManager, chan<- Request, error) {
+	if err := options.Port.Validate(); err != nil {
 		return nil, nil, err
 	}
-	requests := make(chan Request)
-	return &serialManager{
-		port:     port,
-		serial:   serial,
-		requests: requests,
-		stop:     make(chan (chan<- error)),
-	}, requests, nil
+	if err := options.Retry.Validate(); err != nil {
+		return nil, nil, merry.Prependf(err, "Invalid retry policy of port %s", options.Port.Name)
+	}
+	if err := options.Reconnect.Validate(); err != nil {
+		return nil, nil, merry.Prependf(err, "Invalid reconnect policy of port %s", options.Port.Name)
+	}
+	if options.QueueSize < 0 {
+		return nil, nil, merry.Errorf("The queue size of port %s must not be negative", options.Port.Name)
+	}
+	opener := options.Transport
+	if opener == nil {
+		opener = OpenTransport
+	}
+	dialect := options.Dialect
+	if dialect == (encoding.Dialect{}) {
+		dialect = encoding.DefaultDialect
+	}
+	serial, err := NewSerialForTransport(dialect, opener)
+	if err != nil {
+		return nil, nil, merry.Prependf(err, "Invalid dialect of port %s", options.Port.Name)
+	}
+	serial.SetValidator(options.Validator)
+	if options.Observer != nil {
+		// The events name the configured port even if it has been opened using another name (e.g. usb:// ports)
+		serial.SetObserver(portObserver{port: options.Port.Name, observer: options.Observer})
+	}
+	requests := make(chan Request, options.QueueSize)
+	probes := make(chan Request)
+	manager := &serialManager{
+		port:        options.Port,
+		retry:       options.Retry,
+		reconnect:   options.Reconnect,
+		serial:      serial,
+		requests:    requests,
+		probes:      probes,
+		sources:     []<-chan Request{requests, probes},
+		done:        make(chan struct{}),
+		stop:        make(chan (chan<- error)),
+		stateEvents: options.StateEvents,
+		statistics:  newStatistics(options.Port.Name),
+	}
+	manager.state.Store(Closed)
+	return manager, requests, nil
 }
 
 func (serialManager *serialManager) Start() error {
-	log.Debug("Starting serial manager for ", serialManager.port)
+	log.Debug("Starting serial manager for ", serialManager.port.Name)
 	err := serialManager.serial.Open(serialManager.port)
 	if err != nil {
 		close(serialManager.stop)
 		return err
 	}
-	go func() {
-		for {
-			select {
-			case stopResult := <-serialManager.stop:
-				stopResult <- serialManager.serial.Close()
-				return
-			case request, ok := <-serialManager.requests:
-				if !ok {
-					// The channel has been closed. Wait for a stop signal.
-					stopResult := <-serialManager.stop
-					stopResult <- serialManager.serial.Close()
-					return
-				}
-				if request.Data != nil && request.ResponseChannel != nil {
-					response, err := serialManager.serial.SendRequest(request.Data)
-					request.ResponseChannel <- Response{response, err}
-				}
-				if request.ResponseChannel != nil {
-					close(request.ResponseChannel)
-				}
+	serialManager.reopenOptions = serialManager.port
+	// Ports matched by their USB metadata are looked up again when reopening them
+	if serialManager.reconnect.FollowByID && serialManager.port.Match.IsZero() {
+		serialManager.reopenOptions.Name = stablePortName(serialManager.port.Name)
+	}
+	serialManager.setState(Connected, nil)
+	serialManager.startScheduling()
+	go serialManager.run()
+	return nil
+}
+
+func (serialManager *serialManager) AddSource(source <-chan Request) error {
+	if source == nil {
+		return merry.New("The source must not be nil")
+	}
+	if serialManager.lanes[0] != nil {
+		return merry.Errorf("Cannot add sources to the manager of port %s after starting it", serialManager.port.Name)
+	}
+	serialManager.sources = append(serialManager.sources, source)
+	return nil
+}
+
+// startScheduling starts splitting the requests of all sources into the lanes of their priority
+func (serialManager *serialManager) startScheduling() {
+	var sourceLanes [][priorities]chan Request
+	for lane := range serialManager.lanes {
+		serialManager.lanes[lane] = make(chan Request)
+		serialManager.schedulers[lane] = scheduling.NewFairScheduler[Request](serialManager.lanes[lane])
+	}
+	for range serialManager.sources {
+		var lanes [priorities]chan Request
+		for lane := range lanes {
+			lanes[lane] = make(chan Request)
+			// Cannot fail before starting the scheduler
+			_ = serialManager.schedulers[lane].AddSource(lanes[lane])
+		}
+		sourceLanes = append(sourceLanes, lanes)
+	}
+	for _, scheduler := range serialManager.schedulers {
+		scheduler.Start()
+	}
+	for i, source := range serialManager.sources {
+		go serialManager.splitRequests(source, sourceLanes[i])
+	}
+}
+
+// stopScheduling stops taking requests from the sources.
+// The requests taken already are dropped.
+func (serialManager *serialManager) stopScheduling() {
+	close(serialManager.done)
+	for lane, scheduler := range serialManager.schedulers {
+		scheduler.Stop()
+		// The scheduler may be waiting to pass on a request
+		go func(lane <-chan Request) {
+			for request := range lane {
+				serialManager.dropRequest(request)
+			}
+		}(serialManager.lanes[lane])
+	}
+}
+
+// The indices of the cases run selects from. The cases of the lanes follow in the order of the lanes.
+const (
+	runStopCase = iota
+	runReconnectCase
+	runLaneCases
+)
+
+// run handles the requests until the manager is stopped.
+// It always handles the requests of the highest priority class that are ready first.
+func (serialManager *serialManager) run() {
+	var lanes [priorities]<-chan Request
+	for lane, channel := range serialManager.lanes {
+		lanes[lane] = channel
+	}
+	// held are the requests taken from each lane but not handled yet
+	var held [priorities]Request
+	var holding [priorities]bool
+	cases := make([]reflect.SelectCase, runLaneCases+priorities)
+	for i := range cases {
+		cases[i].Dir = reflect.SelectRecv
+	}
+	cases[runStopCase].Chan = reflect.ValueOf(serialManager.stop)
+
+	for {
+		select {
+		case stopResult := <-serialManager.stop:
+			serialManager.shutdown(stopResult, held, holding)
+			return
+		default:
+		}
+		// Queued requests are left in the lanes while the port is disconnected
+		accepting := serialManager.reconnectTimer == nil || !serialManager.reconnect.queuesPending()
+		if accepting {
+			takeReadyRequests(lanes[:], held[:], holding[:])
+			if lane := slices.Index(holding[:], true); lane >= 0 {
+				request := held[lane]
+				held[lane], holding[lane] = Request{}, false
+				serialManager.queued.Add(-1)
+				serialManager.handleRequest(request)
+				continue
 			}
 		}
-	}()
-	return nil
+
+		cases[runReconnectCase].Chan = reflect.Value{}
+		if serialManager.reconnectTimer != nil {
+			cases[runReconnectCase].Chan = reflect.ValueOf(serialManager.reconnectTimer.C)
+		}
+		for lane, channel := range lanes {
+			cases[runLaneCases+lane].Chan = reflect.Value{}
+			if accepting && channel != nil {
+				cases[runLaneCases+lane].Chan = reflect.ValueOf(channel)
+			}
+		}
+		chosen, value, ok := reflect.Select(cases)
+		switch {
+		case chosen == runStopCase:
+			serialManager.shutdown(value.Interface().(chan<- error), held, holding)
+			return
+		case chosen == runReconnectCase:
+			serialManager.reopen()
+		case !ok:
+			// All sources of the lane have been closed
+			lanes[chosen-runLaneCases] = nil
+		default:
+			// Handled once the lanes of higher classes have been checked again,
+			// as their requests may have become ready at the same time
+			held[chosen-runLaneCases], holding[chosen-runLaneCases] = value.Interface().(Request), true
+		}
+	}
+}
+
+// takeReadyRequests takes the requests that are ready from the lanes not holding a request yet
+// without waiting for them. Closed lanes are set to nil.
+func takeReadyRequests(lanes []<-chan Request, held []Request, holding []bool) {
+	for lane, channel := range lanes {
+		if holding[lane] || channel == nil {
+			continue
+		}
+		select {
+		case request, ok := <-channel:
+			if !ok {
+				lanes[lane] = nil
+				continue
+			}
+			held[lane], holding[lane] = request, true
+		default:
+		}
+	}
+}
+
+// shutdown closes the port, reports the result to the given channel and drops the requests not handled yet
+func (serialManager *serialManager) shutdown(stopResult chan<- error, held [priorities]Request, holding [priorities]bool) {
+	stopResult <- serialManager.close()
+	for lane, request := range held {
+		if holding[lane] {
+			serialManager.dropRequest(request)
+		}
+	}
+	serialManager.stopScheduling()
+}
+
+// handleRequest sends the given request, retrying it according to the retry policy,
+// and writes the response to its ResponseChannel unless the context of the request is done
+func (serialManager *serialManager) handleRequest(request Request) {
+	if request.ResponseChannel == nil {
+		return
+	}
+	defer close(request.ResponseChannel)
+
+	ctx := request.context()
+	if ctx.Err() != nil {
+		log.WithField("frame", request.Data).Debug("Skipping cancelled request")
+		return
+	}
+	if request.Data == nil {
+		return
+	}
+
+	var response Response
+	if serialManager.reconnectTimer != nil {
+		response = Response{Err: merry.Prependf(BusDisconnectedError, "Can't send request to port %s", serialManager.port.Name)}
+	} else {
+		response = serialManager.sendWithRetries(ctx, request.Data)
+		if serialManager.reconnect.Enabled && errors.Is(response.Err, PortFailureError) {
+			serialManager.disconnect(response.Err)
+		}
+	}
+	serialManager.statistics.recordRequest(response.Err)
+	select {
+	case request.ResponseChannel <- response:
+	case <-ctx.Done():
+	}
+}
+
+// sendWithRetries sends the given request until it succeeds or the retry policy does not allow another attempt.
+// It returns the result of the last attempt.
+func (serialManager *serialManager) sendWithRetries(ctx context.Context, data encoding.Frame) Response {
+	for attempt := 1; ; attempt++ {
+		if err := serialManager.waitForQuietBus(ctx); err != nil {
+			return Response{Err: err, Attempts: attempt - 1}
+		}
+		start := time.Now()
+		response, err := serialManager.serial.SendRequest(ctx, data)
+		latency := time.Since(start)
+		serialManager.markBusActivity(err)
+		serialManager.statistics.recordAttempt(err, latency)
+		if err == nil || !serialManager.retry.shouldRetry(data, err, attempt) {
+			return Response{Response: response, Err: err, Attempts: attempt, Latency: latency}
+		}
+		if serialManager.reconnect.Enabled && errors.Is(err, PortFailureError) {
+			// Retrying is pointless until the port has been reopened
+			return Response{Response: response, Err: err, Attempts: attempt, Latency: latency}
+		}
+
+		delay := serialManager.retry.delay(attempt)
+		log.WithField("frame", data).WithField("attempt", attempt).WithField("delay", delay).WithError(err).Debug("Retrying failed request")
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Response{Err: merry.Prepend(ctx.Err(), "Request cancelled while waiting for a retry"), Attempts: attempt}
+		}
+	}
+}
+
+// waitForQuietBus waits until the inter-frame gap and turnaround delay after the last frame have passed.
+// Only the goroutine of this manager waits, so other buses are not affected.
+func (serialManager *serialManager) waitForQuietBus(ctx context.Context) error {
+	wait := time.Until(serialManager.quietUntil)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return merry.Prepend(ctx.Err(), "Request cancelled while waiting for a quiet bus")
+	}
+}
+
+// markBusActivity records the end of the last frame on the bus after sending a request failed with the given error.
+// The turnaround delay only applies if the device has sent something.
+func (serialManager *serialManager) markBusActivity(err error) {
+	if errors.Is(err, RequestNotSentError) {
+		return
+	}
+	quiet := serialManager.port.interFrameGap()
+	responded := err == nil || errors.Is(err, encoding.FunctionRejectedError) ||
+		errors.Is(err, ResponseMismatchError) || errors.Is(err, InvalidResponseError)
+	if turnaround := serialManager.port.turnaround(); responded && turnaround > quiet {
+		quiet = turnaround
+	}
+	serialManager.quietUntil = time.Now().Add(quiet)
+}
+
+// disconnect closes the failed port and schedules reopening it
+func (serialManager *serialManager) disconnect(cause error) {
+	log.WithField("port", serialManager.port.Name).WithError(cause).Warn("Serial port failed. Reopening it")
+	if err := serialManager.serial.Close(); err != nil {
+		log.WithField("port", serialManager.port.Name).WithError(err).Debug("Failed to close failed serial port")
+	}
+	serialManager.setState(Disconnected, cause)
+	serialManager.reconnectAttempts = 0
+	serialManager.scheduleReopen()
+}
+
+// scheduleReopen starts the timer for the next attempt to reopen the port
+func (serialManager *serialManager) scheduleReopen() {
+	serialManager.reconnectAttempts++
+	serialManager.reconnectTimer = time.NewTimer(serialManager.reconnect.delay(serialManager.reconnectAttempts))
+}
+
+// reopen tries to reopen the failed port and schedules another attempt if it fails
+func (serialManager *serialManager) reopen() {
+	err := serialManager.serial.Open(serialManager.reopenOptions)
+	if err != nil {
+		log.WithField("port", serialManager.reopenOptions.Name).WithField("attempt", serialManager.reconnectAttempts).WithError(err).Info("Failed to reopen serial port")
+		serialManager.scheduleReopen()
+		return
+	}
+	log.WithField("port", serialManager.reopenOptions.Name).WithField("attempts", serialManager.reconnectAttempts).Info("Reopened serial port")
+	serialManager.reconnectTimer = nil
+	serialManager.setState(Connected, nil)
+}
+
+// close closes the port and stops reopening it
+func (serialManager *serialManager) close() error {
+	if serialManager.reconnectTimer != nil {
+		serialManager.reconnectTimer.Stop()
+		serialManager.reconnectTimer = nil
+	}
+	err := serialManager.serial.Close()
+	serialManager.setState(Closed, nil)
+	return err
+}
+
+// setState changes the state of the connection and sends a StateEvent
+func (serialManager *serialManager) setState(state ConnectionState, cause error) {
+	serialManager.state.Store(state)
+	if serialManager.stateEvents == nil {
+		return
+	}
+	event := StateEvent{Port: serialManager.port.Name, State: state, Err: cause, Time: time.Now()}
+	select {
+	case serialManager.stateEvents <- event:
+	default:
+		log.WithField("port", event.Port).WithField("state", state).Warn("Dropping connection state event")
+	}
+}
+
+// State returns the current state of the connection to the port
+func (serialManager *serialManager) State() ConnectionState {
+	return serialManager.state.Load().(ConnectionState)
+}
+
+// Statistics returns a snapshot of the statistics of the bus since the manager has been created
+func (serialManager *serialManager) Statistics() Statistics {
+	statistics := serialManager.statistics.snapshot()
+	statistics.State = serialManager.State()
+	statistics.QueueDepth = len(serialManager.requests) + int(serialManager.queued.Load())
+	statistics.Correlation = serialManager.serial.CorrelationCounters()
+	return statistics
 }
 
 func (serialManager *serialManager) Stop() error {
-	log.Debug("Stopping serial manager for ", serialManager.port)
+	log.Debug("Stopping serial manager for ", serialManager.port.Name)
 	stopResult := make(chan error)
 	serialManager.stop <- stopResult
 	close(serialManager.stop)
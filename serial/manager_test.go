@@ -1,10 +1,14 @@
 package serial
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ansel1/merry/v2"
 	"github.com/shoenig/test"
 	"github.com/shoenig/test/must"
 	"github.com/ventcon/ventcon-hwio/encoding"
@@ -18,7 +22,7 @@ type testSerial struct {
 	failOnClose bool
 }
 
-func (s *testSerial) Open(portName string) error {
+func (s *testSerial) Open(options PortOptions) error {
 	if s.failOnOpen {
 		return fmt.Errorf("Some opening failure")
 	}
@@ -32,7 +36,7 @@ func (s *testSerial) Close() error {
 	s.wasClosed = true
 	return nil
 }
-func (s *testSerial) SendRequest(data encoding.Frame) (encoding.Frame, error) {
+func (s *testSerial) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
 	if data.FrameType() != encoding.ReadRequest {
 		return nil, fmt.Errorf("Some sending failure")
 	}
@@ -42,10 +46,13 @@ func (s *testSerial) SendRequest(data encoding.Frame) (encoding.Frame, error) {
 	s.frames = append(s.frames, data)
 	return data, nil
 }
-func (s *testSerial) markAsValidSerial() {}
+func (s *testSerial) SetValidator(validator encoding.FrameValidator) {}
+func (s *testSerial) SetObserver(observer Observer)                  {}
+func (s *testSerial) CorrelationCounters() CorrelationCounters       { return CorrelationCounters{} }
+func (s *testSerial) markAsValidSerial()                             {}
 
 func TestNewSerialManager(t *testing.T) {
-	managerInterface, requstChan, err := NewSerialManager("testPort")
+	managerInterface, requstChan, err := NewSerialManager(DefaultPortOptions("testPort"))
 
 	must.NoError(t, err)
 
@@ -54,7 +61,7 @@ func TestNewSerialManager(t *testing.T) {
 		t.Error("Returned serial manager interface is not a serial manager struct")
 	}
 
-	test.Eq(t, "testPort", managerStruct.port)
+	test.Eq(t, DefaultPortOptions("testPort"), managerStruct.port)
 	test.NotNil(t, managerStruct.serial)
 	test.NotNil(t, managerStruct.requests)
 	test.NotNil(t, managerStruct.stop)
@@ -77,8 +84,14 @@ func TestNewSerialManager(t *testing.T) {
 	close(managerStruct.stop)
 }
 
+func TestNewSerialManagerInvalidOptions(t *testing.T) {
+	_, _, err := NewSerialManager(PortOptions{Name: "testPort"})
+
+	test.ErrorContains(t, err, "baud rate of port testPort must be positive")
+}
+
 func setupTestSerialManager(t *testing.T) (*serialManager, chan<- Request, *testSerial) {
-	managerInterface, requestChannel, err := NewSerialManager("testPort")
+	managerInterface, requestChannel, err := NewSerialManager(DefaultPortOptions("testPort"))
 	must.NoError(t, err)
 
 	managerStruct, ok := managerInterface.(*serialManager)
@@ -260,3 +273,453 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+type rejectingTestSerial struct {
+	testSerial
+}
+
+func (s *rejectingTestSerial) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	return nil, merry.Prepend(&encoding.FunctionRejection{Address: data.Address(), RequestType: data.FrameType()}, "Failed to read response frame")
+}
+
+func TestRunFunctionRejected(t *testing.T) {
+	serialManager, requestChannel, _ := setupTestSerialManager(t)
+	serialManager.serial = &rejectingTestSerial{}
+
+	err := serialManager.Start()
+	must.NoError(t, err)
+
+	request := mkTestRequest(t, 1, false, true, true)
+	requestChannel <- request.request
+	response := <-request.responseChannel
+
+	test.ErrorIs(t, response.Err, encoding.FunctionRejectedError)
+	var rejection *encoding.FunctionRejection
+	must.True(t, errors.As(response.Err, &rejection))
+	test.EqOp(t, 1, rejection.Address)
+	test.EqOp(t, encoding.ReadRequest, rejection.RequestType)
+
+	err = serialManager.Stop()
+	must.NoError(t, err)
+}
+
+func TestRunSkipsCancelledRequest(t *testing.T) {
+	serialManager, requestChannel, serial := setupTestSerialManager(t)
+
+	err := serialManager.Start()
+	must.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := mkTestRequest(t, 1, false, true, true)
+	request.request.Context = ctx
+	requestChannel <- request.request
+
+	_, ok := <-request.responseChannel
+	test.False(t, ok)
+	test.SliceEmpty(t, serial.frames)
+
+	err = serialManager.Stop()
+	must.NoError(t, err)
+}
+
+// blockingTestSerial blocks in SendRequest until the context is done
+type blockingTestSerial struct {
+	testSerial
+}
+
+func (s *blockingTestSerial) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	if data.Address() != 1 {
+		return s.testSerial.SendRequest(ctx, data)
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRunStopsWaitingAfterDeadline(t *testing.T) {
+	serialManager, requestChannel, _ := setupTestSerialManager(t)
+	serial := &blockingTestSerial{}
+	serialManager.serial = serial
+
+	err := serialManager.Start()
+	must.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	blocked := mkTestRequest(t, 1, false, true, true)
+	blocked.request.Context = ctx
+	// Nobody reads the response of the blocked request
+	requestChannel <- blocked.request
+
+	next := mkTestRequest(t, 2, false, true, true)
+	requestChannel <- next.request
+	response := <-next.responseChannel
+	must.NoError(t, response.Err)
+	test.Eq(t, next.request.Data, response.Response)
+
+	_, ok := <-blocked.responseChannel
+	test.False(t, ok)
+
+	err = serialManager.Stop()
+	must.NoError(t, err)
+}
+
+// flakyTestSerial fails the first failures requests with the given error
+type flakyTestSerial struct {
+	testSerial
+	failures int
+	err      error
+	attempts int
+}
+
+func (s *flakyTestSerial) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	s.attempts++
+	if s.attempts <= s.failures {
+		return nil, s.err
+	}
+	return data, nil
+}
+
+func setupRetryingTestSerialManager(t *testing.T, serial Serial) (*serialManager, chan<- Request) {
+	managerInterface, requestChannel, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:  DefaultPortOptions("testPort"),
+		Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryOn: RetryOnAll, WriteRetry: WriteRetryUnsent},
+	})
+	must.NoError(t, err)
+	managerStruct := managerInterface.(*serialManager)
+	managerStruct.serial = serial
+	must.NoError(t, managerStruct.Start())
+	return managerStruct, requestChannel
+}
+
+func TestNewSerialManagerWithOptionsInvalidRetryPolicy(t *testing.T) {
+	_, _, err := NewSerialManagerWithOptions(ManagerOptions{Port: DefaultPortOptions("testPort"), Retry: RetryPolicy{MaxAttempts: -1}})
+
+	test.ErrorContains(t, err, "Invalid retry policy of port testPort")
+}
+
+func TestNewSerialManagerWithOptionsInvalidDialect(t *testing.T) {
+	_, _, err := NewSerialManagerWithOptions(ManagerOptions{Port: DefaultPortOptions("testPort"), Dialect: encoding.Dialect{Name: "broken"}})
+
+	test.ErrorContains(t, err, "Invalid dialect of port testPort")
+}
+
+func TestRunSpeaksDialect(t *testing.T) {
+	dialect := encoding.DefaultDialect
+	dialect.Name = "braces"
+	dialect.StartChar, dialect.EndChar = '{', '}'
+	host, device := NewPipe()
+	defer device.Close()
+	observer := &recordingObserver{}
+	options := DefaultPortOptions("testPort")
+	options.ResponseTimeout = 10 * time.Millisecond
+	serialManager, requestChannel, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:      options,
+		Dialect:   dialect,
+		Observer:  observer,
+		Transport: pipeOpener(host),
+	})
+	must.NoError(t, err)
+	must.NoError(t, serialManager.Start())
+	defer serialManager.Stop()
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+	responses := make(chan Response, 1)
+	requestChannel <- Request{Data: req, ResponseChannel: responses}
+	test.ErrorIs(t, (<-responses).Err, NoDataOnSerialError)
+	must.SliceNotEmpty(t, observer.events)
+	test.EqOp(t, "{111lW222}", observer.events[0].Raw)
+}
+
+func TestRunReportsSingleAttempt(t *testing.T) {
+	serialManager, requestChannel, _ := setupTestSerialManager(t)
+	must.NoError(t, serialManager.Start())
+
+	request := mkTestRequest(t, 1, false, true, true)
+	requestChannel <- request.request
+	response := <-request.responseChannel
+
+	must.NoError(t, response.Err)
+	test.EqOp(t, 1, response.Attempts)
+
+	must.NoError(t, serialManager.Stop())
+}
+
+func TestRunRetries(t *testing.T) {
+	noData := merry.Prepend(NoDataOnSerialError, "Failed to read response frame")
+	notSent := merry.Prepend(fmt.Errorf("Some Write failure"), "Failed to write request frame", merry.WithCause(RequestNotSentError))
+	rejected := merry.Prepend(&encoding.FunctionRejection{Address: 1, RequestType: encoding.ReadRequest}, "Failed to read response frame")
+
+	testCases := []struct {
+		name             string
+		isWrite          bool
+		failures         int
+		err              error
+		expectedAttempts int
+		expectErr        bool
+	}{
+		{"succeeds after retry", false, 1, noData, 2, false},
+		{"succeeds in last attempt", false, 2, noData, 3, false},
+		{"gives up", false, 5, noData, 3, true},
+		{"rejection", false, 5, rejected, 1, true},
+		{"write not sent", true, 1, notSent, 2, false},
+		{"write without response", true, 1, noData, 1, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serial := &flakyTestSerial{failures: tc.failures, err: tc.err}
+			serialManager, requestChannel := setupRetryingTestSerialManager(t, serial)
+
+			request := mkTestRequest(t, 1, tc.isWrite, true, true)
+			requestChannel <- request.request
+			response := <-request.responseChannel
+
+			test.EqOp(t, tc.expectedAttempts, response.Attempts)
+			test.EqOp(t, tc.expectedAttempts, serial.attempts)
+			if tc.expectErr {
+				test.ErrorIs(t, response.Err, tc.err)
+			} else {
+				must.NoError(t, response.Err)
+				test.Eq(t, request.request.Data, response.Response)
+			}
+
+			must.NoError(t, serialManager.Stop())
+		})
+	}
+}
+
+func TestRunStopsRetryingAfterDeadline(t *testing.T) {
+	serial := &flakyTestSerial{failures: 5, err: merry.Prepend(NoDataOnSerialError, "Failed to read response frame")}
+	managerInterface, requestChannel, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:  DefaultPortOptions("testPort"),
+		Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Hour, RetryOn: RetryOnAll},
+	})
+	must.NoError(t, err)
+	serialManager := managerInterface.(*serialManager)
+	serialManager.serial = serial
+	must.NoError(t, serialManager.Start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request := mkTestRequest(t, 1, false, true, true)
+	request.request.Context = ctx
+	requestChannel <- request.request
+
+	// The response may or may not be written once the deadline has passed
+	response, ok := <-request.responseChannel
+	if ok {
+		test.ErrorIs(t, response.Err, context.DeadlineExceeded)
+		test.EqOp(t, 1, response.Attempts)
+	}
+	test.EqOp(t, 1, serial.attempts)
+
+	must.NoError(t, serialManager.Stop())
+}
+
+// unpluggedTestSerial fails with a port failure until it has been reopened after openFailures failed attempts
+type unpluggedTestSerial struct {
+	testSerial
+	mutex        sync.Mutex
+	unplugged    bool
+	openFailures int
+	opens        int
+}
+
+func (s *unpluggedTestSerial) Open(options PortOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.opens++
+	if s.unplugged && s.openFailures > 0 {
+		s.openFailures--
+		return fmt.Errorf("Some opening failure")
+	}
+	s.unplugged = false
+	return s.testSerial.Open(options)
+}
+
+func (s *unpluggedTestSerial) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.unplugged {
+		return nil, merry.Prepend(&portFailure{err: fmt.Errorf("Some I/O failure"), write: true}, "Failed to write request frame", merry.WithCause(RequestNotSentError))
+	}
+	return s.testSerial.SendRequest(ctx, data)
+}
+
+func (s *unpluggedTestSerial) unplug() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unplugged = true
+}
+
+func setupReconnectingTestSerialManager(t *testing.T, serial Serial, pending PendingPolicy) (*serialManager, chan<- Request, <-chan StateEvent) {
+	events := make(chan StateEvent, 10)
+	managerInterface, requestChannel, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:        DefaultPortOptions("testPort"),
+		Retry:       RetryPolicy{MaxAttempts: 3, RetryOn: RetryOnAll, WriteRetry: WriteRetryUnsent},
+		Reconnect:   ReconnectPolicy{Enabled: true, Backoff: 5 * time.Millisecond, MaxBackoff: 5 * time.Millisecond, Pending: pending},
+		StateEvents: events,
+	})
+	must.NoError(t, err)
+	managerStruct := managerInterface.(*serialManager)
+	managerStruct.serial = serial
+	test.EqOp(t, Closed, managerStruct.State())
+	must.NoError(t, managerStruct.Start())
+	test.EqOp(t, Connected, (<-events).State)
+	return managerStruct, requestChannel, events
+}
+
+func TestNewSerialManagerWithOptionsInvalidReconnectPolicy(t *testing.T) {
+	_, _, err := NewSerialManagerWithOptions(ManagerOptions{Port: DefaultPortOptions("testPort"), Reconnect: ReconnectPolicy{Pending: "drop"}})
+
+	test.ErrorContains(t, err, "Invalid reconnect policy of port testPort")
+}
+
+func TestRunReconnectsAfterPortFailure(t *testing.T) {
+	serial := &unpluggedTestSerial{openFailures: 2}
+	serialManager, requestChannel, events := setupReconnectingTestSerialManager(t, serial, PendingQueue)
+
+	serial.unplug()
+	failing := mkTestRequest(t, 1, false, true, true)
+	requestChannel <- failing.request
+	response := <-failing.responseChannel
+	test.ErrorIs(t, response.Err, PortFailureError)
+	// The port failure is not retried
+	test.EqOp(t, 1, response.Attempts)
+
+	event := <-events
+	test.EqOp(t, Disconnected, event.State)
+	test.EqOp(t, "testPort", event.Port)
+	test.ErrorIs(t, event.Err, PortFailureError)
+	test.True(t, serial.wasClosed)
+
+	// The request is queued until the port has been reopened
+	queued := mkTestRequest(t, 2, false, true, true)
+	requestChannel <- queued.request
+	response = <-queued.responseChannel
+	must.NoError(t, response.Err)
+	test.Eq(t, queued.request.Data, response.Response)
+
+	test.EqOp(t, Connected, (<-events).State)
+	test.EqOp(t, Connected, serialManager.State())
+	test.EqOp(t, 4, serial.opens)
+
+	must.NoError(t, serialManager.Stop())
+	test.EqOp(t, Closed, (<-events).State)
+	test.EqOp(t, Closed, serialManager.State())
+}
+
+func TestRunFailsPendingRequestsWhileDisconnected(t *testing.T) {
+	serial := &unpluggedTestSerial{openFailures: 1000}
+	serialManager, requestChannel, events := setupReconnectingTestSerialManager(t, serial, PendingFail)
+
+	serial.unplug()
+	failing := mkTestRequest(t, 1, false, true, true)
+	requestChannel <- failing.request
+	response := <-failing.responseChannel
+	test.ErrorIs(t, response.Err, PortFailureError)
+	test.EqOp(t, Disconnected, (<-events).State)
+
+	pending := mkTestRequest(t, 2, false, true, true)
+	requestChannel <- pending.request
+	response = <-pending.responseChannel
+	test.ErrorIs(t, response.Err, BusDisconnectedError)
+	test.EqOp(t, 0, response.Attempts)
+	test.EqOp(t, Disconnected, serialManager.State())
+
+	must.NoError(t, serialManager.Stop())
+	test.EqOp(t, Closed, serialManager.State())
+}
+
+func TestRunWithoutReconnectKeepsFailedPort(t *testing.T) {
+	serialManager, requestChannel, _ := setupTestSerialManager(t)
+	serial := &unpluggedTestSerial{}
+	serialManager.serial = serial
+	must.NoError(t, serialManager.Start())
+
+	serial.unplug()
+	for i := 1; i <= 2; i++ {
+		request := mkTestRequest(t, i, false, true, true)
+		requestChannel <- request.request
+		response := <-request.responseChannel
+		test.ErrorIs(t, response.Err, PortFailureError)
+	}
+	test.EqOp(t, 1, serial.opens)
+	test.EqOp(t, Connected, serialManager.State())
+
+	must.NoError(t, serialManager.Stop())
+}
+
+// timingTestSerial records the times requests are sent and fails requests to address 2 without a response
+type timingTestSerial struct {
+	testSerial
+	times []time.Time
+}
+
+func (s *timingTestSerial) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	s.times = append(s.times, time.Now())
+	if data.Address() == 2 {
+		return nil, merry.Prepend(NoDataOnSerialError, "Failed to read response frame")
+	}
+	return s.testSerial.SendRequest(ctx, data)
+}
+
+func TestRunEnforcesBusTimings(t *testing.T) {
+	port := DefaultPortOptions("testPort")
+	port.InterFrameGap = 10 * time.Millisecond
+	port.Turnaround = 30 * time.Millisecond
+	managerInterface, requestChannel, err := NewSerialManagerWithOptions(ManagerOptions{Port: port})
+	must.NoError(t, err)
+	serialManager := managerInterface.(*serialManager)
+	serial := &timingTestSerial{}
+	serialManager.serial = serial
+	must.NoError(t, serialManager.Start())
+
+	// Address 1 responds, address 2 does not
+	for _, address := range []int{1, 2, 1} {
+		request := mkTestRequest(t, address, false, true, true)
+		requestChannel <- request.request
+		<-request.responseChannel
+	}
+
+	must.Len(t, 3, serial.times)
+	// The turnaround delay follows the response
+	test.GreaterEq(t, port.Turnaround, serial.times[1].Sub(serial.times[0]))
+	// Only the inter-frame gap follows a request without a response
+	gap := serial.times[2].Sub(serial.times[1])
+	test.GreaterEq(t, port.InterFrameGap, gap)
+	test.Less(t, port.Turnaround, gap)
+
+	must.NoError(t, serialManager.Stop())
+}
+
+func TestRunStopsWaitingForQuietBusAfterDeadline(t *testing.T) {
+	port := DefaultPortOptions("testPort")
+	port.Turnaround = time.Hour
+	managerInterface, requestChannel, err := NewSerialManagerWithOptions(ManagerOptions{Port: port})
+	must.NoError(t, err)
+	serialManager := managerInterface.(*serialManager)
+	serial := &timingTestSerial{}
+	serialManager.serial = serial
+	must.NoError(t, serialManager.Start())
+
+	first := mkTestRequest(t, 1, false, true, true)
+	requestChannel <- first.request
+	<-first.responseChannel
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	second := mkTestRequest(t, 1, false, true, true)
+	second.request.Context = ctx
+	requestChannel <- second.request
+	response, ok := <-second.responseChannel
+	if ok {
+		test.ErrorIs(t, response.Err, context.DeadlineExceeded)
+		test.EqOp(t, 0, response.Attempts)
+	}
+	test.Len(t, 1, serial.times)
+
+	must.NoError(t, serialManager.Stop())
+}
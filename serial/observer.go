@@ -0,0 +1,87 @@
+package serial
+
+import (
+	"time"
+
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// TrafficKind is the kind of a TrafficEvent
+type TrafficKind string
+
+const (
+	// FrameSent is the TrafficKind of a frame written to the port
+	FrameSent TrafficKind = "frameSent"
+	// RawReceived is the TrafficKind of the raw data of a frame read from the port before decoding it
+	// (including local echoes)
+	RawReceived TrafficKind = "rawReceived"
+	// FrameReceived is the TrafficKind of a frame decoded from the data read from the port
+	FrameReceived TrafficKind = "frameReceived"
+	// TrafficError is the TrafficKind of an error while sending a request or receiving its response
+	TrafficError TrafficKind = "error"
+)
+
+// TrafficEvent describes a single event of the traffic on a bus
+type TrafficEvent struct {
+	// Port is the name of the port
+	Port string
+	// Kind is the kind of the event
+	Kind TrafficKind
+	// Time is the time of the event.
+	// It contains a monotonic clock reading, so the time between events can be computed using Sub.
+	Time time.Time
+	// Frame is the frame sent (FrameSent), the frame received (FrameReceived)
+	// or the request that failed (TrafficError)
+	Frame encoding.Frame
+	// Raw is the raw data sent (FrameSent) or received (RawReceived) including the start and end character
+	Raw string
+	// Err is the error (TrafficError)
+	Err error
+}
+
+// Observer receives the traffic of a bus (e.g. to record it or derive metrics from it).
+// Observe is called by the goroutine sending the requests, so it must return quickly.
+// Nothing is done for the events if there is no observer.
+type Observer interface {
+	Observe(event TrafficEvent)
+}
+
+// ObserverFunc is an Observer calling the function
+type ObserverFunc func(event TrafficEvent)
+
+// Observe calls the function with the given event
+func (observer ObserverFunc) Observe(event TrafficEvent) {
+	observer(event)
+}
+
+// Observers returns an Observer passing the events to all given observers in order.
+// Nil observers are skipped.
+func Observers(observers ...Observer) Observer {
+	var nonNil multiObserver
+	for _, observer := range observers {
+		if observer != nil {
+			nonNil = append(nonNil, observer)
+		}
+	}
+	return nonNil
+}
+
+// multiObserver passes the events to all its observers
+type multiObserver []Observer
+
+func (observers multiObserver) Observe(event TrafficEvent) {
+	for _, observer := range observers {
+		observer.Observe(event)
+	}
+}
+
+// portObserver sets the port name of all events before passing them on
+type portObserver struct {
+	port     string
+	observer Observer
+}
+
+func (observer portObserver) Observe(event TrafficEvent) {
+	event.Port = observer.port
+	observer.observer.Observe(event)
+}
@@ -0,0 +1,157 @@
+package serial
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// recordingObserver records all events
+type recordingObserver struct {
+	mutex  sync.Mutex
+	events []TrafficEvent
+}
+
+func (observer *recordingObserver) Observe(event TrafficEvent) {
+	observer.mutex.Lock()
+	defer observer.mutex.Unlock()
+	observer.events = append(observer.events, event)
+}
+
+func (observer *recordingObserver) kinds() []TrafficKind {
+	observer.mutex.Lock()
+	defer observer.mutex.Unlock()
+	var kinds []TrafficKind
+	for _, event := range observer.events {
+		kinds = append(kinds, event.Kind)
+	}
+	return kinds
+}
+
+func TestObservers(t *testing.T) {
+	first, second := &recordingObserver{}, &recordingObserver{}
+	var order []string
+	observer := Observers(
+		first,
+		nil,
+		ObserverFunc(func(event TrafficEvent) { order = append(order, "func") }),
+		second,
+	)
+
+	observer.Observe(TrafficEvent{Kind: FrameSent})
+	test.Eq(t, []TrafficKind{FrameSent}, first.kinds())
+	test.Eq(t, []TrafficKind{FrameSent}, second.kinds())
+	test.Eq(t, []string{"func"}, order)
+}
+
+func TestSendRequestObserved(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#222333\r")}
+	serial := setupWorkingCommunicator(t, testSp, true)
+	observer := &recordingObserver{}
+	serial.SetObserver(observer)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+
+	events := observer.events
+	test.Eq(t, []TrafficKind{FrameSent, RawReceived, FrameReceived}, observer.kinds())
+	test.Eq(t, req, events[0].Frame)
+	test.EqOp(t, "\n111lW222\r", events[0].Raw)
+	test.EqOp(t, "\n111lW#222333\r", events[1].Raw)
+	test.Eq(t, resp, events[2].Frame)
+	for i, event := range events {
+		test.EqOp(t, PORT_NAME, event.Port)
+		if i > 0 {
+			test.GreaterEq(t, 0, event.Time.Sub(events[i-1].Time))
+		}
+	}
+}
+
+func TestSendRequestObservedErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		readData string
+		expected []TrafficKind
+		err      error
+	}{
+		{"no data", "", []TrafficKind{FrameSent, TrafficError}, NoDataOnSerialError},
+		{"invalid response", "\n111lX#222333\r", []TrafficKind{FrameSent, RawReceived, TrafficError}, InvalidResponseError},
+		{"mismatch", "\n112lW#222333\r\n111lW#222333\r", []TrafficKind{FrameSent, RawReceived, FrameReceived, TrafficError, RawReceived, FrameReceived}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testSp := &testSerialPort{readData: []byte(tc.readData)}
+			serial := setupWorkingCommunicator(t, testSp, false)
+			options := DefaultPortOptions(PORT_NAME)
+			options.ResponseTimeout = 20 * time.Millisecond
+			must.NoError(t, serial.Open(options))
+			observer := &recordingObserver{}
+			serial.SetObserver(observer)
+
+			req, err := encoding.NewReadRequest(111, 222)
+			must.NoError(t, err)
+
+			_, err = serial.SendRequest(context.Background(), req)
+			test.Eq(t, tc.expected, observer.kinds())
+			last := observer.events[len(observer.events)-1]
+			if tc.err != nil {
+				test.ErrorIs(t, err, tc.err)
+				test.ErrorIs(t, last.Err, tc.err)
+				test.Eq(t, req, last.Frame)
+			} else {
+				must.NoError(t, err)
+				test.ErrorIs(t, observer.events[3].Err, ResponseMismatchError)
+			}
+		})
+	}
+}
+
+func TestSendRequestObservedEcho(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#222333\r"), echo: "\n111lW222\r"}
+	options := DefaultPortOptions(PORT_NAME)
+	options.Echo = true
+	serial := setupRS485Communicator(t, testSp, options)
+	observer := &recordingObserver{}
+	serial.SetObserver(observer)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	_, err = serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.Eq(t, []TrafficKind{FrameSent, RawReceived, RawReceived, FrameReceived}, observer.kinds())
+	test.EqOp(t, "\n111lW222\r", observer.events[1].Raw)
+}
+
+func TestRunObserved(t *testing.T) {
+	host, device := NewPipe()
+	simulateDevice(t, device, 333)
+	observer := &recordingObserver{}
+
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:      DefaultPortOptions("simulated"),
+		Observer:  observer,
+		Transport: pipeOpener(host),
+	})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+	responses := make(chan Response, 1)
+	requests <- Request{Data: req, ResponseChannel: responses}
+	must.NoError(t, (<-responses).Err)
+
+	test.Eq(t, []TrafficKind{FrameSent, RawReceived, FrameReceived}, observer.kinds())
+	test.EqOp(t, "simulated", observer.events[0].Port)
+}
@@ -0,0 +1,314 @@
+package serial
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"go.bug.st/serial"
+)
+
+const (
+	// DEFAULT_BAUD_RATE is the baud rate used if none is given
+	DEFAULT_BAUD_RATE = 9600
+	// DEFAULT_DATA_BITS is the number of data bits used if none is given
+	DEFAULT_DATA_BITS = 8
+	// DEFAULT_READ_TIMEOUT is the read timeout used if none is given
+	DEFAULT_READ_TIMEOUT = 20 * time.Millisecond
+	// DEFAULT_RESPONSE_TIMEOUT is the response timeout used if none is given
+	DEFAULT_RESPONSE_TIMEOUT = 100 * time.Millisecond
+	// DEFAULT_INTER_FRAME_GAP_CHARACTERS is the inter-frame gap in character times used if none is given
+	DEFAULT_INTER_FRAME_GAP_CHARACTERS = 3.5
+	// DEFAULT_TURNAROUND_CHARACTERS is the turnaround delay in character times used if none is given
+	DEFAULT_TURNAROUND_CHARACTERS = 10
+)
+
+// parities maps the names used in port specs to the parities
+var parities = map[string]serial.Parity{
+	"none":  serial.NoParity,
+	"odd":   serial.OddParity,
+	"even":  serial.EvenParity,
+	"mark":  serial.MarkParity,
+	"space": serial.SpaceParity,
+}
+
+// stopBits maps the names used in port specs to the stop bits
+var stopBits = map[string]serial.StopBits{
+	"1":   serial.OneStopBit,
+	"1.5": serial.OnePointFiveStopBits,
+	"2":   serial.TwoStopBits,
+}
+
+// PortOptions are the options used to open a serial port
+type PortOptions struct {
+	// Name is the name of the port (e.g. /dev/ttyUSB0 or COM3)
+	// or the address of a remote port (e.g. tcp://ser2net.local:4001 or rfc2217://gateway.local:4001).
+	// Names with the USB_SCHEME identify a port found using Match.
+	Name string
+	// Match selects the port by its USB metadata when it is opened. It requires a name with the USB_SCHEME.
+	Match PortMatcher
+	// BaudRate is the baud rate of the serial line
+	BaudRate int
+	// DataBits is the number of data bits per character (5 to 8)
+	DataBits int
+	// Parity is the parity of the serial line
+	Parity serial.Parity
+	// StopBits is the number of stop bits per character
+	StopBits serial.StopBits
+	// ReadTimeout is the time a single read waits for data
+	ReadTimeout time.Duration
+	// ResponseTimeout is the time a device may take to send its complete response after a request has been written
+	ResponseTimeout time.Duration
+	// Echo strips the local echo of each frame written (e.g. of RS485 adapters receiving their own transmission)
+	Echo bool
+	// RTS asserts RTS while writing a frame (e.g. to drive an RS485 transceiver) and deasserts it otherwise
+	RTS bool
+	// RTSPreDelay is the time between asserting RTS and writing a frame
+	RTSPreDelay time.Duration
+	// RTSPostDelay is the time between the frame having been transmitted and deasserting RTS
+	RTSPostDelay time.Duration
+	// InterFrameGap is the minimum quiet time on the bus before a request is written.
+	// 0 uses DEFAULT_INTER_FRAME_GAP_CHARACTERS character times.
+	InterFrameGap time.Duration
+	// Turnaround is the minimum time between receiving a response and writing the next request.
+	// 0 uses DEFAULT_TURNAROUND_CHARACTERS character times.
+	Turnaround time.Duration
+}
+
+// DefaultPortOptions returns the options for the port with the given name
+// using 9600 baud, even parity, 8 data bits, one stop bit, a read timeout of 20ms and a response timeout of 100ms
+func DefaultPortOptions(name string) PortOptions {
+	return PortOptions{
+		Name:            name,
+		BaudRate:        DEFAULT_BAUD_RATE,
+		DataBits:        DEFAULT_DATA_BITS,
+		Parity:          serial.EvenParity,
+		StopBits:        serial.OneStopBit,
+		ReadTimeout:     DEFAULT_READ_TIMEOUT,
+		ResponseTimeout: DEFAULT_RESPONSE_TIMEOUT,
+	}
+}
+
+// ParsePortOptions parses port options from a spec consisting of the port name
+// optionally followed by line parameters in URL query syntax.
+// Parameters not given are taken from DefaultPortOptions.
+// The parameters are baud, dataBits, parity (none, odd, even, mark or space), stopBits (1, 1.5 or 2)
+// readTimeout (e.g. 50ms), responseTimeout, echo (true or false), rts (true or false), rtsPreDelay, rtsPostDelay,
+// interFrameGap, turnaround, serialNumber and product.
+// Names with the USB_SCHEME set the vendor and product ID of Match, serialNumber and product its other criteria.
+// Examples: /dev/ttyUSB0?baud=19200&parity=none, usb://0403:6001?serialNumber=A10K1Q2X&baud=19200
+func ParsePortOptions(spec string) (PortOptions, error) {
+	name, query, _ := strings.Cut(spec, "?")
+	options := DefaultPortOptions(name)
+	if strings.HasPrefix(name, USB_SCHEME) {
+		options.Match = parseUSBPortName(name)
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return options, merry.Prependf(err, "Failed to parse the parameters of port %s", spec)
+	}
+
+	for key, value := range values {
+		if len(value) != 1 {
+			return options, merry.Errorf("The parameter %s of port %s must be given exactly once", key, spec)
+		}
+		if err := options.set(key, value[0]); err != nil {
+			return options, merry.Prependf(err, "Invalid parameter %s of port %s", key, spec)
+		}
+	}
+
+	if err := options.Validate(); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// set sets the option with the given parameter name to the given value
+func (options *PortOptions) set(key string, value string) error {
+	var err error
+	switch key {
+	case "baud":
+		options.BaudRate, err = strconv.Atoi(value)
+	case "dataBits":
+		options.DataBits, err = strconv.Atoi(value)
+	case "parity":
+		parity, ok := parities[value]
+		if !ok {
+			return merry.Errorf("Unknown parity %s. Known parities are: %v", value, sortedKeys(parities))
+		}
+		options.Parity = parity
+	case "stopBits":
+		stopBit, ok := stopBits[value]
+		if !ok {
+			return merry.Errorf("Unknown stop bits %s. Known stop bits are: %v", value, sortedKeys(stopBits))
+		}
+		options.StopBits = stopBit
+	case "readTimeout":
+		options.ReadTimeout, err = time.ParseDuration(value)
+	case "responseTimeout":
+		options.ResponseTimeout, err = time.ParseDuration(value)
+	case "echo":
+		options.Echo, err = strconv.ParseBool(value)
+	case "rts":
+		options.RTS, err = strconv.ParseBool(value)
+	case "rtsPreDelay":
+		options.RTSPreDelay, err = time.ParseDuration(value)
+	case "rtsPostDelay":
+		options.RTSPostDelay, err = time.ParseDuration(value)
+	case "interFrameGap":
+		options.InterFrameGap, err = time.ParseDuration(value)
+	case "turnaround":
+		options.Turnaround, err = time.ParseDuration(value)
+	case "serialNumber":
+		options.Match.SerialNumber = value
+	case "product":
+		options.Match.Product = value
+	default:
+		return merry.New("Unknown parameter")
+	}
+	return err
+}
+
+// Validate checks whether the options can be used to open a port
+func (options PortOptions) Validate() error {
+	if options.Name == "" {
+		return merry.New("The port must have a name")
+	}
+	if strings.HasPrefix(options.Name, USB_SCHEME) || !options.Match.IsZero() {
+		if !strings.HasPrefix(options.Name, USB_SCHEME) {
+			return merry.Errorf("Matching port %s by its USB metadata requires a name starting with %s", options.Name, USB_SCHEME)
+		}
+		if err := options.Match.Validate(); err != nil {
+			return merry.Prependf(err, "Invalid USB matcher of port %s", options.Name)
+		}
+	}
+	if options.BaudRate <= 0 {
+		return merry.Errorf("The baud rate of port %s must be positive. It was %d", options.Name, options.BaudRate)
+	}
+	if options.DataBits < 5 || options.DataBits > 8 {
+		return merry.Errorf("The data bits of port %s must be between 5 and 8 (inclusive). It was %d", options.Name, options.DataBits)
+	}
+	if _, ok := nameOf(parities, options.Parity); !ok {
+		return merry.Errorf("Unknown parity %d of port %s", options.Parity, options.Name)
+	}
+	if _, ok := nameOf(stopBits, options.StopBits); !ok {
+		return merry.Errorf("Unknown stop bits %d of port %s", options.StopBits, options.Name)
+	}
+	if options.ReadTimeout <= 0 {
+		return merry.Errorf("The read timeout of port %s must be positive. It was %s", options.Name, options.ReadTimeout)
+	}
+	if options.ResponseTimeout <= 0 {
+		return merry.Errorf("The response timeout of port %s must be positive. It was %s", options.Name, options.ResponseTimeout)
+	}
+	if options.RTSPreDelay < 0 || options.RTSPostDelay < 0 {
+		return merry.Errorf("The RTS delays of port %s must not be negative. They were %s and %s", options.Name, options.RTSPreDelay, options.RTSPostDelay)
+	}
+	if !options.RTS && (options.RTSPreDelay != 0 || options.RTSPostDelay != 0) {
+		return merry.Errorf("The RTS delays of port %s require rts to be enabled", options.Name)
+	}
+	if options.InterFrameGap < 0 || options.Turnaround < 0 {
+		return merry.Errorf("The inter-frame gap and turnaround of port %s must not be negative. They were %s and %s", options.Name, options.InterFrameGap, options.Turnaround)
+	}
+	return nil
+}
+
+// String returns the spec of the options as accepted by ParsePortOptions.
+// The RS485 options, the bus timings and the USB matcher are only included if they are set.
+func (options PortOptions) String() string {
+	parity, _ := nameOf(parities, options.Parity)
+	stopBit, _ := nameOf(stopBits, options.StopBits)
+	spec := fmt.Sprintf("%s?baud=%d&dataBits=%d&parity=%s&stopBits=%s&readTimeout=%s&responseTimeout=%s",
+		options.Name, options.BaudRate, options.DataBits, parity, stopBit, options.ReadTimeout, options.ResponseTimeout)
+	if options.Echo {
+		spec += "&echo=true"
+	}
+	if options.RTS {
+		spec += fmt.Sprintf("&rts=true&rtsPreDelay=%s&rtsPostDelay=%s", options.RTSPreDelay, options.RTSPostDelay)
+	}
+	if options.InterFrameGap != 0 {
+		spec += fmt.Sprintf("&interFrameGap=%s", options.InterFrameGap)
+	}
+	if options.Turnaround != 0 {
+		spec += fmt.Sprintf("&turnaround=%s", options.Turnaround)
+	}
+	if options.Match.SerialNumber != "" {
+		spec += "&serialNumber=" + url.QueryEscape(options.Match.SerialNumber)
+	}
+	if options.Match.Product != "" {
+		spec += "&product=" + url.QueryEscape(options.Match.Product)
+	}
+	return spec
+}
+
+// characterTime returns the time it takes to transmit a single character including its start, parity and stop bits
+func (options PortOptions) characterTime() time.Duration {
+	bits := 1 + float64(options.DataBits)
+	if options.Parity != serial.NoParity {
+		bits++
+	}
+	switch options.StopBits {
+	case serial.OnePointFiveStopBits:
+		bits += 1.5
+	case serial.TwoStopBits:
+		bits += 2
+	default:
+		bits++
+	}
+	return time.Duration(bits * float64(time.Second) / float64(options.BaudRate))
+}
+
+// interFrameGap returns the InterFrameGap or the default derived from the baud rate
+func (options PortOptions) interFrameGap() time.Duration {
+	if options.InterFrameGap != 0 {
+		return options.InterFrameGap
+	}
+	return time.Duration(DEFAULT_INTER_FRAME_GAP_CHARACTERS * float64(options.characterTime()))
+}
+
+// turnaround returns the Turnaround or the default derived from the baud rate
+func (options PortOptions) turnaround() time.Duration {
+	if options.Turnaround != 0 {
+		return options.Turnaround
+	}
+	return DEFAULT_TURNAROUND_CHARACTERS * options.characterTime()
+}
+
+// mode returns the serial mode of the options
+func (options PortOptions) mode() *serial.Mode {
+	mode := &serial.Mode{
+		BaudRate: options.BaudRate,
+		Parity:   options.Parity,
+		DataBits: options.DataBits,
+		StopBits: options.StopBits,
+	}
+	if options.RTS {
+		// RTS is only asserted while writing
+		mode.InitialStatusBits = &serial.ModemOutputBits{RTS: false, DTR: true}
+	}
+	return mode
+}
+
+// nameOf returns the name of the given value in the given map
+func nameOf[T comparable](names map[string]T, value T) (string, bool) {
+	for name, candidate := range names {
+		if candidate == value {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// sortedKeys returns the sorted keys of the given map
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,121 @@
+package serial
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"go.bug.st/serial"
+)
+
+func TestDefaultPortOptions(t *testing.T) {
+	options := DefaultPortOptions(PORT_NAME)
+
+	must.NoError(t, options.Validate())
+	test.Eq(t, PortOptions{Name: PORT_NAME, BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond}, options)
+}
+
+func TestParsePortOptionsGood(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		expected PortOptions
+	}{
+		{PORT_NAME, DefaultPortOptions(PORT_NAME)},
+		{"COM3?", DefaultPortOptions("COM3")},
+		{"/dev/ttyUSB0?baud=19200&parity=none", PortOptions{Name: "/dev/ttyUSB0", BaudRate: 19200, DataBits: 8, Parity: serial.NoParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond}},
+		{"/dev/ttyS0?dataBits=7&parity=odd&stopBits=2&readTimeout=1s", PortOptions{Name: "/dev/ttyS0", BaudRate: 9600, DataBits: 7, Parity: serial.OddParity, StopBits: serial.TwoStopBits, ReadTimeout: time.Second, ResponseTimeout: 100 * time.Millisecond}},
+		{"COM3?responseTimeout=250ms", PortOptions{Name: "COM3", BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 250 * time.Millisecond}},
+		{"/dev/ttyUSB0?echo=true&rts=1&rtsPreDelay=1ms&rtsPostDelay=500us", PortOptions{Name: "/dev/ttyUSB0", BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond, Echo: true, RTS: true, RTSPreDelay: time.Millisecond, RTSPostDelay: 500 * time.Microsecond}},
+		{"COM3?interFrameGap=2ms&turnaround=10ms", PortOptions{Name: "COM3", BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond, InterFrameGap: 2 * time.Millisecond, Turnaround: 10 * time.Millisecond}},
+		{"usb://0403:6001?serialNumber=A10K1Q2X&product=FT232R%20USB&baud=19200", PortOptions{Name: "usb://0403:6001", Match: PortMatcher{VID: "0403", PID: "6001", SerialNumber: "A10K1Q2X", Product: "FT232R USB"}, BaudRate: 19200, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond}},
+		{"usb://?serialNumber=A10K1Q2X", PortOptions{Name: "usb://", Match: PortMatcher{SerialNumber: "A10K1Q2X"}, BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond}},
+		{"/dev/ttyS0?stopBits=1.5&parity=mark", PortOptions{Name: "/dev/ttyS0", BaudRate: 9600, DataBits: 8, Parity: serial.MarkParity, StopBits: serial.OnePointFiveStopBits, ReadTimeout: 20 * time.Millisecond, ResponseTimeout: 100 * time.Millisecond}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			options, err := ParsePortOptions(tc.spec)
+			must.NoError(t, err)
+			test.Eq(t, tc.expected, options)
+
+			// The string representation can be parsed again
+			reparsed, err := ParsePortOptions(options.String())
+			must.NoError(t, err)
+			test.Eq(t, options, reparsed)
+		})
+	}
+}
+
+func TestParsePortOptionsBad(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		expected string
+	}{
+		{"", "must have a name"},
+		{"?baud=19200", "must have a name"},
+		{"COM3?baud=fast", "Invalid parameter baud"},
+		{"COM3?baud=0", "baud rate of port COM3 must be positive"},
+		{"COM3?baud=9600&baud=19200", "exactly once"},
+		{"COM3?dataBits=9", "data bits of port COM3 must be between 5 and 8"},
+		{"COM3?parity=foo", "Unknown parity foo"},
+		{"COM3?stopBits=3", "Unknown stop bits 3"},
+		{"COM3?readTimeout=20", "Invalid parameter readTimeout"},
+		{"COM3?readTimeout=0s", "read timeout of port COM3 must be positive"},
+		{"COM3?responseTimeout=0s", "response timeout of port COM3 must be positive"},
+		{"COM3?echo=maybe", "Invalid parameter echo"},
+		{"COM3?rts=true&rtsPreDelay=-1ms", "RTS delays of port COM3 must not be negative"},
+		{"COM3?rtsPostDelay=1ms", "RTS delays of port COM3 require rts to be enabled"},
+		{"COM3?turnaround=-1ms", "inter-frame gap and turnaround of port COM3 must not be negative"},
+		{"usb://", "Invalid USB matcher of port usb://"},
+		{"usb://403:6001", "USB ID 403 must consist of 4 hexadecimal digits"},
+		{"COM3?serialNumber=A10K1Q2X", "requires a name starting with usb://"},
+		{"COM3?foo=bar", "Invalid parameter foo"},
+		{"COM3?baud=%zz", "Failed to parse the parameters"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			_, err := ParsePortOptions(tc.spec)
+			test.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
+
+func TestPortOptionsValidateBad(t *testing.T) {
+	options := DefaultPortOptions(PORT_NAME)
+	options.Parity = serial.Parity(42)
+	test.ErrorContains(t, options.Validate(), "Unknown parity")
+
+	options = DefaultPortOptions(PORT_NAME)
+	options.StopBits = serial.StopBits(42)
+	test.ErrorContains(t, options.Validate(), "Unknown stop bits")
+}
+
+func TestPortOptionsMode(t *testing.T) {
+	options := DefaultPortOptions(PORT_NAME)
+	test.Eq(t, &serial.Mode{BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity, StopBits: serial.OneStopBit}, options.mode())
+
+	// RTS is deasserted until a frame is written
+	options.RTS = true
+	test.Eq(t, &serial.ModemOutputBits{RTS: false, DTR: true}, options.mode().InitialStatusBits)
+}
+
+func TestPortOptionsBusTimings(t *testing.T) {
+	// 9600 baud with 8 data bits, even parity and one stop bit: 11 bits per character
+	options := DefaultPortOptions(PORT_NAME)
+	characterTime := 11 * time.Second / 9600
+	test.EqOp(t, characterTime, options.characterTime())
+	test.EqOp(t, time.Duration(3.5*float64(characterTime)), options.interFrameGap())
+	test.EqOp(t, 10*characterTime, options.turnaround())
+
+	// 19200 baud with 7 data bits, no parity and two stop bits: 10 bits per character
+	options, err := ParsePortOptions("COM3?baud=19200&dataBits=7&parity=none&stopBits=2")
+	must.NoError(t, err)
+	test.EqOp(t, 10*time.Second/19200, options.characterTime())
+
+	options.InterFrameGap = time.Millisecond
+	options.Turnaround = 20 * time.Millisecond
+	test.EqOp(t, time.Millisecond, options.interFrameGap())
+	test.EqOp(t, 20*time.Millisecond, options.turnaround())
+}
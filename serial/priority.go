@@ -0,0 +1,132 @@
+package serial
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Priority is the class of a request. The manager always sends the requests of a higher class first.
+type Priority int
+
+const (
+	// Background is the class of requests nobody is waiting for (e.g. polling the state of the devices)
+	Background Priority = iota - 1
+	// Control is the class of requests sent automatically to control the devices. It is the zero value.
+	Control
+	// Interactive is the class of requests a user is waiting for (e.g. boosting a ventilator)
+	Interactive
+)
+
+// priorities is the number of priority classes
+const priorities = int(Interactive-Background) + 1
+
+// maxPendingRequests is the number of requests taken from a source ahead of the ones passed on to the lanes.
+// It allows sorting a request of each class ahead without queueing the requests outside of the source.
+const maxPendingRequests = priorities
+
+func (priority Priority) String() string {
+	switch priority {
+	case Background:
+		return "background"
+	case Control:
+		return "control"
+	case Interactive:
+		return "interactive"
+	}
+	return fmt.Sprintf("Priority(%d)", int(priority))
+}
+
+// lane returns the index of the lane of the priority. The lane of the highest priority is 0.
+// Priorities above Interactive or below Background use the lane of the nearest class.
+func (priority Priority) lane() int {
+	return int(Interactive - min(max(priority, Background), Interactive))
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context carrying the given priority (see PriorityFromContext)
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority carried by the given context or Control if it carries none.
+// It allows passing the priority of requests through APIs that do not know about it (e.g. device.Ventilator).
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+	return Control
+}
+
+// The indices of the cases splitRequests selects from. The cases of the lanes follow in the order of the lanes.
+const (
+	splitDoneCase = iota
+	splitSourceCase
+	splitLaneCases
+)
+
+// splitRequests takes the requests of the given source and passes them on to the lane of their priority
+// until the source is closed or the manager stops. Up to maxPendingRequests requests are taken ahead
+// of the ones passed on, so requests of a higher class do not wait behind requests of a lower one.
+// Further requests are left in the source until the lanes take the requests taken already.
+func (serialManager *serialManager) splitRequests(source <-chan Request, lanes [priorities]chan Request) {
+	var pending [priorities][]Request
+	pendingCount := 0
+	cases := make([]reflect.SelectCase, splitLaneCases+priorities)
+	cases[splitDoneCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(serialManager.done)}
+	cases[splitSourceCase].Dir = reflect.SelectRecv
+	for {
+		if source == nil && pendingCount == 0 {
+			for _, lane := range lanes {
+				close(lane)
+			}
+			return
+		}
+
+		cases[splitSourceCase].Chan = reflect.Value{}
+		if source != nil && pendingCount < maxPendingRequests {
+			cases[splitSourceCase].Chan = reflect.ValueOf(source)
+		}
+		for lane, requests := range pending {
+			cases[splitLaneCases+lane] = reflect.SelectCase{Dir: reflect.SelectSend}
+			if len(requests) > 0 {
+				cases[splitLaneCases+lane].Chan = reflect.ValueOf(lanes[lane])
+				cases[splitLaneCases+lane].Send = reflect.ValueOf(requests[0])
+			}
+		}
+
+		chosen, value, ok := reflect.Select(cases)
+		switch {
+		case chosen == splitDoneCase:
+			for _, requests := range pending {
+				for _, request := range requests {
+					serialManager.dropRequest(request)
+				}
+			}
+			return
+		case chosen == splitSourceCase:
+			if !ok {
+				source = nil
+				continue
+			}
+			request := value.Interface().(Request)
+			serialManager.queued.Add(1)
+			lane := request.Priority.lane()
+			pending[lane] = append(pending[lane], request)
+			pendingCount++
+		default:
+			lane := chosen - splitLaneCases
+			pending[lane] = pending[lane][1:]
+			pendingCount--
+		}
+	}
+}
+
+// dropRequest closes the response channel of a request that is not sent because the manager stopped
+func (serialManager *serialManager) dropRequest(request Request) {
+	serialManager.queued.Add(-1)
+	if request.ResponseChannel != nil {
+		close(request.ResponseChannel)
+	}
+}
@@ -0,0 +1,242 @@
+package serial
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// blockedBus simulates a bus whose device at address 1 only responds once it is released.
+// It records the addresses of the requests received.
+type blockedBus struct {
+	mutex     sync.Mutex
+	addresses []int
+	received  chan bool
+	release   chan bool
+}
+
+func startBlockedBus(t *testing.T, device Transport) *blockedBus {
+	bus := &blockedBus{received: make(chan bool, 1), release: make(chan bool)}
+	simulateBus(t, device, func(encoder encoding.SerialEncoder, request encoding.Frame) (string, error) {
+		bus.mutex.Lock()
+		bus.addresses = append(bus.addresses, request.Address())
+		bus.mutex.Unlock()
+		if request.Address() == 1 {
+			bus.received <- true
+			<-bus.release
+		}
+		response, err := encoding.NewReadResponse(request.Address(), request.Function(), 1)
+		if err != nil {
+			return "", err
+		}
+		return encoder.Encode(response)
+	})
+	return bus
+}
+
+func (bus *blockedBus) requested() []int {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return append([]int(nil), bus.addresses...)
+}
+
+// blockedBusOptions returns the options of a port that waits for the device at address 1 to be released
+func blockedBusOptions() PortOptions {
+	options := DefaultPortOptions("bus1")
+	options.ResponseTimeout = 5 * time.Second
+	return options
+}
+
+func readRequest(t *testing.T, address int, priority Priority) (Request, <-chan Response) {
+	req, err := encoding.NewReadRequest(address, 2)
+	must.NoError(t, err)
+	responses := make(chan Response, 1)
+	return Request{Data: req, ResponseChannel: responses, Priority: priority}, responses
+}
+
+func TestPriority(t *testing.T) {
+	test.EqOp(t, 0, Interactive.lane())
+	test.EqOp(t, 1, Control.lane())
+	test.EqOp(t, 2, Background.lane())
+	test.EqOp(t, 0, (Interactive + 1).lane())
+	test.EqOp(t, 2, (Background - 1).lane())
+	test.EqOp(t, Control, Priority(0))
+	test.EqOp(t, "interactive", Interactive.String())
+	test.EqOp(t, "Priority(5)", Priority(5).String())
+
+	test.EqOp(t, Control, PriorityFromContext(context.Background()))
+	test.EqOp(t, Background, PriorityFromContext(WithPriority(context.Background(), Background)))
+}
+
+func TestRunByPriority(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{Port: blockedBusOptions(), Transport: pipeOpener(host)})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	var responses []<-chan Response
+	for _, request := range []struct {
+		address  int
+		priority Priority
+	}{{1, Control}, {2, Background}, {3, Control}, {4, Interactive}, {5, Background}, {6, Interactive}} {
+		req, response := readRequest(t, request.address, request.priority)
+		requests <- req
+		responses = append(responses, response)
+		if request.address == 1 {
+			<-bus.received
+		}
+	}
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return manager.Statistics().QueueDepth == 5 }),
+		wait.Timeout(time.Second),
+		wait.Gap(time.Millisecond),
+	))
+	close(bus.release)
+
+	for _, response := range responses {
+		must.NoError(t, (<-response).Err)
+	}
+	test.Eq(t, []int{1, 4, 6, 3, 2, 5}, bus.requested())
+}
+
+func TestRunFairWithinClass(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{Port: blockedBusOptions(), Transport: pipeOpener(host)})
+	must.NoError(t, err)
+	sources := []chan Request{make(chan Request, 4), make(chan Request, 4)}
+	for _, source := range sources {
+		must.NoError(t, manager.AddSource(source))
+	}
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	blocking, response := readRequest(t, 1, Control)
+	requests <- blocking
+	<-bus.received
+	var responses []<-chan Response
+	for i := range 4 {
+		for j, source := range sources {
+			req, response := readRequest(t, 10*(j+1)+i, Control)
+			source <- req
+			responses = append(responses, response)
+		}
+	}
+	must.Wait(t, wait.InitialSuccess(
+		// Not all requests are taken from the sources ahead
+		wait.BoolFunc(func() bool { return manager.Statistics().QueueDepth+len(sources[0])+len(sources[1]) == 8 }),
+		wait.Timeout(time.Second),
+		wait.Gap(time.Millisecond),
+	))
+	close(bus.release)
+
+	must.NoError(t, (<-response).Err)
+	for _, response := range responses {
+		must.NoError(t, (<-response).Err)
+	}
+	var counts [2]int
+	for _, address := range bus.requested()[1:] {
+		counts[address/10-1]++
+		test.LessEq(t, 1, max(counts[0]-counts[1], counts[1]-counts[0]))
+	}
+	test.Eq(t, [2]int{4, 4}, counts)
+}
+
+func TestRunTakesBoundedRequestsAhead(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{Port: blockedBusOptions(), Transport: pipeOpener(host)})
+	must.NoError(t, err)
+	source := make(chan Request, 20)
+	must.NoError(t, manager.AddSource(source))
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	blocking, response := readRequest(t, 1, Control)
+	requests <- blocking
+	<-bus.received
+	var responses []<-chan Response
+	for i := range cap(source) {
+		req, response := readRequest(t, 10+i, Background)
+		source <- req
+		responses = append(responses, response)
+	}
+	// The lane of the class holds one request, the others are pending or left in the source
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return manager.Statistics().QueueDepth == maxPendingRequests+1 }),
+		wait.Timeout(time.Second),
+		wait.Gap(time.Millisecond),
+	))
+	time.Sleep(10 * time.Millisecond)
+	test.EqOp(t, maxPendingRequests+1, manager.Statistics().QueueDepth)
+	test.EqOp(t, cap(source)-maxPendingRequests-1, len(source))
+	close(bus.release)
+
+	must.NoError(t, (<-response).Err)
+	for _, response := range responses {
+		must.NoError(t, (<-response).Err)
+	}
+	test.SliceLen(t, cap(source)+1, bus.requested())
+}
+
+func TestTakeReadyRequests(t *testing.T) {
+	var lanes [priorities]chan Request
+	var channels [priorities]<-chan Request
+	for lane := range lanes {
+		lanes[lane] = make(chan Request, 1)
+		channels[lane] = lanes[lane]
+	}
+	var held [priorities]Request
+	var holding [priorities]bool
+
+	// A request of the lowest class has been received while waiting when one of the highest class arrived
+	held[Background.lane()], holding[Background.lane()] = Request{Priority: Background}, true
+	lanes[Interactive.lane()] <- Request{Priority: Interactive}
+	close(lanes[Control.lane()])
+	takeReadyRequests(channels[:], held[:], holding[:])
+
+	test.True(t, holding[Interactive.lane()])
+	test.EqOp(t, Interactive, held[Interactive.lane()].Priority)
+	test.False(t, holding[Control.lane()])
+	test.Nil(t, channels[Control.lane()])
+	test.True(t, holding[Background.lane()])
+}
+
+func TestAddSource(t *testing.T) {
+	manager := startSimulatedBus(t, "bus1", nil)
+	test.ErrorContains(t, manager.AddSource(make(chan Request)), "after starting it")
+
+	manager, _, err := NewSerialManager(DefaultPortOptions("bus1"))
+	must.NoError(t, err)
+	test.ErrorContains(t, manager.AddSource(nil), "must not be nil")
+}
+
+func TestStopDropsQueuedRequests(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	defer close(bus.release)
+	options := DefaultPortOptions("bus1")
+	options.ResponseTimeout = 50 * time.Millisecond
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{Port: options, Transport: pipeOpener(host)})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+
+	first, firstResponse := readRequest(t, 1, Control)
+	second, secondResponse := readRequest(t, 2, Background)
+	requests <- first
+	<-bus.received
+	requests <- second
+	must.NoError(t, manager.Stop())
+
+	test.ErrorIs(t, (<-firstResponse).Err, NoDataOnSerialError)
+	_, ok := <-secondResponse
+	test.False(t, ok)
+}
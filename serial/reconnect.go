@@ -0,0 +1,171 @@
+package serial
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+)
+
+const (
+	// DEFAULT_RECONNECT_BACKOFF is the delay before the first attempt to reopen a failed port
+	DEFAULT_RECONNECT_BACKOFF = 500 * time.Millisecond
+	// DEFAULT_RECONNECT_MAX_BACKOFF is the longest delay between two attempts to reopen a failed port
+	DEFAULT_RECONNECT_MAX_BACKOFF = 30 * time.Second
+)
+
+// BusDisconnectedError is the error returned for requests received while the port is being reopened
+// if the PendingPolicy is PendingFail
+var BusDisconnectedError = merry.Sentinel("The serial bus is disconnected")
+
+// byIDDirectory contains stable links to the serial ports named after their USB adapter (Linux only)
+var byIDDirectory = "/dev/serial/by-id"
+
+// ConnectionState is the state of the connection to a serial port
+type ConnectionState string
+
+const (
+	// Connected is the ConnectionState of an open port
+	Connected ConnectionState = "connected"
+	// Disconnected is the ConnectionState of a port that failed and is being reopened
+	Disconnected ConnectionState = "disconnected"
+	// Closed is the ConnectionState of a port that has not been opened yet or has been closed by stopping the manager
+	Closed ConnectionState = "closed"
+)
+
+// StateEvent describes a change of the ConnectionState of a port
+type StateEvent struct {
+	// Port is the name of the port
+	Port string
+	// State is the new state of the port
+	State ConnectionState
+	// Err is the error that made the port fail if the state is Disconnected
+	Err error
+	// Time is the time the state changed
+	Time time.Time
+}
+
+// PendingPolicy describes what happens to requests received while a failed port is being reopened
+type PendingPolicy string
+
+const (
+	// PendingQueue leaves the requests in the request channel until the port has been reopened
+	// apart from the few requests the manager has taken ahead to send them by priority.
+	// Requests whose context ends in the meantime are skipped.
+	PendingQueue PendingPolicy = "queue"
+	// PendingFail answers the requests with a BusDisconnectedError
+	PendingFail PendingPolicy = "fail"
+)
+
+// ParsePendingPolicy parses the name of a PendingPolicy (queue or fail)
+func ParsePendingPolicy(value string) (PendingPolicy, error) {
+	switch pending := PendingPolicy(value); pending {
+	case PendingQueue, PendingFail:
+		return pending, nil
+	default:
+		return "", merry.Errorf("Unknown pending policy %s. Known pending policies are: %s, %s", value, PendingQueue, PendingFail)
+	}
+}
+
+// ReconnectPolicy describes how a port that failed (see PortFailureError) is reopened.
+// The zero value does not reopen failed ports.
+type ReconnectPolicy struct {
+	// Enabled makes the manager close and reopen a failed port
+	Enabled bool
+	// Backoff is the delay before the first attempt to reopen the port. It defaults to DEFAULT_RECONNECT_BACKOFF.
+	Backoff time.Duration
+	// MaxBackoff is the longest delay between two attempts. It defaults to DEFAULT_RECONNECT_MAX_BACKOFF.
+	MaxBackoff time.Duration
+	// FollowByID reopens the port using its link in /dev/serial/by-id.
+	// The link follows the adapter if it gets a different device name after being plugged in again.
+	FollowByID bool
+	// Pending describes what happens to requests received while the port is being reopened.
+	// It defaults to PendingQueue.
+	Pending PendingPolicy
+}
+
+// DefaultReconnectPolicy returns a policy reopening failed ports using their link in /dev/serial/by-id,
+// waiting 500ms before the first attempt and doubling the delay up to 30s.
+// Requests are queued while the port is being reopened.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		Enabled:    true,
+		Backoff:    DEFAULT_RECONNECT_BACKOFF,
+		MaxBackoff: DEFAULT_RECONNECT_MAX_BACKOFF,
+		FollowByID: true,
+		Pending:    PendingQueue,
+	}
+}
+
+// Validate checks whether the policy can be used
+func (policy ReconnectPolicy) Validate() error {
+	if policy.Backoff < 0 {
+		return merry.Errorf("The reconnect backoff must not be negative. It was %s", policy.Backoff)
+	}
+	if policy.MaxBackoff < 0 {
+		return merry.Errorf("The maximum reconnect backoff must not be negative. It was %s", policy.MaxBackoff)
+	}
+	if policy.Pending != "" {
+		if _, err := ParsePendingPolicy(string(policy.Pending)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delay returns the time to wait before the given attempt (starting at 1) to reopen the port
+func (policy ReconnectPolicy) delay(attempt int) time.Duration {
+	backoff := policy.Backoff
+	if backoff == 0 {
+		backoff = DEFAULT_RECONNECT_BACKOFF
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DEFAULT_RECONNECT_MAX_BACKOFF
+	}
+	return exponentialBackoff(backoff, maxBackoff, DEFAULT_BACKOFF_FACTOR, attempt)
+}
+
+// queuesPending returns whether requests are left in the request channel while the port is being reopened
+func (policy ReconnectPolicy) queuesPending() bool {
+	return policy.Pending != PendingFail
+}
+
+// exponentialBackoff returns the delay before the given attempt (starting at 1)
+// starting with the given backoff and multiplying it with the given factor for each further attempt.
+// A maximum of 0 does not limit the delay.
+func exponentialBackoff(backoff time.Duration, maximum time.Duration, factor float64, attempt int) time.Duration {
+	delay := float64(backoff) * math.Pow(factor, float64(attempt-1))
+	if maximum > 0 && delay >= float64(maximum) {
+		return maximum
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// stablePortName returns the link in /dev/serial/by-id pointing to the port with the given name.
+// It returns the given name if there is none.
+func stablePortName(name string) string {
+	if filepath.Dir(name) == byIDDirectory {
+		return name
+	}
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return name
+	}
+	entries, err := os.ReadDir(byIDDirectory)
+	if err != nil {
+		return name
+	}
+	for _, entry := range entries {
+		link := filepath.Join(byIDDirectory, entry.Name())
+		if resolved, err := filepath.EvalSymlinks(link); err == nil && resolved == target {
+			return link
+		}
+	}
+	return name
+}
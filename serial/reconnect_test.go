@@ -0,0 +1,65 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+)
+
+func TestParsePendingPolicy(t *testing.T) {
+	for _, pending := range []PendingPolicy{PendingQueue, PendingFail} {
+		parsed, err := ParsePendingPolicy(string(pending))
+		must.NoError(t, err)
+		test.EqOp(t, pending, parsed)
+	}
+
+	_, err := ParsePendingPolicy("drop")
+	test.ErrorContains(t, err, "Unknown pending policy drop")
+}
+
+func TestReconnectPolicyValidate(t *testing.T) {
+	test.NoError(t, ReconnectPolicy{}.Validate())
+	test.NoError(t, DefaultReconnectPolicy().Validate())
+
+	test.ErrorContains(t, ReconnectPolicy{Backoff: -time.Second}.Validate(), "reconnect backoff must not be negative")
+	test.ErrorContains(t, ReconnectPolicy{MaxBackoff: -time.Second}.Validate(), "maximum reconnect backoff must not be negative")
+	test.ErrorContains(t, ReconnectPolicy{Pending: "drop"}.Validate(), "Unknown pending policy drop")
+}
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	policy := DefaultReconnectPolicy()
+
+	test.EqOp(t, 500*time.Millisecond, policy.delay(1))
+	test.EqOp(t, 2*time.Second, policy.delay(3))
+	test.EqOp(t, 30*time.Second, policy.delay(10))
+
+	test.EqOp(t, DEFAULT_RECONNECT_BACKOFF, ReconnectPolicy{}.delay(1))
+	test.EqOp(t, DEFAULT_RECONNECT_MAX_BACKOFF, ReconnectPolicy{}.delay(100))
+}
+
+func TestStablePortName(t *testing.T) {
+	devices := t.TempDir()
+	byID := t.TempDir()
+	oldByIDDirectory := byIDDirectory
+	byIDDirectory = byID
+	defer func() { byIDDirectory = oldByIDDirectory }()
+
+	device := filepath.Join(devices, "ttyUSB0")
+	other := filepath.Join(devices, "ttyUSB1")
+	must.NoError(t, os.WriteFile(device, nil, 0o600))
+	must.NoError(t, os.WriteFile(other, nil, 0o600))
+	link := filepath.Join(byID, "usb-FTDI_FT232R_USB_UART_A12345-if00-port0")
+	must.NoError(t, os.Symlink(device, link))
+
+	test.EqOp(t, link, stablePortName(device))
+	test.EqOp(t, link, stablePortName(link))
+	test.EqOp(t, other, stablePortName(other))
+	test.EqOp(t, "COM3", stablePortName("COM3"))
+
+	byIDDirectory = filepath.Join(byID, "missing")
+	test.EqOp(t, device, stablePortName(device))
+}
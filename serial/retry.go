@@ -0,0 +1,217 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+const (
+	// DEFAULT_MAX_ATTEMPTS is the number of attempts of the default retry policy
+	DEFAULT_MAX_ATTEMPTS = 3
+	// DEFAULT_BACKOFF is the delay before the first retry of the default retry policy
+	DEFAULT_BACKOFF = 50 * time.Millisecond
+	// DEFAULT_MAX_BACKOFF is the longest delay between two attempts of the default retry policy
+	DEFAULT_MAX_BACKOFF = time.Second
+	// DEFAULT_BACKOFF_FACTOR is the factor the delay is multiplied with after each retry if none is given
+	DEFAULT_BACKOFF_FACTOR = 2
+)
+
+// RetryOn is a set of error classes a request is retried on
+type RetryOn uint
+
+const (
+	// RetryOnNoData retries requests that got no response (NoDataOnSerialError)
+	RetryOnNoData RetryOn = 1 << iota
+	// RetryOnInvalidResponse retries requests whose response could not be decoded (InvalidResponseError)
+	RetryOnInvalidResponse
+	// RetryOnMismatch retries requests that only got responses not matching the request (ResponseMismatchError)
+	RetryOnMismatch
+	// RetryOnWriteFailure retries requests that could not be written to the port (RequestNotSentError)
+	RetryOnWriteFailure
+
+	// RetryOnAll retries on all error classes
+	RetryOnAll = RetryOnNoData | RetryOnInvalidResponse | RetryOnMismatch | RetryOnWriteFailure
+)
+
+// retryOnClasses maps the names of the error classes to the errors they match
+var retryOnClasses = []struct {
+	name  string
+	class RetryOn
+	err   error
+}{
+	{"noData", RetryOnNoData, NoDataOnSerialError},
+	{"invalidResponse", RetryOnInvalidResponse, InvalidResponseError},
+	{"mismatch", RetryOnMismatch, ResponseMismatchError},
+	{"writeFailure", RetryOnWriteFailure, RequestNotSentError},
+}
+
+// ParseRetryOn parses a comma separated list of error classes (noData, invalidResponse, mismatch and writeFailure).
+// "none" and an empty string are the empty set, "all" is the set of all classes.
+func ParseRetryOn(value string) (RetryOn, error) {
+	var retryOn RetryOn
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			retryOn |= RetryOnAll
+			continue
+		}
+		found := false
+		for _, class := range retryOnClasses {
+			if class.name == name {
+				retryOn |= class.class
+				found = true
+			}
+		}
+		if !found {
+			return 0, merry.Errorf("Unknown error class %s. Known error classes are: %s", name, RetryOnAll)
+		}
+	}
+	return retryOn, nil
+}
+
+// String returns the comma separated names of the error classes as accepted by ParseRetryOn
+func (retryOn RetryOn) String() string {
+	var names []string
+	for _, class := range retryOnClasses {
+		if retryOn&class.class != 0 {
+			names = append(names, class.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// matches returns whether the given error belongs to one of the error classes
+func (retryOn RetryOn) matches(err error) bool {
+	for _, class := range retryOnClasses {
+		if retryOn&class.class != 0 && errors.Is(err, class.err) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteRetry describes when write requests may be retried.
+// Retrying a write request that has reached the device writes the value again.
+type WriteRetry string
+
+const (
+	// WriteRetryNever never retries write requests
+	WriteRetryNever WriteRetry = "never"
+	// WriteRetryUnsent only retries write requests that could not be written to the port (RequestNotSentError).
+	// The device can't have received them.
+	WriteRetryUnsent WriteRetry = "unsent"
+	// WriteRetryAlways retries write requests like read requests.
+	// Only use it if writing the same value twice is harmless for all functions.
+	WriteRetryAlways WriteRetry = "always"
+)
+
+// ParseWriteRetry parses the name of a WriteRetry (never, unsent or always)
+func ParseWriteRetry(value string) (WriteRetry, error) {
+	switch writeRetry := WriteRetry(value); writeRetry {
+	case WriteRetryNever, WriteRetryUnsent, WriteRetryAlways:
+		return writeRetry, nil
+	default:
+		return "", merry.Errorf("Unknown write retry %s. Known write retries are: %s, %s, %s", value, WriteRetryNever, WriteRetryUnsent, WriteRetryAlways)
+	}
+}
+
+// RetryPolicy describes how often and when a failed request is sent again.
+// Requests rejected by the device (encoding.FunctionRejectedError), invalid requests
+// and requests whose context is done are never retried.
+// The zero value does not retry at all.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent. 0 and 1 disable retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry
+	Backoff time.Duration
+	// MaxBackoff is the longest delay between two attempts. 0 does not limit the delay.
+	MaxBackoff time.Duration
+	// BackoffFactor is the factor the delay is multiplied with after each retry.
+	// It defaults to DEFAULT_BACKOFF_FACTOR.
+	BackoffFactor float64
+	// RetryOn are the error classes requests are retried on
+	RetryOn RetryOn
+	// WriteRetry describes when write requests may be retried. It defaults to WriteRetryNever.
+	WriteRetry WriteRetry
+}
+
+// DefaultRetryPolicy returns a policy sending requests up to 3 times on all error classes,
+// waiting 50ms before the first retry and doubling the delay up to 1s.
+// Write requests are only retried if they could not be written to the port.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   DEFAULT_MAX_ATTEMPTS,
+		Backoff:       DEFAULT_BACKOFF,
+		MaxBackoff:    DEFAULT_MAX_BACKOFF,
+		BackoffFactor: DEFAULT_BACKOFF_FACTOR,
+		RetryOn:       RetryOnAll,
+		WriteRetry:    WriteRetryUnsent,
+	}
+}
+
+// Validate checks whether the policy can be used
+func (policy RetryPolicy) Validate() error {
+	if policy.MaxAttempts < 0 {
+		return merry.Errorf("The maximum number of attempts must not be negative. It was %d", policy.MaxAttempts)
+	}
+	if policy.Backoff < 0 {
+		return merry.Errorf("The backoff must not be negative. It was %s", policy.Backoff)
+	}
+	if policy.MaxBackoff < 0 {
+		return merry.Errorf("The maximum backoff must not be negative. It was %s", policy.MaxBackoff)
+	}
+	if policy.BackoffFactor != 0 && policy.BackoffFactor < 1 {
+		return merry.Errorf("The backoff factor must be at least 1. It was %g", policy.BackoffFactor)
+	}
+	if policy.WriteRetry != "" {
+		if _, err := ParseWriteRetry(string(policy.WriteRetry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldRetry returns whether the given request that failed with the given error in the given attempt
+// (starting at 1) may be sent again
+func (policy RetryPolicy) shouldRetry(request encoding.Frame, err error, attempt int) bool {
+	if attempt >= policy.MaxAttempts {
+		return false
+	}
+	if errors.Is(err, encoding.FunctionRejectedError) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if !policy.RetryOn.matches(err) {
+		return false
+	}
+	if request.FrameType() == encoding.WriteRequest {
+		switch policy.WriteRetry {
+		case WriteRetryAlways:
+			return true
+		case WriteRetryUnsent:
+			return errors.Is(err, RequestNotSentError)
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// delay returns the time to wait before the given retry (starting at 1)
+func (policy RetryPolicy) delay(retry int) time.Duration {
+	factor := policy.BackoffFactor
+	if factor == 0 {
+		factor = DEFAULT_BACKOFF_FACTOR
+	}
+	return exponentialBackoff(policy.Backoff, policy.MaxBackoff, factor, retry)
+}
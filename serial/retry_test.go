@@ -0,0 +1,138 @@
+package serial
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+func TestParseRetryOn(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected RetryOn
+	}{
+		{"", 0},
+		{"none", 0},
+		{"all", RetryOnAll},
+		{"noData", RetryOnNoData},
+		{"noData, mismatch", RetryOnNoData | RetryOnMismatch},
+		{"invalidResponse,writeFailure", RetryOnInvalidResponse | RetryOnWriteFailure},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			retryOn, err := ParseRetryOn(tc.value)
+			must.NoError(t, err)
+			test.EqOp(t, tc.expected, retryOn)
+
+			// The string representation can be parsed again
+			reparsed, err := ParseRetryOn(retryOn.String())
+			must.NoError(t, err)
+			test.EqOp(t, retryOn, reparsed)
+		})
+	}
+
+	_, err := ParseRetryOn("noData,rejection")
+	test.ErrorContains(t, err, "Unknown error class rejection")
+}
+
+func TestParseWriteRetry(t *testing.T) {
+	for _, writeRetry := range []WriteRetry{WriteRetryNever, WriteRetryUnsent, WriteRetryAlways} {
+		parsed, err := ParseWriteRetry(string(writeRetry))
+		must.NoError(t, err)
+		test.EqOp(t, writeRetry, parsed)
+	}
+
+	_, err := ParseWriteRetry("sometimes")
+	test.ErrorContains(t, err, "Unknown write retry sometimes")
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	test.NoError(t, RetryPolicy{}.Validate())
+	test.NoError(t, DefaultRetryPolicy().Validate())
+
+	testCases := []struct {
+		policy   RetryPolicy
+		expected string
+	}{
+		{RetryPolicy{MaxAttempts: -1}, "attempts must not be negative"},
+		{RetryPolicy{Backoff: -time.Second}, "backoff must not be negative"},
+		{RetryPolicy{MaxBackoff: -time.Second}, "maximum backoff must not be negative"},
+		{RetryPolicy{BackoffFactor: 0.5}, "backoff factor must be at least 1"},
+		{RetryPolicy{WriteRetry: "sometimes"}, "Unknown write retry"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			test.ErrorContains(t, tc.policy.Validate(), tc.expected)
+		})
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	read, err := encoding.NewReadRequest(1, 2)
+	must.NoError(t, err)
+	write, err := encoding.NewWriteRequest(1, 2, 3)
+	must.NoError(t, err)
+
+	noData := merry.Prepend(NoDataOnSerialError, "Failed to read response frame")
+	invalid := merry.Prepend(fmt.Errorf("Some Decode failure"), "Failed to decode frame", merry.WithCause(InvalidResponseError))
+	notSent := merry.Prepend(fmt.Errorf("Some Write failure"), "Failed to write request frame", merry.WithCause(RequestNotSentError))
+	mismatch := merry.Wrap(&ResponseMismatch{Request: read})
+	rejected := merry.Prepend(&encoding.FunctionRejection{Address: 1, RequestType: encoding.ReadRequest}, "Failed to read response frame")
+	cancelled := merry.Prepend(context.Canceled, "Request cancelled before sending it")
+
+	policy := DefaultRetryPolicy()
+
+	testCases := []struct {
+		name     string
+		policy   RetryPolicy
+		request  encoding.Frame
+		err      error
+		attempt  int
+		expected bool
+	}{
+		{"no data", policy, read, noData, 1, true},
+		{"invalid response", policy, read, invalid, 1, true},
+		{"not sent", policy, read, notSent, 1, true},
+		{"mismatch", policy, read, mismatch, 2, true},
+		{"last attempt", policy, read, noData, 3, false},
+		{"rejection", policy, read, rejected, 1, false},
+		{"cancelled", policy, read, cancelled, 1, false},
+		{"other error", policy, read, fmt.Errorf("Some validation failure"), 1, false},
+		{"class not enabled", RetryPolicy{MaxAttempts: 3, RetryOn: RetryOnMismatch}, read, noData, 1, false},
+		{"zero policy", RetryPolicy{}, read, noData, 1, false},
+		{"write not sent", policy, write, notSent, 1, true},
+		{"write without response", policy, write, noData, 1, false},
+		{"write never", RetryPolicy{MaxAttempts: 3, RetryOn: RetryOnAll}, write, notSent, 1, false},
+		{"write always", RetryPolicy{MaxAttempts: 3, RetryOn: RetryOnAll, WriteRetry: WriteRetryAlways}, write, noData, 1, true},
+		{"write always rejection", RetryPolicy{MaxAttempts: 3, RetryOn: RetryOnAll, WriteRetry: WriteRetryAlways}, write, rejected, 1, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test.EqOp(t, tc.expected, tc.policy.shouldRetry(tc.request, tc.err, tc.attempt))
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := DefaultRetryPolicy()
+
+	test.EqOp(t, 50*time.Millisecond, policy.delay(1))
+	test.EqOp(t, 100*time.Millisecond, policy.delay(2))
+	test.EqOp(t, 400*time.Millisecond, policy.delay(4))
+	test.EqOp(t, time.Second, policy.delay(6))
+
+	policy.BackoffFactor = 0
+	test.EqOp(t, 200*time.Millisecond, policy.delay(3))
+
+	policy.MaxBackoff = 0
+	test.EqOp(t, time.Duration(1<<63-1), policy.delay(1000))
+}
@@ -0,0 +1,277 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"go.bug.st/serial"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Telnet commands and options used by RFC 2217
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptionBinary          = 0
+	telnetOptionSuppressGoAhead = 3
+	telnetOptionComPort         = 44
+)
+
+// Commands of the COM-PORT-OPTION (RFC 2217) sent by the client. The server answers with the command plus 100.
+const (
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortPurgeData   = 12
+)
+
+// comPortPurgeReceive is the value of the PURGE-DATA command discarding the receive buffer of the remote port
+const comPortPurgeReceive = 1
+
+// comPortParities maps the parities to the values of the SET-PARITY command
+var comPortParities = map[serial.Parity]byte{
+	serial.NoParity:    1,
+	serial.OddParity:   2,
+	serial.EvenParity:  3,
+	serial.MarkParity:  4,
+	serial.SpaceParity: 5,
+}
+
+// comPortStopBits maps the stop bits to the values of the SET-STOPSIZE command
+var comPortStopBits = map[serial.StopBits]byte{
+	serial.OneStopBit:           1,
+	serial.TwoStopBits:          2,
+	serial.OnePointFiveStopBits: 3,
+}
+
+// telnetState is the state of the parser of the telnet stream
+type telnetState int
+
+const (
+	telnetStateData telnetState = iota
+	telnetStateIAC
+	telnetStateNegotiation
+	telnetStateSubnegotiation
+	telnetStateSubnegotiationIAC
+)
+
+// rfc2217Port is a Transport accessing a remote port using the telnet COM-PORT-OPTION (RFC 2217).
+// Unlike a raw TCP port, it sets the line parameters on the remote side.
+// It is not a DirectionControl though: It can't tell when the remote side has transmitted the data,
+// so it can't switch an RS485 transceiver with RTS for each frame.
+type rfc2217Port struct {
+	tcpPort
+	state telnetState
+	// command is the negotiation command (WILL, WONT, DO or DONT) being parsed
+	command byte
+	// subnegotiation is the subnegotiation being parsed
+	subnegotiation []byte
+	// received holds the bytes read from the connection before removing the telnet commands
+	received [256]byte
+	// escaped holds the data being written with escaped IAC bytes
+	escaped []byte
+}
+
+// openRFC2217Port connects to the RFC 2217 server with the given address (host:port) and sets the given mode
+func openRFC2217Port(address string, mode *serial.Mode) (*rfc2217Port, error) {
+	tcp, err := openTCPPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port := &rfc2217Port{tcpPort: *tcp}
+
+	negotiation := []byte{
+		telnetIAC, telnetWILL, telnetOptionComPort,
+		telnetIAC, telnetWILL, telnetOptionBinary,
+		telnetIAC, telnetDO, telnetOptionBinary,
+		telnetIAC, telnetDO, telnetOptionSuppressGoAhead,
+	}
+	if _, err := port.conn.Write(negotiation); err != nil {
+		port.conn.Close()
+		return nil, merry.Prependf(err, "Failed to negotiate the COM port option with %s", address)
+	}
+	if err := port.SetMode(mode); err != nil {
+		port.conn.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// SetMode sets the line parameters of the remote port
+func (port *rfc2217Port) SetMode(mode *serial.Mode) error {
+	parity, ok := comPortParities[mode.Parity]
+	if !ok {
+		return merry.Errorf("Unknown parity %d", mode.Parity)
+	}
+	stopBits, ok := comPortStopBits[mode.StopBits]
+	if !ok {
+		return merry.Errorf("Unknown stop bits %d", mode.StopBits)
+	}
+	var baudRate [4]byte
+	binary.BigEndian.PutUint32(baudRate[:], uint32(mode.BaudRate))
+
+	commands := []struct {
+		command byte
+		value   []byte
+	}{
+		{comPortSetBaudRate, baudRate[:]},
+		{comPortSetDataSize, []byte{byte(mode.DataBits)}},
+		{comPortSetParity, []byte{parity}},
+		{comPortSetStopSize, []byte{stopBits}},
+	}
+	for _, command := range commands {
+		if err := port.sendComPortCommand(command.command, command.value...); err != nil {
+			return merry.Prepend(err, "Failed to set the serial mode of the remote port")
+		}
+	}
+	return nil
+}
+
+// sendComPortCommand sends the given COM-PORT-OPTION command with the given value
+func (port *rfc2217Port) sendComPortCommand(command byte, value ...byte) error {
+	subnegotiation := []byte{telnetIAC, telnetSB, telnetOptionComPort, command}
+	subnegotiation = appendEscaped(subnegotiation, value)
+	subnegotiation = append(subnegotiation, telnetIAC, telnetSE)
+	_, err := port.conn.Write(subnegotiation)
+	return err
+}
+
+// appendEscaped appends the given data to dst doubling IAC bytes
+func appendEscaped(dst []byte, data []byte) []byte {
+	for _, b := range data {
+		if b == telnetIAC {
+			dst = append(dst, telnetIAC)
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
+
+// Read reads the data from the connection and handles the telnet commands in between.
+// Like a serial port, it returns 0 bytes without an error after the read timeout.
+func (port *rfc2217Port) Read(p []byte) (int, error) {
+	size := min(len(p), len(port.received))
+	received, err := port.tcpPort.Read(port.received[:size])
+	n := 0
+	for _, b := range port.received[:received] {
+		if port.parse(b) {
+			p[n] = b
+			n++
+		}
+	}
+	return n, err
+}
+
+// parse handles the next byte of the telnet stream and returns whether it is a data byte
+func (port *rfc2217Port) parse(b byte) bool {
+	switch port.state {
+	case telnetStateIAC:
+		switch b {
+		case telnetIAC:
+			port.state = telnetStateData
+			return true
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			port.command = b
+			port.state = telnetStateNegotiation
+		case telnetSB:
+			port.subnegotiation = port.subnegotiation[:0]
+			port.state = telnetStateSubnegotiation
+		default:
+			// Other commands (e.g. NOP) have no arguments
+			port.state = telnetStateData
+		}
+	case telnetStateNegotiation:
+		port.negotiate(port.command, b)
+		port.state = telnetStateData
+	case telnetStateSubnegotiation:
+		if b == telnetIAC {
+			port.state = telnetStateSubnegotiationIAC
+		} else {
+			port.subnegotiation = append(port.subnegotiation, b)
+		}
+	case telnetStateSubnegotiationIAC:
+		if b == telnetSE {
+			if log.IsLevelEnabled(log.TraceLevel) {
+				log.WithField("subnegotiation", port.subnegotiation).Trace("Received telnet subnegotiation")
+			}
+			port.state = telnetStateData
+		} else {
+			port.subnegotiation = append(port.subnegotiation, b)
+			port.state = telnetStateSubnegotiation
+		}
+	default:
+		if b == telnetIAC {
+			port.state = telnetStateIAC
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// negotiate answers the given negotiation command of the server.
+// Options other than the ones requested when connecting are refused.
+func (port *rfc2217Port) negotiate(command byte, option byte) {
+	supported := option == telnetOptionComPort || option == telnetOptionBinary || option == telnetOptionSuppressGoAhead
+	if supported {
+		// Acknowledgement of the options requested when connecting
+		return
+	}
+	var answer byte
+	switch command {
+	case telnetDO:
+		answer = telnetWONT
+	case telnetWILL:
+		answer = telnetDONT
+	default:
+		return
+	}
+	if _, err := port.conn.Write([]byte{telnetIAC, answer, option}); err != nil {
+		log.WithError(err).Debug("Failed to refuse telnet option")
+	}
+}
+
+// Write writes the given data doubling IAC bytes
+func (port *rfc2217Port) Write(p []byte) (int, error) {
+	port.escaped = appendEscaped(port.escaped[:0], p)
+	if _, err := port.conn.Write(port.escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ResetInputBuffer discards the data received by the remote port and the data already received.
+// Reading stops once nothing has been received for a millisecond.
+// Reads returning only telnet commands (e.g. the acknowledgement of the purge) do not stop it.
+func (port *rfc2217Port) ResetInputBuffer() error {
+	if err := port.sendComPortCommand(comPortPurgeData, comPortPurgeReceive); err != nil {
+		return err
+	}
+	for {
+		if err := port.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+			return err
+		}
+		received, err := port.conn.Read(port.received[:])
+		for _, b := range port.received[:received] {
+			// The data bytes are discarded, the telnet commands are still handled
+			port.parse(b)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/ventcon/ventcon-hwio/encoding"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DEFAULT_PROBE_TIMEOUT is the time a single probe of a scan may take including its retries
+	DEFAULT_PROBE_TIMEOUT = 2 * DEFAULT_RESPONSE_TIMEOUT
+	// DEFAULT_PROBE_FUNCTION is the function read by the default probe.
+	// Any response including a rejection shows that a device is present.
+	DEFAULT_PROBE_FUNCTION = encoding.MINIMUM_FUNCTION
+)
+
+// Probe creates the request sent to find out whether a device with the given address is present.
+// A device is present if it responds to the request or rejects its function.
+type Probe func(address int) (encoding.Frame, error)
+
+// ReadProbe returns a Probe reading the given function
+func ReadProbe(function int) Probe {
+	return func(address int) (encoding.Frame, error) {
+		return encoding.NewReadRequest(address, function)
+	}
+}
+
+// ScanOptions describe a scan of a bus
+type ScanOptions struct {
+	// First is the first address probed. It defaults to encoding.MINIMUM_ADDRESS.
+	First int
+	// Last is the last address probed. It defaults to encoding.MAXIMUM_ADDRESS.
+	Last int
+	// Timeout is the time a single probe may take including its retries. It defaults to DEFAULT_PROBE_TIMEOUT.
+	Timeout time.Duration
+	// Probe creates the requests sent. It defaults to ReadProbe(DEFAULT_PROBE_FUNCTION).
+	// If the manager has a validator, the request must pass it.
+	Probe Probe
+}
+
+// withDefaults returns the options with the defaults applied
+func (options ScanOptions) withDefaults() ScanOptions {
+	if options.First == 0 {
+		options.First = encoding.MINIMUM_ADDRESS
+	}
+	if options.Last == 0 {
+		options.Last = encoding.MAXIMUM_ADDRESS
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DEFAULT_PROBE_TIMEOUT
+	}
+	if options.Probe == nil {
+		options.Probe = ReadProbe(DEFAULT_PROBE_FUNCTION)
+	}
+	return options
+}
+
+// Validate checks whether the options can be used
+func (options ScanOptions) Validate() error {
+	options = options.withDefaults()
+	if options.First < encoding.MINIMUM_ADDRESS || options.Last > encoding.MAXIMUM_ADDRESS || options.First > options.Last {
+		return merry.Errorf("The addresses scanned must be between %d and %d (inclusive) and the first must not be larger than the last. They were %d to %d",
+			encoding.MINIMUM_ADDRESS, encoding.MAXIMUM_ADDRESS, options.First, options.Last)
+	}
+	if options.Timeout < 0 {
+		return merry.Errorf("The probe timeout must not be negative. It was %s", options.Timeout)
+	}
+	return nil
+}
+
+// ScannedDevice is a device that responded to a probe of a scan
+type ScannedDevice struct {
+	// Port is the name of the port of the bus the device is connected to
+	Port string
+	// Address is the address of the device
+	Address int
+	// Latency is the time the device took to respond
+	Latency time.Duration
+	// Rejected is true if the device rejected the function of the probe
+	Rejected bool
+}
+
+// Scan probes the addresses of the bus one after the other and returns the devices that responded.
+// An address is considered empty if its probe received no data or timed out (e.g. because the bus was busy).
+// Any other error of a probe (e.g. the probe not passing the validator of the manager or the bus being disconnected)
+// aborts the scan, as does the end of the context. The devices found until then are returned with the error.
+func (serialManager *serialManager) Scan(ctx context.Context, options ScanOptions) ([]ScannedDevice, error) {
+	if err := options.Validate(); err != nil {
+		return nil, merry.Prependf(err, "Invalid scan of port %s", serialManager.port.Name)
+	}
+	if serialManager.State() == Closed {
+		return nil, merry.Errorf("Can't scan port %s before starting its manager", serialManager.port.Name)
+	}
+	options = options.withDefaults()
+
+	log.WithField("port", serialManager.port.Name).WithField("first", options.First).WithField("last", options.Last).Info("Scanning bus")
+	var devices []ScannedDevice
+	for address := options.First; address <= options.Last; address++ {
+		request, err := options.Probe(address)
+		if err != nil {
+			return devices, merry.Prependf(err, "Failed to create the probe of address %d", address)
+		}
+		response, err := serialManager.probe(ctx, request, options.Timeout)
+		if err != nil {
+			return devices, merry.Prependf(err, "Scan of port %s aborted at address %d", serialManager.port.Name, address)
+		}
+		rejected := errors.Is(response.Err, encoding.FunctionRejectedError)
+		if response.Err != nil && !rejected {
+			if !errors.Is(response.Err, NoDataOnSerialError) && !errors.Is(response.Err, context.DeadlineExceeded) {
+				return devices, merry.Prependf(response.Err, "Scan of port %s aborted at address %d", serialManager.port.Name, address)
+			}
+			log.WithField("port", serialManager.port.Name).WithField("address", address).WithError(response.Err).Trace("No device found")
+			continue
+		}
+		log.WithField("port", serialManager.port.Name).WithField("address", address).WithField("latency", response.Latency).Info("Found device")
+		devices = append(devices, ScannedDevice{
+			Port:     serialManager.port.Name,
+			Address:  address,
+			Latency:  response.Latency,
+			Rejected: rejected,
+		})
+	}
+	return devices, nil
+}
+
+// probe sends the given request bounded by the given timeout.
+// It only returns an error if the context ends. The errors of the probe itself are returned in the response.
+func (serialManager *serialManager) probe(ctx context.Context, request encoding.Frame, timeout time.Duration) (Response, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	responses := make(chan Response, 1)
+	var response Response
+	answered := false
+	select {
+	case serialManager.probes <- Request{Data: request, ResponseChannel: responses, Context: probeCtx}:
+		select {
+		case response, answered = <-responses:
+		case <-probeCtx.Done():
+			// The probe is waiting behind other requests or for the bus to be reconnected
+		}
+	case <-probeCtx.Done():
+		// The bus is busy with other requests or disconnected
+	}
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	if !answered {
+		// The probe timed out before it has been answered
+		return Response{Err: context.DeadlineExceeded}, nil
+	}
+	return response, nil
+}
+
+// ScanBuses scans the buses of the given managers in parallel and returns the devices that responded
+// ordered by port and address. The devices found on the other buses are returned even if the scan of a bus failed.
+func ScanBuses(ctx context.Context, managers []SerialManager, options ScanOptions) ([]ScannedDevice, error) {
+	var wg sync.WaitGroup
+	devices := make([][]ScannedDevice, len(managers))
+	errs := make([]error, len(managers))
+	for i, manager := range managers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			devices[i], errs[i] = manager.Scan(ctx, options)
+		}()
+	}
+	wg.Wait()
+
+	var all []ScannedDevice
+	for _, found := range devices {
+		all = append(all, found...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Port != all[j].Port {
+			return all[i].Port < all[j].Port
+		}
+		return all[i].Address < all[j].Address
+	})
+	return all, errors.Join(errs...)
+}
@@ -0,0 +1,175 @@
+package serial
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// startSimulatedBus starts a manager for a bus with devices at the given addresses.
+// The devices with a true value reject all functions.
+func startSimulatedBus(t *testing.T, name string, devices map[int]bool) SerialManager {
+	manager, _, err := NewSerialManagerWithOptions(simulatedBusOptions(t, name, devices))
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	t.Cleanup(func() { manager.Stop() })
+	return manager
+}
+
+// simulatedBusOptions returns the options of a manager for a bus with devices at the given addresses
+// (see startSimulatedBus)
+func simulatedBusOptions(t *testing.T, name string, devices map[int]bool) ManagerOptions {
+	host, device := NewPipe()
+	simulateBus(t, device, func(encoder encoding.SerialEncoder, request encoding.Frame) (string, error) {
+		rejects, ok := devices[request.Address()]
+		switch {
+		case !ok:
+			return "", nil
+		case rejects:
+			return encoder.EncodeInvalidFunctionResponse(request)
+		}
+		response, err := encoding.NewReadResponse(request.Address(), request.Function(), 1)
+		if err != nil {
+			return "", err
+		}
+		return encoder.Encode(response)
+	})
+
+	options, err := ParsePortOptions(name + "?responseTimeout=10ms")
+	must.NoError(t, err)
+	return ManagerOptions{Port: options, Transport: pipeOpener(host)}
+}
+
+func TestScanOptionsValidate(t *testing.T) {
+	test.NoError(t, ScanOptions{}.Validate())
+	test.NoError(t, ScanOptions{First: 10, Last: 10}.Validate())
+
+	test.ErrorContains(t, ScanOptions{First: 10, Last: 9}.Validate(), "first must not be larger than the last")
+	test.ErrorContains(t, ScanOptions{Last: 251}.Validate(), "between 1 and 250")
+	test.ErrorContains(t, ScanOptions{Timeout: -time.Second}.Validate(), "probe timeout must not be negative")
+}
+
+func TestScan(t *testing.T) {
+	manager := startSimulatedBus(t, "bus1", map[int]bool{3: false, 5: true, 9: false})
+
+	devices, err := manager.Scan(context.Background(), ScanOptions{First: 1, Last: 8, Timeout: 50 * time.Millisecond})
+	must.NoError(t, err)
+	must.Len(t, 2, devices)
+	test.EqOp(t, ScannedDevice{Port: "bus1", Address: 3, Latency: devices[0].Latency}, devices[0])
+	test.EqOp(t, ScannedDevice{Port: "bus1", Address: 5, Latency: devices[1].Latency, Rejected: true}, devices[1])
+	test.Positive(t, devices[0].Latency)
+	test.Less(t, 10*time.Millisecond, devices[0].Latency)
+}
+
+func TestScanWithProbe(t *testing.T) {
+	manager := startSimulatedBus(t, "bus1", map[int]bool{3: false})
+
+	var probed []int
+	probe := func(address int) (encoding.Frame, error) {
+		probed = append(probed, address)
+		return encoding.NewReadRequest(address, 42)
+	}
+	devices, err := manager.Scan(context.Background(), ScanOptions{First: 2, Last: 4, Probe: probe})
+	must.NoError(t, err)
+	must.Len(t, 1, devices)
+	test.EqOp(t, 3, devices[0].Address)
+	test.Eq(t, []int{2, 3, 4}, probed)
+}
+
+func TestScanBeforeStart(t *testing.T) {
+	manager, _, err := NewSerialManager(DefaultPortOptions("bus1"))
+	must.NoError(t, err)
+
+	_, err = manager.Scan(context.Background(), ScanOptions{})
+	test.ErrorContains(t, err, "before starting its manager")
+}
+
+func TestScanCancelled(t *testing.T) {
+	manager := startSimulatedBus(t, "bus1", map[int]bool{1: false})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	devices, err := manager.Scan(ctx, ScanOptions{})
+	test.ErrorIs(t, err, context.DeadlineExceeded)
+	// The devices found before are returned
+	must.Len(t, 1, devices)
+	test.EqOp(t, 1, devices[0].Address)
+}
+
+func TestScanAbortsOnPortFailure(t *testing.T) {
+	host, device := NewPipe()
+	must.NoError(t, device.Close())
+	manager, _, err := NewSerialManagerWithOptions(ManagerOptions{Port: DefaultPortOptions("bus1"), Transport: pipeOpener(host)})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	_, err = manager.Scan(context.Background(), ScanOptions{})
+	test.ErrorIs(t, err, PortFailureError)
+	test.ErrorContains(t, err, "aborted at address 1")
+}
+
+func TestScanInvalidProbe(t *testing.T) {
+	options := simulatedBusOptions(t, "bus1", map[int]bool{1: false})
+	options.Validator = &testValidator{invalidType: encoding.ReadRequest}
+	manager, _, err := NewSerialManagerWithOptions(options)
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	// A probe not passing the validator does not look like an empty bus
+	devices, err := manager.Scan(context.Background(), ScanOptions{Last: 3})
+	test.ErrorContains(t, err, "Some validation failure")
+	test.ErrorContains(t, err, "aborted at address 1")
+	test.SliceEmpty(t, devices)
+}
+
+func TestScanWhileBusHeld(t *testing.T) {
+	host, device := NewPipe()
+	bus := startBlockedBus(t, device)
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{Port: blockedBusOptions(), Transport: pipeOpener(host)})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+	defer close(bus.release)
+
+	blocking, _ := readRequest(t, 1, Control)
+	requests <- blocking
+	<-bus.received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	scanned := make(chan error, 1)
+	go func() {
+		devices, err := manager.Scan(ctx, ScanOptions{First: 2, Timeout: 20 * time.Millisecond})
+		test.SliceEmpty(t, devices)
+		scanned <- err
+	}()
+
+	select {
+	case err := <-scanned:
+		test.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("The scan is still waiting for the held bus")
+	}
+}
+
+func TestScanBuses(t *testing.T) {
+	bus1 := startSimulatedBus(t, "bus1", map[int]bool{4: false, 2: false})
+	bus2 := startSimulatedBus(t, "bus2", map[int]bool{1: false})
+
+	devices, err := ScanBuses(context.Background(), []SerialManager{bus2, bus1}, ScanOptions{Last: 5})
+	must.NoError(t, err)
+	must.Len(t, 3, devices)
+	for i, expected := range []struct {
+		port    string
+		address int
+	}{{"bus1", 2}, {"bus1", 4}, {"bus2", 1}} {
+		test.EqOp(t, expected.port, devices[i].Port)
+		test.EqOp(t, expected.address, devices[i].Address)
+	}
+}
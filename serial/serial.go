@@ -1,52 +1,157 @@
 package serial
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"errors"
-	"io"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/ansel1/merry/v2"
 	"github.com/ventcon/ventcon-hwio/encoding"
-	"go.bug.st/serial"
 
 	log "github.com/sirupsen/logrus"
 )
 
 var NoDataOnSerialError = merry.Sentinel("No data on serial")
 
+// InvalidResponseError is the error returned when the response could not be decoded (e.g. because of noise on the line)
+var InvalidResponseError = merry.Sentinel("Invalid response")
+
+// RequestNotSentError is the error returned when sending a request failed before it could have reached the device
+var RequestNotSentError = merry.Sentinel("Request not sent")
+
+// EchoMismatchError is the error returned when the local echo of a request differs from the request
+// (e.g. because of a collision on the bus)
+var EchoMismatchError = merry.Sentinel("The echo does not match the request")
+
+// WriteFailedError is the error returned when writing a request to the serial port failed.
+// Unlike RequestNotSentError, it is also returned if part of the request may have reached the device.
+var WriteFailedError = merry.Sentinel("Failed to write to the serial port")
+
+// PortFailureError is the error returned when the serial port itself failed (e.g. because the adapter has been unplugged)
+var PortFailureError = merry.Sentinel("Serial port failure")
+
+// portFailure marks an error of the serial port as PortFailureError.
+// Unlike merry.WithCause, it is not hidden by causes added by callers (e.g. RequestNotSentError).
+// If it occurred while writing, it also matches WriteFailedError.
+type portFailure struct {
+	err   error
+	write bool
+}
+
+func (failure *portFailure) Error() string {
+	return failure.err.Error()
+}
+
+func (failure *portFailure) Unwrap() error {
+	return failure.err
+}
+
+// Is makes the portFailure match PortFailureError and, if it occurred while writing, WriteFailedError
+func (failure *portFailure) Is(target error) bool {
+	return target == PortFailureError || (failure.write && target == WriteFailedError)
+}
+
+// ResponseMismatchError is the error returned when no response matching the request has been received.
+// Use errors.As with a *ResponseMismatch to get the details.
+var ResponseMismatchError = merry.Sentinel("The response does not match the request")
+
+// maxMismatchedResponses is the number of mismatched responses discarded while waiting for the response to a request
+// before giving up with a ResponseMismatchError
+const maxMismatchedResponses = 3
+
+// ResponseMismatch describes a response that does not match its request.
+// It matches ResponseMismatchError when used with errors.Is.
+type ResponseMismatch struct {
+	// Request is the request that has been sent
+	Request encoding.Frame
+	// Response is the last response received or nil if it was a rejection
+	Response encoding.Frame
+	// Rejection is the last rejection received or nil if it was a response
+	Rejection *encoding.FunctionRejection
+}
+
+// Error returns the description of the mismatch
+func (mismatch *ResponseMismatch) Error() string {
+	if mismatch.Rejection != nil {
+		return fmt.Sprintf("Received rejection of a %s to device %d as response to %v", mismatch.Rejection.RequestType, mismatch.Rejection.Address, mismatch.Request)
+	}
+	return fmt.Sprintf("Received %v as response to %v", mismatch.Response, mismatch.Request)
+}
+
+// Is makes the ResponseMismatch match ResponseMismatchError
+func (mismatch *ResponseMismatch) Is(target error) bool {
+	return target == ResponseMismatchError
+}
+
+// CorrelationCounters count how the responses received matched their requests
+type CorrelationCounters struct {
+	// Matched is the number of responses (and rejections) that matched their request
+	Matched uint64
+	// Mismatched is the number of responses (and rejections) discarded because they did not match their request
+	Mismatched uint64
+	// Drained is the number of times stale data has been discarded before sending a request
+	Drained uint64
+}
+
 type Serial interface {
-	Open(portName string) error
+	Open(options PortOptions) error
 	Close() error
-	SendRequest(data encoding.Frame) (encoding.Frame, error)
+	SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error)
+	SetValidator(validator encoding.FrameValidator)
+	SetObserver(observer Observer)
+	CorrelationCounters() CorrelationCounters
 	markAsValidSerial()
 }
 
 type serialCommunicator struct {
-	lowLevelSerialOpener func(portName string, mode *serial.Mode) (serial.Port, error)
+	lowLevelSerialOpener TransportOpener
 	encoder              encoding.SerialEncoder
-	port                 serial.Port
-	reader               *bufio.Reader
+	port                 Transport
+	reader               *frameReader
+	options              PortOptions
+	writeBuffer          [encoding.MAXIMUM_FRAME_LENGTH]byte
+	written              []byte
+	validator            encoding.FrameValidator
+	observer             Observer
+	matched              atomic.Uint64
+	mismatched           atomic.Uint64
+	drained              atomic.Uint64
 }
 
 func NewSerial() (Serial, error) {
-	encoder, err := encoding.NewSerialEncoder()
+	return NewSerialForDialect(encoding.DefaultDialect)
+}
+
+func NewSerialForDialect(dialect encoding.Dialect) (Serial, error) {
+	return NewSerialForTransport(dialect, OpenTransport)
+}
+
+// NewSerialForTransport creates a Serial for the given dialect that opens its port using the given opener
+// (e.g. returning one end of a NewPipe connected to a simulated device)
+func NewSerialForTransport(dialect encoding.Dialect, opener TransportOpener) (Serial, error) {
+	encoder, err := encoding.NewSerialEncoderForDialect(dialect)
 	if err != nil {
 		return nil, err
 	}
 	return &serialCommunicator{
 		encoder:              encoder,
-		lowLevelSerialOpener: serial.Open,
+		lowLevelSerialOpener: opener,
 	}, nil
 }
 
-func (serialCommunicator *serialCommunicator) Open(portName string) error {
-	mode := &serial.Mode{
-		BaudRate: 9600,
-		Parity:   serial.EvenParity,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
+func (serialCommunicator *serialCommunicator) Open(options PortOptions) error {
+	if err := options.Validate(); err != nil {
+		return merry.Prepend(err, "Invalid port options")
 	}
+	options, err := options.resolve()
+	if err != nil {
+		return err
+	}
+	mode := options.mode()
+	portName := options.Name
 
 	log.WithFields(log.Fields{
 		"portName":   portName,
@@ -58,9 +163,13 @@ func (serialCommunicator *serialCommunicator) Open(portName string) error {
 		return merry.Prependf(err, "Failed to open serial connection for portName %s.", portName)
 	}
 
-	err = port.SetReadTimeout(20 * time.Millisecond)
+	if _, ok := port.(DirectionControl); options.RTS && !ok {
+		err = merry.Errorf("The transport of portName %s cannot control RTS.", portName)
+	} else if err = port.SetReadTimeout(options.ReadTimeout); err != nil {
+		err = merry.Prependf(err, "Failed to set the read timeout for portName %s.", portName)
+	}
 	if err != nil {
-		wrappedErr := merry.Prependf(err, "Failed to set the read timeout for portName %s.", portName)
+		wrappedErr := err
 		closeErr := port.Close()
 		if closeErr != nil {
 			return merry.Prependf(wrappedErr, "Failed to close port: %s; Tried to close port because", closeErr)
@@ -69,32 +178,76 @@ func (serialCommunicator *serialCommunicator) Open(portName string) error {
 	}
 
 	serialCommunicator.port = port
-	serialCommunicator.reader = bufio.NewReader(port)
+	serialCommunicator.options = options
+	serialCommunicator.reader = newFrameReader(port, serialCommunicator.encoder, options.ReadTimeout, options.ResponseTimeout)
 	return nil
 }
 
+// Close closes the port. Closing a port that is not open does nothing.
 func (serialCommunicator *serialCommunicator) Close() error {
 	log.Debug("Closing serial port")
-	if serialCommunicator.port != nil {
-		return serialCommunicator.port.Close()
+	port := serialCommunicator.port
+	if port == nil {
+		return nil
 	}
-	return nil
+	serialCommunicator.port = nil
+	serialCommunicator.reader = nil
+	return port.Close()
 }
 
 func (serialCommunicator *serialCommunicator) WriteFrame(data encoding.Frame) error {
 	if serialCommunicator.port == nil {
-		return merry.New("Serial port not yet opened.")
+		return merry.New("Serial port not yet opened.", merry.WithCause(RequestNotSentError))
 	}
-	dataStr, err := serialCommunicator.encoder.Encode(data)
+	dataBytes, err := serialCommunicator.encoder.AppendEncode(serialCommunicator.writeBuffer[:0], data)
 	if err != nil {
-		return err
+		return merry.Wrap(err, merry.WithCause(RequestNotSentError))
+	}
+
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("frame", data).Trace("Writing frame")
+	}
+
+	var written int
+	if serialCommunicator.options.RTS {
+		written, err = serialCommunicator.writeWithRTS(dataBytes)
+	} else {
+		written, err = serialCommunicator.port.Write(dataBytes)
+	}
+	if err != nil {
+		// Only a frame of which nothing has been written can't have reached the device
+		var wrappers []merry.Wrapper
+		if written == 0 {
+			wrappers = append(wrappers, merry.WithCause(RequestNotSentError))
+		}
+		return merry.Prepend(&portFailure{err: err, write: true}, fmt.Sprintf("Failed to send serial message: %s", dataBytes), wrappers...)
+	}
+	serialCommunicator.written = dataBytes
+	if serialCommunicator.observer != nil {
+		serialCommunicator.observe(TrafficEvent{Kind: FrameSent, Frame: data, Raw: string(dataBytes)})
 	}
-	dataBytes := []byte(dataStr)
+	return nil
+}
 
-	log.WithField("frame", data).Trace("Writing frame")
+// writeWithRTS asserts RTS, writes the given data and deasserts RTS once the data has been transmitted.
+// It returns the number of bytes written.
+func (serialCommunicator *serialCommunicator) writeWithRTS(data []byte) (int, error) {
+	port := serialCommunicator.port.(DirectionControl)
+	if err := port.SetRTS(true); err != nil {
+		return 0, merry.Prepend(err, "Failed to assert RTS")
+	}
+	time.Sleep(serialCommunicator.options.RTSPreDelay)
 
-	_, err = serialCommunicator.port.Write(dataBytes)
-	return merry.Prependf(err, "Failed to send serial message: %s", dataStr)
+	written, err := serialCommunicator.port.Write(data)
+	if err == nil {
+		err = merry.Prepend(port.Drain(), "Failed to wait for the transmission")
+	}
+
+	time.Sleep(serialCommunicator.options.RTSPostDelay)
+	if rtsErr := port.SetRTS(false); rtsErr != nil && err == nil {
+		err = merry.Prepend(rtsErr, "Failed to deassert RTS")
+	}
+	return written, err
 }
 
 func (serialCommunicator *serialCommunicator) ReadFrame() (encoding.Frame, error) {
@@ -102,31 +255,186 @@ func (serialCommunicator *serialCommunicator) ReadFrame() (encoding.Frame, error
 		return nil, merry.New("Serial port not yet opened.")
 	}
 
-	// The buffered reader implementation tries 100 times to read from the underlying reader
-	// This is not optimal.
-	// TODO: Implement a custom reader that reads until a delimiter is found with none/less retries
-	str, err := serialCommunicator.reader.ReadString(encoding.CHAR_CR)
+	// The slice is only valid until the next read. DecodeBytes does not retain it.
+	data, err := serialCommunicator.readRawFrame()
 	if err != nil {
 		var wrappers []merry.Wrapper
-
-		if len(str) == 0 {
-			if errors.Is(err, io.ErrNoProgress) {
-				wrappers = append(wrappers, merry.WithCause(NoDataOnSerialError))
-			}
+		if errors.Is(err, IncompleteFrameError) {
+			wrappers = append(wrappers, merry.WithCause(InvalidResponseError))
+		}
+		if len(data) == 0 {
 			return nil, merry.Prepend(err, "Failed to read from serial port", wrappers...)
 		}
-		return nil, merry.Prependf(err, "Failed to read full frame from serial port (Read='%s')", str)
+		return nil, merry.Prepend(err, fmt.Sprintf("Failed to read full frame from serial port (Read='%s')", encoding.DataWithEscapeChars(string(data))), wrappers...)
 	}
-	log.WithField("data", encoding.DataWithEscapeChars(str)).Trace("Read full frame")
-	return serialCommunicator.encoder.Decode(str)
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.WithField("data", encoding.DataWithEscapeChars(string(data))).Trace("Read full frame")
+	}
+	frame, err := serialCommunicator.encoder.DecodeBytes(data)
+	if err != nil && !errors.Is(err, encoding.FunctionRejectedError) {
+		return nil, merry.Prepend(err, "Failed to decode frame", merry.WithCause(InvalidResponseError))
+	}
+	if err == nil && serialCommunicator.observer != nil {
+		serialCommunicator.observe(TrafficEvent{Kind: FrameReceived, Frame: frame})
+	}
+	return frame, err
+}
+
+// readRawFrame reads the next frame from the port without decoding it
+func (serialCommunicator *serialCommunicator) readRawFrame() ([]byte, error) {
+	data, err := serialCommunicator.reader.ReadFrame()
+	if err == nil && serialCommunicator.observer != nil {
+		serialCommunicator.observe(TrafficEvent{Kind: RawReceived, Raw: string(data)})
+	}
+	return data, err
+}
+
+// SetValidator sets the validator requests are checked with before sending them.
+// Responses not passing the validator are logged. A nil validator disables the validation.
+func (serialCommunicator *serialCommunicator) SetValidator(validator encoding.FrameValidator) {
+	serialCommunicator.validator = validator
+}
+
+// SetObserver sets the observer receiving the traffic on the port. A nil observer disables the observation.
+// It must not be changed while a request is being sent.
+func (serialCommunicator *serialCommunicator) SetObserver(observer Observer) {
+	serialCommunicator.observer = observer
+}
+
+// observe passes the given event to the observer, which must not be nil
+func (serialCommunicator *serialCommunicator) observe(event TrafficEvent) {
+	event.Port = serialCommunicator.options.Name
+	event.Time = time.Now()
+	serialCommunicator.observer.Observe(event)
+}
+
+// SendRequest sends the given request and waits for the matching response.
+// The whole round trip is bounded by the given context. The error of the context is returned once it is done.
+// The complete response has to be received within the response timeout of the port (see PortOptions),
+// otherwise NoDataOnSerialError or, for a partial response, InvalidResponseError is returned.
+// Stale data received before sending the request is discarded.
+// Responses and rejections not matching the request (e.g. a late response to an earlier request) are discarded
+// until the matching one has been received. A ResponseMismatchError is returned if there are too many of them.
+func (serialCommunicator *serialCommunicator) SendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	response, err := serialCommunicator.sendRequest(ctx, data)
+	if err != nil && serialCommunicator.observer != nil {
+		serialCommunicator.observe(TrafficEvent{Kind: TrafficError, Frame: data, Err: err})
+	}
+	return response, err
 }
 
-func (serialCommunicator *serialCommunicator) SendRequest(data encoding.Frame) (encoding.Frame, error) {
+func (serialCommunicator *serialCommunicator) sendRequest(ctx context.Context, data encoding.Frame) (encoding.Frame, error) {
+	if serialCommunicator.validator != nil {
+		if err := serialCommunicator.validator.ValidateFrame(data); err != nil {
+			return nil, merry.Prepend(err, "Invalid request frame")
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, merry.Prepend(err, "Request cancelled before sending it")
+	}
+	if err := serialCommunicator.drainStaleInput(); err != nil {
+		return nil, merry.Wrap(err, merry.WithCause(RequestNotSentError))
+	}
 	if err := serialCommunicator.WriteFrame(data); err != nil {
+		// WriteFrame marks the error as RequestNotSentError if nothing has been written
 		return nil, merry.Prepend(err, "Failed to write request frame")
 	}
-	resp, err := serialCommunicator.ReadFrame()
-	return resp, merry.Prepend(err, "Failed to read response frame")
+	defer serialCommunicator.reader.expectResponse(ctx)()
+	if serialCommunicator.options.Echo {
+		if err := serialCommunicator.skipEcho(); err != nil {
+			return nil, err
+		}
+	}
+
+	for mismatches := 1; ; mismatches++ {
+		resp, err := serialCommunicator.ReadFrame()
+		mismatch := &ResponseMismatch{Request: data, Response: resp}
+
+		if errors.As(err, &mismatch.Rejection) {
+			if rejectionMatches(data, mismatch.Rejection) {
+				serialCommunicator.matched.Add(1)
+				return nil, merry.Prepend(err, "Failed to read response frame")
+			}
+		} else if err != nil {
+			return nil, merry.Prepend(err, "Failed to read response frame")
+		} else if ResponseMatches(data, resp) {
+			serialCommunicator.matched.Add(1)
+			if serialCommunicator.validator != nil {
+				if err := serialCommunicator.validator.ValidateFrame(resp); err != nil {
+					log.WithField("frame", resp).WithError(err).Warn("Received response that is not valid")
+				}
+			}
+			return resp, nil
+		}
+
+		serialCommunicator.mismatched.Add(1)
+		if mismatches >= maxMismatchedResponses {
+			return nil, merry.Wrap(mismatch)
+		}
+		log.WithError(mismatch).Warn("Discarding response not matching the request")
+		if serialCommunicator.observer != nil {
+			serialCommunicator.observe(TrafficEvent{Kind: TrafficError, Frame: data, Err: mismatch})
+		}
+	}
+}
+
+// ResponseMatches returns whether the given response is the response to the given request.
+// That is the case if it has the type answering the request and the address and function of the request.
+func ResponseMatches(request encoding.Frame, response encoding.Frame) bool {
+	if response == nil {
+		return false
+	}
+	expectedType := encoding.ReadResponse
+	if request.FrameType() == encoding.WriteRequest {
+		expectedType = encoding.WriteResponse
+	}
+	return response.FrameType() == expectedType && response.Address() == request.Address() && response.Function() == request.Function()
+}
+
+// rejectionMatches returns whether the given rejection is the response to the given request.
+// A rejection does not contain the function, so only address and type can be checked.
+func rejectionMatches(request encoding.Frame, rejection *encoding.FunctionRejection) bool {
+	return rejection.RequestType == request.FrameType() && rejection.Address == request.Address()
+}
+
+// skipEcho reads the local echo of the frame written last
+func (serialCommunicator *serialCommunicator) skipEcho() error {
+	echo, err := serialCommunicator.readRawFrame()
+	if err != nil {
+		return merry.Prepend(err, "Failed to read the echo of the request")
+	}
+	if !bytes.Equal(echo, serialCommunicator.written) {
+		message := fmt.Sprintf("Received '%s' as echo of '%s'",
+			encoding.DataWithEscapeChars(string(echo)), encoding.DataWithEscapeChars(string(serialCommunicator.written)))
+		return merry.Prepend(EchoMismatchError, message, merry.WithCause(InvalidResponseError))
+	}
+	return nil
+}
+
+// drainStaleInput discards all data received but not yet read (e.g. a late response to an earlier request)
+func (serialCommunicator *serialCommunicator) drainStaleInput() error {
+	if serialCommunicator.reader == nil {
+		// WriteFrame will report the port as not yet opened
+		return nil
+	}
+	if stale := serialCommunicator.reader.Buffered(); len(stale) > 0 {
+		log.WithField("data", encoding.DataWithEscapeChars(string(stale))).Debug("Discarding stale data")
+		serialCommunicator.reader.Discard()
+		serialCommunicator.drained.Add(1)
+	}
+	if err := serialCommunicator.port.ResetInputBuffer(); err != nil {
+		return merry.Prepend(&portFailure{err: err}, "Failed to discard stale data")
+	}
+	return nil
+}
+
+// CorrelationCounters returns how the responses received so far matched their requests
+func (serialCommunicator *serialCommunicator) CorrelationCounters() CorrelationCounters {
+	return CorrelationCounters{
+		Matched:    serialCommunicator.matched.Load(),
+		Mismatched: serialCommunicator.mismatched.Load(),
+		Drained:    serialCommunicator.drained.Load(),
+	}
 }
 
 func (serialCommunicator *serialCommunicator) markAsValidSerial() { /*Intentionally empty*/ }
@@ -1,6 +1,8 @@
 package serial
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -19,12 +21,22 @@ type testSerialPort struct {
 	failOnClose          bool
 	failOnWrite          bool
 	failOnRead           bool
+	failOnResetInput     bool
 	failOnReadButStart   bool
 	readTimeout          time.Duration
 	hasBeenClosed        bool
 	written              []byte
 	readData             []byte
 	readOffset           int
+	inputResets          int
+	emptyReadDelay       time.Duration
+	echo                 string
+	rts                  bool
+	events               []string
+	failOnSetRTS         bool
+	failOnDeassertRTS    bool
+	failOnDrain          bool
+	partialWrite         int
 }
 
 func (sp *testSerialPort) Read(p []byte) (n int, err error) {
@@ -39,17 +51,44 @@ func (sp *testSerialPort) Read(p []byte) (n int, err error) {
 		return 0, fmt.Errorf("Some Read failure")
 	}
 	dataLeft := len(sp.readData) - sp.readOffset
+	if dataLeft == 0 {
+		// Like a real port waiting for its read timeout
+		if sp.emptyReadDelay > 0 {
+			time.Sleep(sp.emptyReadDelay)
+		} else {
+			time.Sleep(sp.readTimeout)
+		}
+	}
 	toRead := dataLeft
 	if len(p) < toRead {
 		toRead = len(p)
 	}
 
-	return copy(p, sp.readData[sp.readOffset:sp.readOffset+toRead]), nil
+	n = copy(p, sp.readData[sp.readOffset:sp.readOffset+toRead])
+	sp.readOffset += n
+	return n, nil
+}
+func (sp *testSerialPort) ResetInputBuffer() error {
+	sp.inputResets++
+	if sp.failOnResetInput {
+		return fmt.Errorf("Some ResetInputBuffer failure")
+	}
+	return nil
 }
 func (sp *testSerialPort) Write(p []byte) (n int, err error) {
 	if sp.failOnWrite {
 		return 0, fmt.Errorf("Some Write failure")
 	}
+	if sp.partialWrite > 0 {
+		sp.written = append(sp.written, p[:sp.partialWrite]...)
+		return sp.partialWrite, fmt.Errorf("Some Write failure")
+	}
+	sp.events = append(sp.events, fmt.Sprintf("write(rts=%t)", sp.rts))
+	// The echo precedes the response
+	if sp.echo != "" {
+		sp.readData = append([]byte(sp.echo), sp.readData[sp.readOffset:]...)
+		sp.readOffset = 0
+	}
 	toWrite := len(p)
 
 	newWritten := make([]byte, len(sp.written), cap(sp.written)+toWrite)
@@ -59,6 +98,21 @@ func (sp *testSerialPort) Write(p []byte) (n int, err error) {
 
 	return toWrite, nil
 }
+func (sp *testSerialPort) SetRTS(rts bool) error {
+	if sp.failOnSetRTS || (sp.failOnDeassertRTS && !rts) {
+		return fmt.Errorf("Some SetRTS failure")
+	}
+	sp.rts = rts
+	sp.events = append(sp.events, fmt.Sprintf("rts=%t", rts))
+	return nil
+}
+func (sp *testSerialPort) Drain() error {
+	sp.events = append(sp.events, "drain")
+	if sp.failOnDrain {
+		return fmt.Errorf("Some Drain failure")
+	}
+	return nil
+}
 func (sp *testSerialPort) SetReadTimeout(t time.Duration) error {
 	sp.readTimeout = t
 	if sp.failOnSetReadTimeout {
@@ -80,10 +134,38 @@ func (se *testSerialEncoder) Encode(frame encoding.Frame) (string, error) {
 	return "", fmt.Errorf("Some Encode failure")
 }
 
+func (se *testSerialEncoder) AppendEncode(dst []byte, frame encoding.Frame) ([]byte, error) {
+	return dst, fmt.Errorf("Some Encode failure")
+}
+
+func (se *testSerialEncoder) EncodeInvalidFunctionResponse(request encoding.Frame) (string, error) {
+	return "", fmt.Errorf("Some Encode failure")
+}
+
 func (se *testSerialEncoder) Decode(data string) (encoding.Frame, error) {
 	return nil, fmt.Errorf("Some Decode failure")
 }
 
+func (se *testSerialEncoder) DecodeBytes(data []byte) (encoding.Frame, error) {
+	return nil, fmt.Errorf("Some Decode failure")
+}
+
+func (se *testSerialEncoder) DecodeBytesInto(data []byte, dst *encoding.DecodedFrame) error {
+	return fmt.Errorf("Some Decode failure")
+}
+
+func (se *testSerialEncoder) DecodeRequest(data string) (encoding.Frame, error) {
+	return nil, fmt.Errorf("Some Decode failure")
+}
+
+func (se *testSerialEncoder) DecodeRequestBytes(data []byte) (encoding.Frame, error) {
+	return nil, fmt.Errorf("Some Decode failure")
+}
+
+func (se *testSerialEncoder) Dialect() encoding.Dialect {
+	return encoding.DefaultDialect
+}
+
 func TestNewSerial(t *testing.T) {
 	serialInterface, err := NewSerial()
 	must.NoError(t, err)
@@ -97,6 +179,43 @@ func TestNewSerial(t *testing.T) {
 	test.NotNil(t, serialCommunicator.lowLevelSerialOpener)
 }
 
+func TestNewSerialForDialect(t *testing.T) {
+	dialect := encoding.DefaultDialect
+	dialect.Name = "serialTest"
+	dialect.EndChar = '!'
+
+	serialInterface, err := NewSerialForDialect(dialect)
+	must.NoError(t, err)
+
+	serialCommunicator, ok := serialInterface.(*serialCommunicator)
+	if !ok {
+		t.Error("Returned serial interface is not a serial communicator")
+	}
+
+	test.Eq(t, dialect, serialCommunicator.encoder.Dialect())
+
+	testSp := &testSerialPort{
+		readData: []byte("\n111lW#222333!"),
+	}
+	serialCommunicator.lowLevelSerialOpener =
+		func(portName string, mode *serial.Mode) (Transport, error) {
+			return testSp, nil
+		}
+	must.NoError(t, serialInterface.Open(DefaultPortOptions(PORT_NAME)))
+
+	frame, err := serialCommunicator.ReadFrame()
+	must.NoError(t, err)
+	test.Eq(t, 111, frame.Address())
+}
+
+func TestNewSerialForInvalidDialect(t *testing.T) {
+	dialect := encoding.DefaultDialect
+	dialect.EndChar = dialect.StartChar
+
+	_, err := NewSerialForDialect(dialect)
+	test.Error(t, err)
+}
+
 func TestOpenGood(t *testing.T) {
 	serialInterface, err := NewSerial()
 	must.NoError(t, err)
@@ -106,7 +225,7 @@ func TestOpenGood(t *testing.T) {
 	}
 	testSp := &testSerialPort{}
 	serialCommunicator.lowLevelSerialOpener =
-		func(portName string, mode *serial.Mode) (serial.Port, error) {
+		func(portName string, mode *serial.Mode) (Transport, error) {
 			test.Eq(t, PORT_NAME, portName)
 			test.Eq(t, 9600, mode.BaudRate)
 			test.Eq(t, serial.EvenParity, mode.Parity)
@@ -114,10 +233,49 @@ func TestOpenGood(t *testing.T) {
 			return testSp, nil
 		}
 
-	err = serialInterface.Open(PORT_NAME)
+	err = serialInterface.Open(DefaultPortOptions(PORT_NAME))
 	test.NoError(t, err)
 	test.Eq(t, 20*time.Millisecond, testSp.readTimeout)
-	test.Eq[serial.Port](t, testSp, serialCommunicator.port)
+	test.Eq[Transport](t, testSp, serialCommunicator.port)
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	serialInterface, err := NewSerial()
+	must.NoError(t, err)
+	serialCommunicator, ok := serialInterface.(*serialCommunicator)
+	must.True(t, ok)
+	testSp := &testSerialPort{}
+	serialCommunicator.lowLevelSerialOpener =
+		func(portName string, mode *serial.Mode) (Transport, error) {
+			test.Eq(t, PORT_NAME, portName)
+			test.Eq(t, 19200, mode.BaudRate)
+			test.Eq(t, serial.NoParity, mode.Parity)
+			test.Eq(t, 7, mode.DataBits)
+			test.Eq(t, serial.TwoStopBits, mode.StopBits)
+			return testSp, nil
+		}
+
+	options := PortOptions{Name: PORT_NAME, BaudRate: 19200, DataBits: 7, Parity: serial.NoParity, StopBits: serial.TwoStopBits, ReadTimeout: 50 * time.Millisecond, ResponseTimeout: time.Second}
+	err = serialInterface.Open(options)
+	test.NoError(t, err)
+	test.Eq(t, 50*time.Millisecond, testSp.readTimeout)
+}
+
+func TestOpenWithInvalidOptions(t *testing.T) {
+	serialInterface, err := NewSerial()
+	must.NoError(t, err)
+	serialCommunicator, ok := serialInterface.(*serialCommunicator)
+	must.True(t, ok)
+	serialCommunicator.lowLevelSerialOpener =
+		func(portName string, mode *serial.Mode) (Transport, error) {
+			t.Error("The port must not be opened with invalid options")
+			return nil, nil
+		}
+
+	options := DefaultPortOptions(PORT_NAME)
+	options.BaudRate = 0
+	err = serialInterface.Open(options)
+	test.ErrorContains(t, err, "Invalid port options")
 }
 
 func TestOpenErrorOnOpen(t *testing.T) {
@@ -128,11 +286,11 @@ func TestOpenErrorOnOpen(t *testing.T) {
 		t.Error("Returned serial interface is not a serial communicator")
 	}
 	serialCommunicator.lowLevelSerialOpener =
-		func(portName string, mode *serial.Mode) (serial.Port, error) {
+		func(portName string, mode *serial.Mode) (Transport, error) {
 			return nil, fmt.Errorf("Some Open failure")
 		}
 
-	err = serialInterface.Open(PORT_NAME)
+	err = serialInterface.Open(DefaultPortOptions(PORT_NAME))
 	test.ErrorContains(t, err, "Some Open failure")
 }
 
@@ -147,11 +305,11 @@ func TestOpenErrorOnSetReadTimeout(t *testing.T) {
 		failOnSetReadTimeout: true,
 	}
 	serialCommunicator.lowLevelSerialOpener =
-		func(portName string, mode *serial.Mode) (serial.Port, error) {
+		func(portName string, mode *serial.Mode) (Transport, error) {
 			return testSp, nil
 		}
 
-	err = serialInterface.Open(PORT_NAME)
+	err = serialInterface.Open(DefaultPortOptions(PORT_NAME))
 	test.ErrorContains(t, err, "Some SetReadTimeout failure")
 	test.True(t, testSp.hasBeenClosed)
 }
@@ -168,11 +326,11 @@ func TestOpenErrorOnSetReadTimeoutAndErrorOnClose(t *testing.T) {
 		failOnClose:          true,
 	}
 	serialCommunicator.lowLevelSerialOpener =
-		func(portName string, mode *serial.Mode) (serial.Port, error) {
+		func(portName string, mode *serial.Mode) (Transport, error) {
 			return testSp, nil
 		}
 
-	err = serialInterface.Open(PORT_NAME)
+	err = serialInterface.Open(DefaultPortOptions(PORT_NAME))
 	errstr := fmt.Sprintf("%s", err)
 	fmt.Print(errstr)
 	test.ErrorContains(t, err, "Some SetReadTimeout failure")
@@ -187,12 +345,12 @@ func setupWorkingCommunicator(t *testing.T, testSp *testSerialPort, open bool) *
 		t.Error("Returned serial interface is not a serial communicator")
 	}
 	serialCommunicator.lowLevelSerialOpener =
-		func(portName string, mode *serial.Mode) (serial.Port, error) {
+		func(portName string, mode *serial.Mode) (Transport, error) {
 			return testSp, nil
 		}
 
 	if open {
-		err = serialInterface.Open(PORT_NAME)
+		err = serialInterface.Open(DefaultPortOptions(PORT_NAME))
 		must.NoError(t, err)
 	}
 	return serialCommunicator
@@ -296,9 +454,14 @@ func TestReadFrameNoData(t *testing.T) {
 	testSp := &testSerialPort{}
 	serial := setupWorkingCommunicator(t, testSp, true)
 
+	start := time.Now()
 	_, err := serial.ReadFrame()
 
+	// Gives up after the response timeout instead of after a number of empty reads
+	test.Less(t, DEFAULT_RESPONSE_TIMEOUT+DEFAULT_READ_TIMEOUT, time.Since(start))
+
 	test.ErrorIs(t, err, NoDataOnSerialError)
+	test.False(t, errors.Is(err, PortFailureError))
 	test.Eq(t, 0, testSp.readOffset)
 }
 
@@ -324,6 +487,7 @@ func TestReadFrameDecodingFails(t *testing.T) {
 	_, err := serial.ReadFrame()
 
 	test.ErrorContains(t, err, "Some Decode failure")
+	test.ErrorIs(t, err, InvalidResponseError)
 }
 
 func TestSendRequestGood(t *testing.T) {
@@ -332,10 +496,10 @@ func TestSendRequestGood(t *testing.T) {
 	}
 	serial := setupWorkingCommunicator(t, testSp, true)
 
-	req, err := encoding.NewReadRequest(100, 100)
+	req, err := encoding.NewReadRequest(111, 222)
 	must.NoError(t, err)
 
-	resp, err := serial.SendRequest(req)
+	resp, err := serial.SendRequest(context.Background(), req)
 
 	test.NoError(t, err)
 	test.Eq(t, encoding.ReadResponse, resp.FrameType())
@@ -354,9 +518,11 @@ func TestSendRequestWriteFails(t *testing.T) {
 	req, err := encoding.NewReadRequest(100, 100)
 	must.NoError(t, err)
 
-	_, err = serial.SendRequest(req)
+	_, err = serial.SendRequest(context.Background(), req)
 
 	test.ErrorContains(t, err, "Some Write failure")
+	test.ErrorIs(t, err, RequestNotSentError)
+	test.ErrorIs(t, err, PortFailureError)
 }
 
 func TestSendRequestReadFails(t *testing.T) {
@@ -369,9 +535,11 @@ func TestSendRequestReadFails(t *testing.T) {
 	req, err := encoding.NewReadRequest(100, 100)
 	must.NoError(t, err)
 
-	_, err = serial.SendRequest(req)
+	_, err = serial.SendRequest(context.Background(), req)
 
 	test.ErrorContains(t, err, "Some Read failure")
+	test.ErrorIs(t, err, PortFailureError)
+	test.False(t, errors.Is(err, RequestNotSentError))
 }
 
 func TestCloseGood(t *testing.T) {
@@ -384,6 +552,17 @@ func TestCloseGood(t *testing.T) {
 	test.Eq(t, true, testSp.hasBeenClosed)
 }
 
+func TestCloseTwice(t *testing.T) {
+	testSp := &testSerialPort{}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	must.NoError(t, serial.Close())
+	test.NoError(t, serial.Close())
+
+	err := serial.WriteFrame(nil)
+	test.ErrorContains(t, err, "Serial port not yet opened")
+}
+
 func TestCloseWithoutOpen(t *testing.T) {
 	testSp := &testSerialPort{}
 	serial := setupWorkingCommunicator(t, testSp, false)
@@ -404,3 +583,300 @@ func TestCloseError(t *testing.T) {
 
 	test.ErrorContains(t, err, "Some Close failure")
 }
+
+func TestSendRequestFunctionRejected(t *testing.T) {
+	testSp := &testSerialPort{
+		readData: []byte("\n100lW#?\r"),
+	}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	req, err := encoding.NewReadRequest(100, 100)
+	must.NoError(t, err)
+
+	_, err = serial.SendRequest(context.Background(), req)
+
+	test.ErrorIs(t, err, encoding.FunctionRejectedError)
+	test.False(t, errors.Is(err, NoDataOnSerialError))
+	test.False(t, errors.Is(err, InvalidResponseError))
+
+	var rejection *encoding.FunctionRejection
+	must.True(t, errors.As(err, &rejection))
+	test.EqOp(t, 100, rejection.Address)
+	test.EqOp(t, encoding.ReadRequest, rejection.RequestType)
+}
+
+// testValidator rejects all frames of the given type
+type testValidator struct {
+	invalidType encoding.FrameType
+}
+
+func (v *testValidator) ValidateFrame(frame encoding.Frame) error {
+	if frame.FrameType() == v.invalidType {
+		return fmt.Errorf("Some validation failure")
+	}
+	return nil
+}
+
+func TestSendRequestValidation(t *testing.T) {
+	testSp := &testSerialPort{}
+	serial := setupWorkingCommunicator(t, testSp, true)
+	serial.SetValidator(&testValidator{invalidType: encoding.ReadRequest})
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	_, err = serial.SendRequest(context.Background(), req)
+	test.ErrorContains(t, err, "Some validation failure")
+	test.SliceEmpty(t, testSp.written)
+
+	// An invalid response is only logged
+	testSp.readData = []byte("\n111lW#222333\r")
+	serial.SetValidator(&testValidator{invalidType: encoding.ReadResponse})
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 333, resp.Value())
+
+	testSp.readData, testSp.readOffset = []byte("\n111lW#222333\r"), 0
+	serial.SetValidator(nil)
+	_, err = serial.SendRequest(context.Background(), req)
+	test.NoError(t, err)
+}
+
+func TestSendRequestDiscardsMismatchedResponses(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{"other address", "\n112lW#222333\r"},
+		{"other function", "\n111lW#223333\r"},
+		{"other type", "\n111sW#222333\r"},
+		{"rejection of other address", "\n112lW#?\r"},
+		{"rejection of other type", "\n111sW#?\r"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testSp := &testSerialPort{readData: []byte(tc.data + "\n111lW#222333\r")}
+			serial := setupWorkingCommunicator(t, testSp, true)
+
+			req, err := encoding.NewReadRequest(111, 222)
+			must.NoError(t, err)
+
+			resp, err := serial.SendRequest(context.Background(), req)
+			must.NoError(t, err)
+			test.EqOp(t, 111, resp.Address())
+			test.EqOp(t, 222, resp.Function())
+			test.EqOp(t, 333, resp.Value())
+			test.Eq(t, CorrelationCounters{Matched: 1, Mismatched: 1}, serial.CorrelationCounters())
+		})
+	}
+}
+
+func TestResponseMatches(t *testing.T) {
+	readRequest, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+	writeRequest, err := encoding.NewWriteRequest(111, 222, 333)
+	must.NoError(t, err)
+	readResponse, err := encoding.NewReadResponse(111, 222, 444)
+	must.NoError(t, err)
+	writeResponse, err := encoding.NewWriteResponse(111, 222, 333)
+	must.NoError(t, err)
+	otherAddress, err := encoding.NewReadResponse(112, 222, 444)
+	must.NoError(t, err)
+	otherFunction, err := encoding.NewReadResponse(111, 223, 444)
+	must.NoError(t, err)
+
+	test.True(t, ResponseMatches(readRequest, readResponse))
+	test.True(t, ResponseMatches(writeRequest, writeResponse))
+	test.False(t, ResponseMatches(readRequest, writeResponse))
+	test.False(t, ResponseMatches(writeRequest, readResponse))
+	test.False(t, ResponseMatches(readRequest, otherAddress))
+	test.False(t, ResponseMatches(readRequest, otherFunction))
+	test.False(t, ResponseMatches(readRequest, readRequest))
+	test.False(t, ResponseMatches(readRequest, nil))
+}
+
+func TestSendRequestTooManyMismatchedResponses(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n112lW#222333\r\n113lW#222333\r\n114lW#?\r\n111lW#222333\r")}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	_, err = serial.SendRequest(context.Background(), req)
+	test.ErrorIs(t, err, ResponseMismatchError)
+	var mismatch *ResponseMismatch
+	must.True(t, errors.As(err, &mismatch))
+	test.Eq(t, req, mismatch.Request)
+	test.Nil(t, mismatch.Response)
+	must.NotNil(t, mismatch.Rejection)
+	test.EqOp(t, 114, mismatch.Rejection.Address)
+	test.Eq(t, CorrelationCounters{Mismatched: maxMismatchedResponses}, serial.CorrelationCounters())
+}
+
+func TestSendRequestDrainsStaleData(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#222333\r\n111lW#222444\r")}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 333, resp.Value())
+	test.EqOp(t, 1, testSp.inputResets)
+
+	// The second response is a stale duplicate and has been read into the buffer with the first one
+	testSp.readData, testSp.readOffset = []byte("\n111lW#222555\r"), 0
+	resp, err = serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 555, resp.Value())
+	test.EqOp(t, 2, testSp.inputResets)
+	test.Eq(t, CorrelationCounters{Matched: 2, Drained: 1}, serial.CorrelationCounters())
+
+	testSp.failOnResetInput = true
+	_, err = serial.SendRequest(context.Background(), req)
+	test.ErrorContains(t, err, "Some ResetInputBuffer failure")
+}
+
+func TestSendRequestCancelledBeforeSending(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#222333\r")}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = serial.SendRequest(ctx, req)
+	test.ErrorIs(t, err, context.Canceled)
+	test.SliceEmpty(t, testSp.written)
+}
+
+func TestSendRequestDeadline(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#"), emptyReadDelay: 5 * time.Millisecond}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = serial.SendRequest(ctx, req)
+	test.ErrorIs(t, err, context.DeadlineExceeded)
+	// The context ends the request before the response timeout
+	test.Less(t, DEFAULT_RESPONSE_TIMEOUT, time.Since(start))
+
+	// The context only bounds a single request and the partial frame is discarded as stale data
+	testSp.readData, testSp.readOffset = []byte("\n111lW#222333\r"), 0
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 333, resp.Value())
+}
+
+func setupRS485Communicator(t *testing.T, testSp *testSerialPort, options PortOptions) *serialCommunicator {
+	serial := setupWorkingCommunicator(t, testSp, false)
+	must.NoError(t, serial.Open(options))
+	return serial
+}
+
+func TestSendRequestRTS(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#222333\r")}
+	options := DefaultPortOptions(PORT_NAME)
+	options.RTS = true
+	options.RTSPreDelay = time.Millisecond
+	options.RTSPostDelay = time.Millisecond
+	serial := setupRS485Communicator(t, testSp, options)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 333, resp.Value())
+	test.Eq(t, []string{"rts=true", "write(rts=true)", "drain", "rts=false"}, testSp.events)
+	test.False(t, testSp.rts)
+
+	testSp.failOnSetRTS = true
+	_, err = serial.SendRequest(context.Background(), req)
+	test.ErrorContains(t, err, "Failed to assert RTS")
+	test.ErrorIs(t, err, RequestNotSentError)
+}
+
+func TestSendRequestRTSFailsAfterWriting(t *testing.T) {
+	testCases := []struct {
+		name    string
+		port    *testSerialPort
+		message string
+	}{
+		{"drain", &testSerialPort{failOnDrain: true}, "Failed to wait for the transmission"},
+		{"deassert", &testSerialPort{failOnDeassertRTS: true}, "Failed to deassert RTS"},
+		{"partial write", &testSerialPort{partialWrite: 3}, "Some Write failure"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := DefaultPortOptions(PORT_NAME)
+			options.RTS = true
+			serial := setupRS485Communicator(t, tc.port, options)
+
+			req, err := encoding.NewReadRequest(111, 222)
+			must.NoError(t, err)
+
+			_, err = serial.SendRequest(context.Background(), req)
+			test.ErrorContains(t, err, tc.message)
+			test.ErrorIs(t, err, PortFailureError)
+			test.ErrorIs(t, err, WriteFailedError)
+			test.False(t, errors.Is(err, RequestNotSentError))
+		})
+	}
+}
+
+func TestSendRequestWithoutRTS(t *testing.T) {
+	testSp := &testSerialPort{readData: []byte("\n111lW#222333\r")}
+	serial := setupWorkingCommunicator(t, testSp, true)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	_, err = serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.Eq(t, []string{"write(rts=false)"}, testSp.events)
+}
+
+func TestSendRequestEcho(t *testing.T) {
+	testCases := []struct {
+		name      string
+		echo      string
+		response  string
+		expectErr error
+	}{
+		{"echo stripped", "\n111lW222\r", "\n111lW#222333\r", nil},
+		{"garbled echo", "\n111lW223\r", "\n111lW#222333\r", EchoMismatchError},
+		{"missing echo", "", "", NoDataOnSerialError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testSp := &testSerialPort{readData: []byte(tc.response), echo: tc.echo}
+			options := DefaultPortOptions(PORT_NAME)
+			options.Echo = true
+			options.ResponseTimeout = 20 * time.Millisecond
+			serial := setupRS485Communicator(t, testSp, options)
+
+			req, err := encoding.NewReadRequest(111, 222)
+			must.NoError(t, err)
+
+			resp, err := serial.SendRequest(context.Background(), req)
+			if tc.expectErr != nil {
+				test.ErrorIs(t, err, tc.expectErr)
+				test.False(t, errors.Is(err, RequestNotSentError))
+				return
+			}
+			must.NoError(t, err)
+			test.EqOp(t, 333, resp.Value())
+			test.Eq(t, CorrelationCounters{Matched: 1}, serial.CorrelationCounters())
+		})
+	}
+}
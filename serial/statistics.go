@@ -0,0 +1,176 @@
+package serial
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// DefaultLatencyBounds are the upper bounds of the buckets of the latency histogram
+var DefaultLatencyBounds = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram counts round trip latencies in buckets
+type LatencyHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets in ascending order
+	Bounds []time.Duration
+	// Counts are the number of latencies in each bucket.
+	// It has one more element than Bounds counting the latencies above the last bound.
+	Counts []uint64
+	// Count is the number of latencies recorded
+	Count uint64
+	// Sum is the sum of all latencies recorded
+	Sum time.Duration
+	// Max is the largest latency recorded
+	Max time.Duration
+}
+
+func newLatencyHistogram(bounds []time.Duration) LatencyHistogram {
+	return LatencyHistogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+// record adds the given latency to the histogram
+func (histogram *LatencyHistogram) record(latency time.Duration) {
+	bucket := len(histogram.Bounds)
+	for i, bound := range histogram.Bounds {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	histogram.Counts[bucket]++
+	histogram.Count++
+	histogram.Sum += latency
+	histogram.Max = max(histogram.Max, latency)
+}
+
+// Mean returns the mean of the latencies recorded or 0 if there are none
+func (histogram LatencyHistogram) Mean() time.Duration {
+	if histogram.Count == 0 {
+		return 0
+	}
+	return histogram.Sum / time.Duration(histogram.Count)
+}
+
+// Quantile returns the upper bound of the bucket containing the given quantile (between 0 and 1) of the latencies.
+// It returns Max if the quantile is above the last bound and 0 if no latencies have been recorded.
+func (histogram LatencyHistogram) Quantile(quantile float64) time.Duration {
+	if histogram.Count == 0 {
+		return 0
+	}
+	rank := max(uint64(math.Ceil(quantile*float64(histogram.Count))), 1)
+	var seen uint64
+	for i, count := range histogram.Counts[:len(histogram.Bounds)] {
+		seen += count
+		if seen >= rank {
+			return histogram.Bounds[i]
+		}
+	}
+	return histogram.Max
+}
+
+// Statistics describe the health of a bus.
+// Requests and their results are counted once, the error classes for each failed attempt.
+type Statistics struct {
+	// Port is the name of the port
+	Port string
+	// State is the state of the connection to the port
+	State ConnectionState
+	// QueueDepth is the number of requests waiting to be sent
+	QueueDepth int
+
+	// Requests is the number of requests handled
+	Requests uint64
+	// Successes is the number of requests that received a response
+	Successes uint64
+	// Failures is the number of requests that failed after all attempts
+	Failures uint64
+	// Attempts is the number of times requests have been sent including retries
+	Attempts uint64
+
+	// Timeouts is the number of attempts without a response (NoDataOnSerialError)
+	Timeouts uint64
+	// DecodeFailures is the number of attempts whose response could not be decoded (InvalidResponseError)
+	DecodeFailures uint64
+	// Rejections is the number of attempts the device rejected (encoding.FunctionRejectedError)
+	Rejections uint64
+	// Mismatches is the number of attempts that only received responses to other requests (ResponseMismatchError)
+	Mismatches uint64
+	// WriteErrors is the number of attempts that could not be sent or failed while writing them to the port
+	// (RequestNotSentError or WriteFailedError)
+	WriteErrors uint64
+	// OtherErrors is the number of attempts that failed for other reasons (e.g. a cancelled context)
+	OtherErrors uint64
+
+	// Latency is the histogram of the round trip times of the attempts the device responded to
+	Latency LatencyHistogram
+	// Correlation describes how the responses matched their requests
+	Correlation CorrelationCounters
+}
+
+// statistics collects the Statistics of a manager. It is safe for concurrent use.
+type statistics struct {
+	mutex   sync.Mutex
+	current Statistics
+}
+
+func newStatistics(port string) *statistics {
+	return &statistics{current: Statistics{Port: port, Latency: newLatencyHistogram(DefaultLatencyBounds)}}
+}
+
+// recordAttempt records the result of sending a request once
+func (stats *statistics) recordAttempt(err error, latency time.Duration) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	current := &stats.current
+	current.Attempts++
+	switch {
+	case err == nil:
+		current.Latency.record(latency)
+	case errors.Is(err, RequestNotSentError) || errors.Is(err, WriteFailedError):
+		current.WriteErrors++
+	case errors.Is(err, NoDataOnSerialError):
+		current.Timeouts++
+	case errors.Is(err, encoding.FunctionRejectedError):
+		current.Rejections++
+		current.Latency.record(latency)
+	case errors.Is(err, ResponseMismatchError):
+		current.Mismatches++
+	case errors.Is(err, InvalidResponseError):
+		current.DecodeFailures++
+	default:
+		current.OtherErrors++
+	}
+}
+
+// recordRequest records the final result of a request
+func (stats *statistics) recordRequest(err error) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.current.Requests++
+	if err == nil {
+		stats.current.Successes++
+	} else {
+		stats.current.Failures++
+	}
+}
+
+// snapshot returns a copy of the statistics collected
+func (stats *statistics) snapshot() Statistics {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	snapshot := stats.current
+	snapshot.Latency.Counts = append([]uint64(nil), stats.current.Latency.Counts...)
+	return snapshot
+}
@@ -0,0 +1,137 @@
+package serial
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"go.bug.st/serial"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	histogram := newLatencyHistogram([]time.Duration{10 * time.Millisecond, 100 * time.Millisecond})
+	test.EqOp(t, 0, histogram.Mean())
+	test.EqOp(t, 0, histogram.Quantile(0.5))
+
+	for _, latency := range []time.Duration{2 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond, 300 * time.Millisecond} {
+		histogram.record(latency)
+	}
+	test.Eq(t, []uint64{2, 1, 1}, histogram.Counts)
+	test.EqOp(t, 4, histogram.Count)
+	test.EqOp(t, 362*time.Millisecond, histogram.Sum)
+	test.EqOp(t, 300*time.Millisecond, histogram.Max)
+	test.EqOp(t, 90500*time.Microsecond, histogram.Mean())
+	test.EqOp(t, 10*time.Millisecond, histogram.Quantile(0))
+	test.EqOp(t, 10*time.Millisecond, histogram.Quantile(0.5))
+	test.EqOp(t, 100*time.Millisecond, histogram.Quantile(0.75))
+	test.EqOp(t, 300*time.Millisecond, histogram.Quantile(0.99))
+}
+
+func TestStatisticsRecordAttempt(t *testing.T) {
+	stats := newStatistics("bus1")
+	stats.recordAttempt(nil, time.Millisecond)
+	stats.recordAttempt(merry.Prepend(NoDataOnSerialError, "Timed out"), 0)
+	stats.recordAttempt(merry.Wrap(merry.New("Foo"), merry.WithCause(InvalidResponseError)), 0)
+	stats.recordAttempt(merry.Wrap(encoding.FunctionRejectedError), 2*time.Millisecond)
+	stats.recordAttempt(merry.Wrap(ResponseMismatchError), 0)
+	stats.recordAttempt(merry.Wrap(merry.New("Foo"), merry.WithCause(RequestNotSentError)), 0)
+	stats.recordAttempt(merry.Prepend(&portFailure{err: merry.New("Foo"), write: true}, "Partially written"), 0)
+	stats.recordAttempt(context.Canceled, 0)
+	stats.recordRequest(nil)
+	stats.recordRequest(NoDataOnSerialError)
+
+	snapshot := stats.snapshot()
+	test.EqOp(t, "bus1", snapshot.Port)
+	test.EqOp(t, 2, snapshot.Requests)
+	test.EqOp(t, 1, snapshot.Successes)
+	test.EqOp(t, 1, snapshot.Failures)
+	test.EqOp(t, 8, snapshot.Attempts)
+	test.EqOp(t, 1, snapshot.Timeouts)
+	test.EqOp(t, 1, snapshot.DecodeFailures)
+	test.EqOp(t, 1, snapshot.Rejections)
+	test.EqOp(t, 1, snapshot.Mismatches)
+	test.EqOp(t, 2, snapshot.WriteErrors)
+	test.EqOp(t, 1, snapshot.OtherErrors)
+	test.EqOp(t, 2, snapshot.Latency.Count)
+	test.EqOp(t, 3*time.Millisecond, snapshot.Latency.Sum)
+
+	// The snapshot is not changed by later attempts
+	stats.recordAttempt(nil, time.Millisecond)
+	test.EqOp(t, 2, snapshot.Latency.Counts[0])
+}
+
+func TestManagerStatistics(t *testing.T) {
+	manager := startSimulatedBus(t, "bus1", map[int]bool{3: false, 5: true})
+
+	_, err := manager.Scan(context.Background(), ScanOptions{First: 3, Last: 7, Timeout: 50 * time.Millisecond})
+	must.NoError(t, err)
+
+	statistics := manager.Statistics()
+	test.EqOp(t, "bus1", statistics.Port)
+	test.EqOp(t, Connected, statistics.State)
+	test.EqOp(t, 5, statistics.Requests)
+	test.EqOp(t, 1, statistics.Successes)
+	test.EqOp(t, 4, statistics.Failures)
+	test.EqOp(t, 5, statistics.Attempts)
+	test.EqOp(t, 3, statistics.Timeouts)
+	test.EqOp(t, 1, statistics.Rejections)
+	test.EqOp(t, 2, statistics.Latency.Count)
+	test.Positive(t, statistics.Correlation.Matched)
+}
+
+func TestManagerStatisticsWriteErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		port *testSerialPort
+	}{
+		{"nothing written", &testSerialPort{failOnWrite: true}},
+		{"partial write", &testSerialPort{partialWrite: 3}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{
+				Port: DefaultPortOptions("bus1"),
+				Transport: func(portName string, mode *serial.Mode) (Transport, error) {
+					return tc.port, nil
+				},
+			})
+			must.NoError(t, err)
+			must.NoError(t, manager.Start())
+			defer manager.Stop()
+
+			req, err := encoding.NewReadRequest(1, 2)
+			must.NoError(t, err)
+			responses := make(chan Response, 1)
+			requests <- Request{Data: req, ResponseChannel: responses}
+			test.ErrorIs(t, (<-responses).Err, WriteFailedError)
+
+			statistics := manager.Statistics()
+			test.EqOp(t, 1, statistics.Attempts)
+			test.EqOp(t, 1, statistics.WriteErrors)
+			test.EqOp(t, 0, statistics.OtherErrors)
+		})
+	}
+}
+
+func TestManagerStatisticsQueueDepth(t *testing.T) {
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{Port: DefaultPortOptions("bus1"), QueueSize: 2})
+	must.NoError(t, err)
+
+	req, err := encoding.NewReadRequest(1, 2)
+	must.NoError(t, err)
+	requests <- Request{Data: req}
+	requests <- Request{Data: req}
+
+	statistics := manager.Statistics()
+	test.EqOp(t, Closed, statistics.State)
+	test.EqOp(t, 2, statistics.QueueDepth)
+	test.EqOp(t, 0, statistics.Requests)
+
+	_, _, err = NewSerialManagerWithOptions(ManagerOptions{Port: DefaultPortOptions("bus1"), QueueSize: -1})
+	test.ErrorContains(t, err, "queue size of port bus1 must not be negative")
+}
@@ -0,0 +1,84 @@
+package serial
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"go.bug.st/serial"
+)
+
+const (
+	// TCP_SCHEME is the URL scheme of ports forwarded as raw bytes over TCP (e.g. tcp://ser2net.local:4001)
+	TCP_SCHEME = "tcp://"
+	// RFC2217_SCHEME is the URL scheme of ports accessed using RFC 2217 (e.g. rfc2217://gateway.local:4001)
+	RFC2217_SCHEME = "rfc2217://"
+	// DIAL_TIMEOUT is the time connecting to a remote port may take
+	DIAL_TIMEOUT = 5 * time.Second
+)
+
+// tcpPort is a Transport forwarding the data as raw bytes over a TCP connection
+// (e.g. to a ser2net box or an Ethernet-RS485 gateway).
+// The line parameters have to be set on the remote side and the modem control lines are not available.
+// It is not a DirectionControl, so RTS can't be enabled in the PortOptions of a raw TCP port.
+type tcpPort struct {
+	conn        net.Conn
+	readTimeout time.Duration
+}
+
+// openTCPPort connects to the raw TCP port with the given address (host:port)
+func openTCPPort(address string) (*tcpPort, error) {
+	conn, err := net.DialTimeout("tcp", address, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, merry.Prependf(err, "Failed to connect to %s", address)
+	}
+	return &tcpPort{conn: conn, readTimeout: serial.NoTimeout}, nil
+}
+
+// Read reads from the connection. Like a serial port, it returns 0 bytes without an error after the read timeout.
+func (port *tcpPort) Read(p []byte) (int, error) {
+	deadline := time.Time{}
+	if port.readTimeout != serial.NoTimeout {
+		deadline = time.Now().Add(port.readTimeout)
+	}
+	if err := port.conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := port.conn.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, nil
+	}
+	return n, err
+}
+
+func (port *tcpPort) Write(p []byte) (int, error) {
+	return port.conn.Write(p)
+}
+
+// ResetInputBuffer discards the data already received
+func (port *tcpPort) ResetInputBuffer() error {
+	var discard [256]byte
+	for {
+		if err := port.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+			return err
+		}
+		n, err := port.conn.Read(discard[:])
+		if errors.Is(err, os.ErrDeadlineExceeded) || (err == nil && n == 0) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (port *tcpPort) SetReadTimeout(timeout time.Duration) error {
+	port.readTimeout = timeout
+	return nil
+}
+
+func (port *tcpPort) Close() error {
+	return port.conn.Close()
+}
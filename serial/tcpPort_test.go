@@ -0,0 +1,218 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+)
+
+// startStandIn listens on a local TCP port standing in for a remote serial port.
+// The first connection is passed to handle and the bytes received by then are sent on the returned channel.
+func startStandIn(t *testing.T, handle func(conn net.Conn) []byte) (string, <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		received <- handle(conn)
+	}()
+	return listener.Addr().String(), received
+}
+
+// readUntil reads from the connection until the received bytes contain the given data or a second has passed
+func readUntil(conn net.Conn, received []byte, data []byte) []byte {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var buffer [256]byte
+	for !bytes.Contains(received, data) {
+		n, err := conn.Read(buffer[:])
+		received = append(received, buffer[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	return received
+}
+
+func openRemoteSerial(t *testing.T, spec string) Serial {
+	options, err := ParsePortOptions(spec)
+	must.NoError(t, err)
+	serial, err := NewSerial()
+	must.NoError(t, err)
+	must.NoError(t, serial.Open(options))
+	t.Cleanup(func() { serial.Close() })
+	return serial
+}
+
+func TestSendRequestTCP(t *testing.T) {
+	address, received := startStandIn(t, func(conn net.Conn) []byte {
+		request := readUntil(conn, nil, []byte("\r"))
+		conn.Write([]byte("\n111lW#222333\r"))
+		return request
+	})
+	serial := openRemoteSerial(t, TCP_SCHEME+address)
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 333, resp.Value())
+	test.EqOp(t, "\n111lW222\r", string(<-received))
+}
+
+func TestSendRequestTCPNoResponse(t *testing.T) {
+	address, _ := startStandIn(t, func(conn net.Conn) []byte {
+		// The connection stays open without answering until the client closes it
+		return readUntil(conn, nil, []byte("never sent"))
+	})
+	serial := openRemoteSerial(t, TCP_SCHEME+address+"?responseTimeout=30ms")
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	_, err = serial.SendRequest(context.Background(), req)
+	test.ErrorIs(t, err, NoDataOnSerialError)
+}
+
+func TestOpenTCPPortFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	address := listener.Addr().String()
+	must.NoError(t, listener.Close())
+
+	options := DefaultPortOptions(TCP_SCHEME + address)
+	serial, err := NewSerial()
+	must.NoError(t, err)
+	test.ErrorContains(t, serial.Open(options), "Failed to connect to "+address)
+}
+
+func TestOpenRemotePortWithRTS(t *testing.T) {
+	for _, scheme := range []string{TCP_SCHEME, RFC2217_SCHEME} {
+		t.Run(scheme, func(t *testing.T) {
+			address, _ := startStandIn(t, func(conn net.Conn) []byte {
+				return readUntil(conn, nil, []byte("never sent"))
+			})
+			options, err := ParsePortOptions(scheme + address + "?rts=true")
+			must.NoError(t, err)
+			serial, err := NewSerial()
+			must.NoError(t, err)
+			test.ErrorContains(t, serial.Open(options), "cannot control RTS")
+		})
+	}
+}
+
+func TestTCPPortReadTimeout(t *testing.T) {
+	address, _ := startStandIn(t, func(conn net.Conn) []byte {
+		return readUntil(conn, nil, []byte("\r"))
+	})
+	port, err := openTCPPort(address)
+	must.NoError(t, err)
+	defer port.Close()
+	must.NoError(t, port.SetReadTimeout(10*time.Millisecond))
+
+	var buffer [16]byte
+	start := time.Now()
+	n, err := port.Read(buffer[:])
+	must.NoError(t, err)
+	test.Zero(t, n)
+	test.GreaterEq(t, 10*time.Millisecond, time.Since(start))
+}
+
+func TestSendRequestRFC2217(t *testing.T) {
+	address, received := startStandIn(t, func(conn net.Conn) []byte {
+		request := readUntil(conn, nil, []byte("\r"))
+		// The response is interleaved with the server's acknowledgement and an option the client has to refuse
+		conn.Write([]byte{telnetIAC, telnetDO, telnetOptionComPort, telnetIAC, telnetSB, telnetOptionComPort, 101, 0, 0, 0x4b, 0, telnetIAC, telnetSE})
+		conn.Write([]byte("\n111lW#222"))
+		conn.Write([]byte{telnetIAC, telnetDO, 24})
+		conn.Write([]byte("333\r"))
+		return readUntil(conn, request, []byte{telnetIAC, telnetWONT, 24})
+	})
+	serial := openRemoteSerial(t, RFC2217_SCHEME+address+"?baud=19200&parity=none&stopBits=2")
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 333, resp.Value())
+	must.NoError(t, serial.Close())
+
+	data := <-received
+	expected := [][]byte{
+		{telnetIAC, telnetWILL, telnetOptionComPort},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetBaudRate, 0, 0, 0x4b, 0, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetDataSize, 8, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetParity, 1, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetStopSize, 2, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortPurgeData, comPortPurgeReceive, telnetIAC, telnetSE},
+		[]byte("\n111lW222\r"),
+		{telnetIAC, telnetWONT, 24},
+	}
+	for _, e := range expected {
+		test.True(t, bytes.Contains(data, e), test.Sprintf("expected %v in %v", e, data))
+	}
+}
+
+func TestRFC2217PortEscapesIAC(t *testing.T) {
+	address, received := startStandIn(t, func(conn net.Conn) []byte {
+		data := readUntil(conn, nil, []byte{1, telnetIAC, telnetIAC, 2})
+		conn.Write([]byte{3, telnetIAC, telnetIAC, 4})
+		return data
+	})
+	port, err := openRFC2217Port(address, DefaultPortOptions("").mode())
+	must.NoError(t, err)
+	defer port.Close()
+	must.NoError(t, port.SetReadTimeout(time.Second))
+
+	n, err := port.Write([]byte{1, telnetIAC, 2})
+	must.NoError(t, err)
+	test.EqOp(t, 3, n)
+
+	var buffer [16]byte
+	n, err = port.Read(buffer[:])
+	must.NoError(t, err)
+	test.Eq(t, []byte{3, telnetIAC, 4}, buffer[:n])
+	test.True(t, bytes.HasSuffix(<-received, []byte{1, telnetIAC, telnetIAC, 2}))
+}
+
+func TestRFC2217PortResetInputBuffer(t *testing.T) {
+	// Each write on a pipe is received by a separate read
+	client, server := net.Pipe()
+	port := &rfc2217Port{tcpPort: tcpPort{conn: client, readTimeout: time.Second}}
+	defer port.Close()
+	reset := make(chan bool)
+	go func() {
+		defer server.Close()
+		readUntil(server, nil, []byte{telnetIAC, telnetSB, telnetOptionComPort, comPortPurgeData, comPortPurgeReceive, telnetIAC, telnetSE})
+		// The acknowledgement of the purge is followed by data received by the remote port before purging
+		server.Write([]byte{telnetIAC, telnetSB, telnetOptionComPort, comPortPurgeData + 100, comPortPurgeReceive, telnetIAC, telnetSE})
+		server.Write([]byte("stale"))
+		<-reset
+		server.Write([]byte("fresh"))
+	}()
+
+	start := time.Now()
+	must.NoError(t, port.ResetInputBuffer())
+	// Reading is not bounded by the read timeout of the port
+	test.Less(t, 500*time.Millisecond, time.Since(start))
+	close(reset)
+
+	var buffer [16]byte
+	n, err := port.Read(buffer[:])
+	must.NoError(t, err)
+	test.EqOp(t, "fresh", string(buffer[:n]))
+}
@@ -0,0 +1,168 @@
+package serial
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Transport is the connection a Serial exchanges frames over.
+// A serial.Port is a Transport, as are the remote ports (see TCP_SCHEME and RFC2217_SCHEME) and the ends of a NewPipe.
+type Transport interface {
+	io.ReadWriteCloser
+	// SetReadTimeout sets the time a Read waits for data. Once it has passed, Read returns 0 bytes without an error.
+	// serial.NoTimeout makes Read wait until data has been received.
+	SetReadTimeout(timeout time.Duration) error
+	// ResetInputBuffer discards the data received but not read yet
+	ResetInputBuffer() error
+}
+
+// DirectionControl is implemented by transports that can switch an RS485 transceiver using the RTS line.
+// It is required by PortOptions with RTS enabled.
+type DirectionControl interface {
+	// SetRTS asserts or deasserts the RTS line
+	SetRTS(rts bool) error
+	// Drain waits until the written data has been transmitted
+	Drain() error
+}
+
+// TransportOpener opens the transport with the given port name using the given line parameters
+type TransportOpener func(portName string, mode *serial.Mode) (Transport, error)
+
+// OpenTransport opens the port with the given name.
+// Names starting with TCP_SCHEME or RFC2217_SCHEME are remote ports, all others local serial ports.
+func OpenTransport(portName string, mode *serial.Mode) (Transport, error) {
+	var transport Transport
+	var err error
+	switch {
+	case strings.HasPrefix(portName, TCP_SCHEME):
+		transport, err = openTCPPort(strings.TrimPrefix(portName, TCP_SCHEME))
+	case strings.HasPrefix(portName, RFC2217_SCHEME):
+		transport, err = openRFC2217Port(strings.TrimPrefix(portName, RFC2217_SCHEME), mode)
+	default:
+		transport, err = serial.Open(portName, mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// NewPipe creates an in-memory, full-duplex transport. Data written to one end can be read from the other one.
+// Unlike a net.Pipe, writes do not wait for the data to be read.
+// It connects a Serial (e.g. created by NewSerialForTransport) to a simulated device in tests:
+//
+//	host, device := NewPipe()
+//	serial, err := NewSerialForTransport(encoding.DefaultDialect, func(string, *serial.Mode) (Transport, error) {
+//		return host, nil
+//	})
+//
+// The simulated device then reads the requests from and writes its responses to device.
+// Closing one end makes reads from the other one return io.EOF once the data written before has been read.
+func NewPipe() (Transport, Transport) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeEnd{in: a, out: b, readTimeout: serial.NoTimeout}, &pipeEnd{in: b, out: a, readTimeout: serial.NoTimeout}
+}
+
+// pipeBuffer holds the data written to one direction of a pipe
+type pipeBuffer struct {
+	mutex  sync.Mutex
+	data   []byte
+	closed bool
+	// changed is closed and replaced when data is written or the buffer is closed
+	changed chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{changed: make(chan struct{})}
+}
+
+// notify wakes up all readers waiting for data. The mutex must be held.
+func (buffer *pipeBuffer) notify() {
+	close(buffer.changed)
+	buffer.changed = make(chan struct{})
+}
+
+func (buffer *pipeBuffer) close() {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if !buffer.closed {
+		buffer.closed = true
+		buffer.notify()
+	}
+}
+
+// pipeEnd is one end of a pipe created by NewPipe
+type pipeEnd struct {
+	// in holds the data written to the other end
+	in *pipeBuffer
+	// out holds the data written to this end
+	out         *pipeBuffer
+	readTimeout time.Duration
+}
+
+// Read reads the data written to the other end.
+// Like a serial port, it returns 0 bytes without an error after the read timeout.
+func (end *pipeEnd) Read(p []byte) (int, error) {
+	var timeout <-chan time.Time
+	if end.readTimeout != serial.NoTimeout {
+		timer := time.NewTimer(end.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		end.in.mutex.Lock()
+		if len(end.in.data) > 0 {
+			n := copy(p, end.in.data)
+			end.in.data = end.in.data[n:]
+			end.in.mutex.Unlock()
+			return n, nil
+		}
+		if end.in.closed {
+			end.in.mutex.Unlock()
+			return 0, io.EOF
+		}
+		changed := end.in.changed
+		end.in.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return 0, nil
+		}
+	}
+}
+
+// Write makes the given data available to the other end
+func (end *pipeEnd) Write(p []byte) (int, error) {
+	end.out.mutex.Lock()
+	defer end.out.mutex.Unlock()
+	if end.out.closed {
+		return 0, io.ErrClosedPipe
+	}
+	end.out.data = append(end.out.data, p...)
+	end.out.notify()
+	return len(p), nil
+}
+
+func (end *pipeEnd) SetReadTimeout(timeout time.Duration) error {
+	end.readTimeout = timeout
+	return nil
+}
+
+func (end *pipeEnd) ResetInputBuffer() error {
+	end.in.mutex.Lock()
+	defer end.in.mutex.Unlock()
+	end.in.data = nil
+	return nil
+}
+
+// Close closes both directions of the pipe
+func (end *pipeEnd) Close() error {
+	end.out.close()
+	end.in.close()
+	return nil
+}
@@ -0,0 +1,163 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test"
+	"github.com/shoenig/test/must"
+	"github.com/ventcon/ventcon-hwio/encoding"
+	"go.bug.st/serial"
+)
+
+// simulateDevice answers all read requests received on the given transport with the given value until it is closed
+func simulateDevice(t *testing.T, device Transport, value int) {
+	simulateBus(t, device, func(encoder encoding.SerialEncoder, request encoding.Frame) (string, error) {
+		response, err := encoding.NewReadResponse(request.Address(), request.Function(), value)
+		if err != nil {
+			return "", err
+		}
+		return encoder.Encode(response)
+	})
+}
+
+// simulateBus answers the requests received on the given transport with the encoded response returned by respond
+// until it is closed. Requests for which respond returns an empty response are not answered.
+func simulateBus(t *testing.T, device Transport, respond func(encoder encoding.SerialEncoder, request encoding.Frame) (string, error)) {
+	encoder, err := encoding.NewSerialEncoder()
+	must.NoError(t, err)
+	go func() {
+		var received []byte
+		var buffer [64]byte
+		for {
+			n, err := device.Read(buffer[:])
+			if err != nil {
+				return
+			}
+			received = append(received, buffer[:n]...)
+			end := bytes.IndexByte(received, encoding.DefaultDialect.EndChar)
+			if end < 0 {
+				continue
+			}
+			request, err := encoder.DecodeRequestBytes(received[:end+1])
+			received = received[end+1:]
+			if err != nil {
+				continue
+			}
+			response, err := respond(encoder, request)
+			if err != nil || response == "" {
+				continue
+			}
+			device.Write([]byte(response))
+		}
+	}()
+}
+
+func pipeOpener(transport Transport) TransportOpener {
+	return func(portName string, mode *serial.Mode) (Transport, error) {
+		return transport, nil
+	}
+}
+
+func TestPipe(t *testing.T) {
+	a, b := NewPipe()
+
+	n, err := a.Write([]byte("hello"))
+	must.NoError(t, err)
+	test.EqOp(t, 5, n)
+
+	var buffer [3]byte
+	n, err = b.Read(buffer[:])
+	must.NoError(t, err)
+	test.EqOp(t, "hel", string(buffer[:n]))
+	n, err = b.Read(buffer[:])
+	must.NoError(t, err)
+	test.EqOp(t, "lo", string(buffer[:n]))
+
+	// Reads wait for data written later
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		b.Write([]byte("!"))
+	}()
+	n, err = a.Read(buffer[:])
+	must.NoError(t, err)
+	test.EqOp(t, "!", string(buffer[:n]))
+
+	// ResetInputBuffer discards the data not read yet
+	a.Write([]byte("stale"))
+	must.NoError(t, b.ResetInputBuffer())
+	must.NoError(t, b.SetReadTimeout(10*time.Millisecond))
+	start := time.Now()
+	n, err = b.Read(buffer[:])
+	must.NoError(t, err)
+	test.Zero(t, n)
+	test.GreaterEq(t, 10*time.Millisecond, time.Since(start))
+
+	// The data written before closing can still be read
+	a.Write([]byte("bye"))
+	must.NoError(t, a.Close())
+	n, err = b.Read(buffer[:])
+	must.NoError(t, err)
+	test.EqOp(t, "bye", string(buffer[:n]))
+	_, err = b.Read(buffer[:])
+	test.ErrorIs(t, err, io.EOF)
+	_, err = b.Write([]byte("x"))
+	test.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestSendRequestOverPipe(t *testing.T) {
+	host, device := NewPipe()
+	simulateDevice(t, device, 333)
+
+	serial, err := NewSerialForTransport(encoding.DefaultDialect, pipeOpener(host))
+	must.NoError(t, err)
+	must.NoError(t, serial.Open(DefaultPortOptions("simulated")))
+	defer serial.Close()
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+
+	resp, err := serial.SendRequest(context.Background(), req)
+	must.NoError(t, err)
+	test.EqOp(t, 111, resp.Address())
+	test.EqOp(t, 333, resp.Value())
+}
+
+func TestOpenRTSWithoutDirectionControl(t *testing.T) {
+	host, _ := NewPipe()
+	serial, err := NewSerialForTransport(encoding.DefaultDialect, pipeOpener(host))
+	must.NoError(t, err)
+
+	options := DefaultPortOptions("simulated")
+	options.RTS = true
+	test.ErrorContains(t, serial.Open(options), "cannot control RTS")
+
+	// The transport has been closed
+	_, err = host.Write([]byte("x"))
+	test.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestRunOverPipe(t *testing.T) {
+	host, device := NewPipe()
+	simulateDevice(t, device, 333)
+
+	manager, requests, err := NewSerialManagerWithOptions(ManagerOptions{
+		Port:      DefaultPortOptions("simulated"),
+		Transport: pipeOpener(host),
+	})
+	must.NoError(t, err)
+	must.NoError(t, manager.Start())
+	defer manager.Stop()
+
+	req, err := encoding.NewReadRequest(111, 222)
+	must.NoError(t, err)
+	responses := make(chan Response, 1)
+	requests <- Request{Data: req, ResponseChannel: responses}
+
+	resp := <-responses
+	must.NoError(t, resp.Err)
+	test.EqOp(t, 333, resp.Response.Value())
+}
@@ -2,7 +2,14 @@
 package main
 
 import (
+	"context"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+
 	log "github.com/sirupsen/logrus"
+	"github.com/ventcon/ventcon-hwio/serial"
 )
 
 // main is the main entrypoint of this project
@@ -18,4 +25,58 @@ func main() {
 	configureLogging(config)
 
 	log.WithField("variables", vars).Info("This software is configured using environment variables.")
+
+	functionCatalog := config.Catalog.OrDefault()
+	log.WithField("catalog", functionCatalog.Name()).WithField("functions", len(functionCatalog.Functions())).Info("Loaded function catalog.")
+
+	if log.IsLevelEnabled(log.DebugLevel) {
+		logAvailablePorts()
+	}
+	for _, port := range config.Ports {
+		log.WithField("port", serial.PortOptions(port).String()).Info("Configured serial port.")
+	}
+	for _, address := range slices.Sorted(maps.Keys(config.Routes)) {
+		log.WithField("address", address).WithField("port", config.Routes[address]).Info("Configured route.")
+	}
+	retryPolicy := config.Retry.Policy()
+	log.WithField("maxAttempts", retryPolicy.MaxAttempts).WithField("retryOn", retryPolicy.RetryOn).WithField("writeRetry", retryPolicy.WriteRetry).Info("Configured retry policy.")
+	reconnectPolicy := config.Reconnect.Policy()
+	log.WithField("enabled", reconnectPolicy.Enabled).WithField("followByID", reconnectPolicy.FollowByID).WithField("pending", reconnectPolicy.Pending).Info("Configured reconnect policy.")
+
+	if len(os.Args) > 1 {
+		runCommand(config, os.Args[1], os.Args[2:])
+	}
+}
+
+// runCommand runs the subcommand with the given name and arguments
+func runCommand(config Config, command string, args []string) {
+	switch command {
+	case SCAN_COMMAND:
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := runScan(ctx, config, args, os.Stdout); err != nil {
+			log.WithError(err).Fatal("Failed to scan the buses.")
+		}
+	default:
+		log.WithField("command", command).Fatalf("Unknown command. Known commands are: %s", SCAN_COMMAND)
+	}
+}
+
+// logAvailablePorts logs the serial ports of the system with their USB metadata to help writing usb:// port names
+func logAvailablePorts() {
+	ports, err := serial.ListPorts()
+	if err != nil {
+		log.WithError(err).Debug("Failed to list the available serial ports.")
+		return
+	}
+	for _, port := range ports {
+		log.WithFields(log.Fields{
+			"name":         port.Name,
+			"usb":          port.IsUSB,
+			"vid":          port.VID,
+			"pid":          port.PID,
+			"serialNumber": port.SerialNumber,
+			"product":      port.Product,
+		}).Debug("Found serial port.")
+	}
 }